@@ -0,0 +1,45 @@
+// Command migrationvet runs the migrationvet analyzer on its own or as a vet
+// tool:
+//
+//	migrationvet ./...
+//	go vet -vettool=$(which migrationvet) -baseline=$PWD/migrations.sum ./...
+//
+// "migrationvet record" adds the migrations of the matching packages to a
+// baseline, keeping the checksums already recorded:
+//
+//	migrationvet record -baseline migrations.sum ./...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/pechorka/migrations/analysis/migrationvet"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		os.Exit(record(os.Args[2:]))
+	}
+	singlechecker.Main(migrationvet.Analyzer)
+}
+
+func record(args []string) int {
+	flags := flag.NewFlagSet("migrationvet record", flag.ExitOnError)
+	path := flags.String("baseline", "migrations.sum", "baseline file to add the migrations to")
+	flags.Parse(args)
+	patterns := flags.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	added, err := migrationvet.Record(*path, patterns...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrationvet:", err)
+		return 1
+	}
+	fmt.Printf("migrationvet: recorded %d migrations in %s\n", added, *path)
+	return 0
+}
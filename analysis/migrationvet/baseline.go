@@ -0,0 +1,143 @@
+package migrationvet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// baseline maps a list name to the checksums of its migrations by version.
+//
+// The file holds one migration per line, "<list> <version> <sha256>", where
+// list is "<package path>.<variable>" for package-level variables and
+// "<package path>.<function>.<variable>" for local ones. Blank lines and lines
+// starting with "#" are ignored.
+type baseline map[string]map[int]string
+
+func readBaseline(path string) (baseline, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration baseline: %w", err)
+	}
+	b := baseline{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("migration baseline %s:%d: want \"<list> <version> <sha256>\", got %q", path, n, line)
+		}
+		version, err := strconv.Atoi(fields[1])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration baseline %s:%d: invalid version %q", path, n, fields[1])
+		}
+		b.add(fields[0], version, fields[2])
+	}
+	return b, scanner.Err()
+}
+
+func (b baseline) add(list string, version int, sum string) bool {
+	if b[list] == nil {
+		b[list] = map[int]string{}
+	}
+	if _, ok := b[list][version]; ok {
+		return false
+	}
+	b[list][version] = sum
+	return true
+}
+
+func (b baseline) format() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Checksums of applied migrations, see \"migrationvet record\".\n")
+	lists := make([]string, 0, len(b))
+	for list := range b {
+		lists = append(lists, list)
+	}
+	slices.Sort(lists)
+	for _, list := range lists {
+		versions := make([]int, 0, len(b[list]))
+		for version := range b[list] {
+			versions = append(versions, version)
+		}
+		slices.Sort(versions)
+		for _, version := range versions {
+			fmt.Fprintf(&buf, "%s %d %s\n", list, version, b[list][version])
+		}
+	}
+	return buf.Bytes()
+}
+
+// checksum returns the hex-encoded SHA-256 of a migration, like the checksums
+// recorded by migrations.WithChecksums.
+func checksum(migration string) string {
+	sum := sha256.Sum256([]byte(migration))
+	return hex.EncodeToString(sum[:])
+}
+
+// Record adds the migrations of the lists in the packages matching patterns
+// to the baseline file at path, creating it when needed, and returns how many
+// it added. Migrations already in the baseline keep their checksum, so an
+// edited migration stays reported until its line is deleted on purpose.
+// Record is meant to run after a release, once the migrations are applied
+// somewhere and must no longer change.
+func Record(path string, patterns ...string) (int, error) {
+	return record(&packages.Config{}, path, patterns)
+}
+
+func record(cfg *packages.Config, path string, patterns []string) (int, error) {
+	cfg.Mode |= packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load packages: %w", err)
+	}
+	var loadErrs []error
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			loadErrs = append(loadErrs, err)
+		}
+	})
+	if len(loadErrs) > 0 {
+		return 0, fmt.Errorf("failed to load packages: %w", errors.Join(loadErrs...))
+	}
+
+	b, err := readBaseline(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		b, err = baseline{}, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	ignore := func(pos token.Pos, format string, args ...any) {}
+	for _, pkg := range pkgs {
+		for _, l := range collect(pkg.PkgPath, pkg.Syntax, pkg.TypesInfo, ignore).lists {
+			if l.name == "" {
+				continue
+			}
+			for i, m := range l.migrations {
+				if m.known && b.add(l.name, i+1, checksum(m.sql)) {
+					added++
+				}
+			}
+		}
+	}
+	if err := os.WriteFile(path, b.format(), 0o644); err != nil {
+		return 0, fmt.Errorf("failed to write migration baseline: %w", err)
+	}
+	return added, nil
+}
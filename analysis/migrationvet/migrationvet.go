@@ -0,0 +1,490 @@
+// Package migrationvet defines an Analyzer checking migration lists written as
+// Go literals and the calls applying them. It reports:
+//
+//   - a []migrations.Migration element whose Version is not its position, and
+//     a []string literal whose index keys leave a gap (versions are
+//     positional, so a gap applies empty migrations);
+//   - an ApplyTo or RollbackTo target beyond the end of a literal list;
+//   - a call of the migrations package without WithDialect, which silently
+//     uses SQLite, unless the package sets a dialect with SetDefaultOptions;
+//   - with -baseline, a migration whose SQL no longer matches the checksum
+//     recorded for it, and a recorded migration removed from its list.
+//
+// Lists are the []string and []migrations.Migration literals passed to the
+// migrations package in the same package, every []migrations.Migration
+// literal, and package-level []string variables with "migration" in their
+// name, e.g. a shared "var Migrations = []string{...}". Only constant strings
+// are checked against the baseline; SQL loaded at run time is left alone.
+//
+// The analyzer runs with go vet:
+//
+//	go install github.com/pechorka/migrations/analysis/cmd/migrationvet@latest
+//	go vet -vettool=$(which migrationvet) ./...
+//
+// and with any other driver of analysis.Analyzers, such as golangci-lint.
+package migrationvet
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const migrationsPath = "github.com/pechorka/migrations"
+
+// Analyzer reports migration hygiene issues, see the package documentation.
+var Analyzer = &analysis.Analyzer{
+	Name: "migrationvet",
+	Doc:  "check migration lists and the calls applying them",
+	URL:  "https://pkg.go.dev/github.com/pechorka/migrations/analysis/migrationvet",
+	Run:  run,
+}
+
+var baselinePath string
+
+func init() {
+	Analyzer.Flags.StringVar(&baselinePath, "baseline", "", "file holding the checksums recorded by \"migrationvet record\"; migrations differing from it are reported")
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	var recorded baseline
+	if baselinePath != "" {
+		var err error
+		if recorded, err = readBaseline(baselinePath); err != nil {
+			return nil, err
+		}
+	}
+	pkg := collect(pass.Pkg.Path(), pass.Files, pass.TypesInfo, pass.Reportf)
+	for _, l := range pkg.lists {
+		if l.name != "" {
+			checkBaseline(pass, l, recorded[l.name])
+		}
+	}
+	for _, c := range pkg.calls {
+		checkTarget(pass, pkg, c)
+		if !pkg.defaultDialect {
+			checkDialect(pass, c)
+		}
+	}
+	return nil, nil
+}
+
+// list is a migration list written as a composite literal.
+type list struct {
+	// name identifies the list in the baseline; empty for inline literals.
+	name       string
+	lit        *ast.CompositeLit
+	migrations []migration
+}
+
+// migration is an element of a list.
+type migration struct {
+	node ast.Node
+	sql  string
+	// known reports whether sql is a constant.
+	known bool
+}
+
+// call is a call of a function of the migrations package.
+type call struct {
+	expr *ast.CallExpr
+	fn   *types.Func
+	// list is the argument holding the migrations, nil when there is none.
+	list ast.Expr
+	// target is the ApplyTo or RollbackTo version argument.
+	target ast.Expr
+}
+
+type pkgInfo struct {
+	lists []*list
+	// byObj holds the lists assigned to variables that are never reassigned.
+	byObj  map[types.Object]*list
+	inline map[*ast.CompositeLit]*list
+	calls  []call
+	// defaultDialect reports whether the package may set a dialect with
+	// SetDefaultOptions.
+	defaultDialect bool
+}
+
+// collect finds the lists and calls of a package. Positional problems of the
+// lists are passed to report.
+func collect(pkgPath string, files []*ast.File, info *types.Info, report func(token.Pos, string, ...any)) *pkgInfo {
+	p := &pkgInfo{byObj: map[types.Object]*list{}, inline: map[*ast.CompositeLit]*list{}}
+	passed := map[types.Object]bool{}
+	assigned := map[types.Object]int{}
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				c, ok := migrationsCall(info, n)
+				if !ok {
+					return true
+				}
+				if c.fn.Name() == "SetDefaultOptions" && setsDialect(info, n.Args) {
+					p.defaultDialect = true
+				}
+				p.calls = append(p.calls, c)
+				switch arg := ast.Unparen(c.list).(type) {
+				case *ast.Ident:
+					passed[info.Uses[arg]] = true
+				case *ast.CompositeLit:
+					l := &list{lit: arg, migrations: parseList(info, arg, report)}
+					p.lists = append(p.lists, l)
+					p.inline[arg] = l
+				}
+			case *ast.AssignStmt:
+				for _, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && info.Uses[id] != nil {
+						assigned[info.Uses[id]]++
+					}
+				}
+			case *ast.UnaryExpr:
+				if id, ok := n.X.(*ast.Ident); ok && n.Op == token.AND && info.Uses[id] != nil {
+					assigned[info.Uses[id]]++
+				}
+			}
+			return true
+		})
+	}
+
+	add := func(id *ast.Ident, value ast.Expr, scope string) {
+		lit, ok := ast.Unparen(value).(*ast.CompositeLit)
+		obj := info.ObjectOf(id)
+		if !ok || obj == nil || !isList(obj.Type()) {
+			return
+		}
+		packageLevel := scope == ""
+		if !passed[obj] && !isMigrationSlice(obj.Type()) &&
+			!(packageLevel && strings.Contains(strings.ToLower(id.Name), "migration")) {
+			return
+		}
+		name := pkgPath + "." + id.Name
+		if !packageLevel {
+			name = pkgPath + "." + scope + "." + id.Name
+		}
+		l := &list{name: name, lit: lit, migrations: parseList(info, lit, report)}
+		p.lists = append(p.lists, l)
+		if info.Defs[id] != nil {
+			p.byObj[obj] = l
+		}
+	}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				forEachValue(decl, func(id *ast.Ident, value ast.Expr) { add(id, value, "") })
+			case *ast.FuncDecl:
+				if decl.Body == nil {
+					continue
+				}
+				scope := funcName(decl)
+				ast.Inspect(decl.Body, func(n ast.Node) bool {
+					switch n := n.(type) {
+					case *ast.DeclStmt:
+						if gen, ok := n.Decl.(*ast.GenDecl); ok {
+							forEachValue(gen, func(id *ast.Ident, value ast.Expr) { add(id, value, scope) })
+						}
+					case *ast.AssignStmt:
+						if n.Tok == token.DEFINE && len(n.Lhs) == len(n.Rhs) {
+							for i, lhs := range n.Lhs {
+								if id, ok := lhs.(*ast.Ident); ok && info.Defs[id] != nil {
+									add(id, n.Rhs[i], scope)
+								}
+							}
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+	for obj := range assigned {
+		delete(p.byObj, obj)
+	}
+	return p
+}
+
+// migrationsCall reports whether call calls a function of the migrations
+// package and finds its list and target arguments.
+func migrationsCall(info *types.Info, expr *ast.CallExpr) (call, bool) {
+	fn, ok := typeutil.Callee(info, expr).(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != migrationsPath {
+		return call{}, false
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() != nil {
+		return call{}, false
+	}
+	c := call{expr: expr, fn: fn}
+	params := sig.Params()
+	for i := 0; i < params.Len() && i < len(expr.Args); i++ {
+		if sig.Variadic() && i == params.Len()-1 {
+			break
+		}
+		param := params.At(i)
+		switch {
+		case c.list == nil && isList(param.Type()):
+			c.list = expr.Args[i]
+		case (param.Name() == "target" || param.Name() == "version") && types.Identical(param.Type(), types.Typ[types.Int]):
+			c.target = expr.Args[i]
+		}
+	}
+	return c, true
+}
+
+// parseList returns the migrations of lit in version order.
+func parseList(info *types.Info, lit *ast.CompositeLit, report func(token.Pos, string, ...any)) []migration {
+	byIndex := map[int]migration{}
+	next, last := 0, -1
+	for _, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if index, ok := constInt(info, kv.Key); ok {
+				next = int(index)
+			}
+			elt = kv.Value
+		}
+		m := migration{node: elt}
+		if inner, ok := ast.Unparen(elt).(*ast.CompositeLit); ok {
+			m.sql, m.known = migrationSQL(info, inner, next+1, report)
+		} else {
+			m.sql, m.known = constString(info, elt)
+		}
+		byIndex[next] = m
+		last = max(last, next)
+		next++
+	}
+
+	migrations := make([]migration, last+1)
+	gap := -1
+	for i := range migrations {
+		m, ok := byIndex[i]
+		if ok {
+			migrations[i] = m
+			if gap >= 0 {
+				reportGap(lit, gap, i-1, report)
+				gap = -1
+			}
+			continue
+		}
+		// The zero value of a missing element is an empty migration.
+		migrations[i] = migration{node: lit, known: true}
+		if gap < 0 {
+			gap = i
+		}
+	}
+	return migrations
+}
+
+func reportGap(lit *ast.CompositeLit, from, to int, report func(token.Pos, string, ...any)) {
+	if from == to {
+		report(lit.Pos(), "migration list has no version %d: versions are positional, so it applies an empty migration", from+1)
+		return
+	}
+	report(lit.Pos(), "migration list has no versions %d to %d: versions are positional, so they apply empty migrations", from+1, to+1)
+}
+
+// migrationSQL returns the SQL of a migrations.Migration literal at version
+// and reports a Version other than version.
+func migrationSQL(info *types.Info, lit *ast.CompositeLit, version int, report func(token.Pos, string, ...any)) (string, bool) {
+	var (
+		up         string
+		known      bool
+		statements []string
+		hasStmts   bool
+	)
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return "", false
+		}
+		field, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		switch field.Name {
+		case "Version":
+			if v, ok := constInt(info, kv.Value); ok && v != 0 && v != int64(version) {
+				report(kv.Pos(), "migration at position %d has Version %d: versions are positional, use %d or leave it unset", version, v, version)
+			}
+		case "UpSQL":
+			up, known = constString(info, kv.Value)
+		case "Statements":
+			stmts, ok := ast.Unparen(kv.Value).(*ast.CompositeLit)
+			if !ok {
+				return "", false
+			}
+			for _, stmt := range stmts.Elts {
+				s, ok := constString(info, stmt)
+				if !ok {
+					return "", false
+				}
+				statements = append(statements, s)
+			}
+			hasStmts = len(statements) > 0
+		case "Func", "Open", "DialectSQL":
+			return "", false
+		}
+	}
+	if hasStmts {
+		// Like the checksums of the migrations package.
+		return strings.Join(statements, ";\n"), true
+	}
+	return up, known
+}
+
+func checkBaseline(pass *analysis.Pass, l *list, recorded map[int]string) {
+	for version, sum := range recorded {
+		if version > len(l.migrations) {
+			pass.Reportf(l.lit.Pos(), "migration %d of %s was recorded in the baseline but is no longer in the list: applied migrations must not be removed", version, l.name)
+			continue
+		}
+		m := l.migrations[version-1]
+		if m.known && checksum(m.sql) != sum {
+			pass.Reportf(m.node.Pos(), "migration %d of %s differs from the baseline: applied migrations must not be edited, add a new migration instead", version, l.name)
+		}
+	}
+}
+
+func checkTarget(pass *analysis.Pass, p *pkgInfo, c call) {
+	if c.target == nil || c.list == nil {
+		return
+	}
+	target, ok := constInt(pass.TypesInfo, c.target)
+	if !ok {
+		return
+	}
+	var l *list
+	switch arg := ast.Unparen(c.list).(type) {
+	case *ast.Ident:
+		l = p.byObj[pass.TypesInfo.Uses[arg]]
+	case *ast.CompositeLit:
+		l = p.inline[arg]
+	}
+	if l == nil {
+		return
+	}
+	if target < 0 || target > int64(len(l.migrations)) {
+		pass.Reportf(c.target.Pos(), "%s version %d is outside the list of %d migrations", c.fn.Name(), target, len(l.migrations))
+	}
+}
+
+// checkDialect reports a call taking options, none of which can set the
+// dialect. Options it cannot see through, e.g. a variable or a slice passed
+// with "...", are assumed to set it.
+func checkDialect(pass *analysis.Pass, c call) {
+	sig := c.fn.Type().(*types.Signature)
+	if !sig.Variadic() || c.fn.Name() == "SetDefaultOptions" || c.expr.Ellipsis.IsValid() {
+		return
+	}
+	params := sig.Params()
+	if !isOptions(params.At(params.Len() - 1).Type()) {
+		return
+	}
+	for i := range params.Len() {
+		if isNamed(params.At(i).Type(), "Dialect") {
+			// The dialect is an argument, e.g. GenerateScript.
+			return
+		}
+	}
+	if setsDialect(pass.TypesInfo, c.expr.Args[params.Len()-1:]) {
+		return
+	}
+	pass.Reportf(c.expr.Pos(), "migrations.%s without WithDialect uses the sqlite dialect; pass migrations.WithDialect or set it with migrations.SetDefaultOptions", c.fn.Name())
+}
+
+// setsDialect reports whether options may set the dialect.
+func setsDialect(info *types.Info, options []ast.Expr) bool {
+	for _, option := range options {
+		call, ok := ast.Unparen(option).(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		fn, ok := typeutil.Callee(info, call).(*types.Func)
+		if !ok || fn.Pkg() == nil || fn.Pkg().Path() != migrationsPath || fn.Name() == "WithDialect" {
+			return true
+		}
+	}
+	return false
+}
+
+func forEachValue(decl *ast.GenDecl, fn func(id *ast.Ident, value ast.Expr)) {
+	if decl.Tok != token.VAR {
+		return
+	}
+	for _, spec := range decl.Specs {
+		vs := spec.(*ast.ValueSpec)
+		if len(vs.Names) != len(vs.Values) {
+			continue
+		}
+		for i, id := range vs.Names {
+			fn(id, vs.Values[i])
+		}
+	}
+}
+
+func funcName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	recv := decl.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	switch r := recv.(type) {
+	case *ast.IndexExpr:
+		recv = r.X
+	case *ast.IndexListExpr:
+		recv = r.X
+	}
+	if id, ok := recv.(*ast.Ident); ok {
+		return id.Name + "." + decl.Name.Name
+	}
+	return decl.Name.Name
+}
+
+// isList reports whether t is []string or []migrations.Migration.
+func isList(t types.Type) bool {
+	slice, ok := t.Underlying().(*types.Slice)
+	return ok && (types.Identical(slice.Elem(), types.Typ[types.String]) || isNamed(slice.Elem(), "Migration"))
+}
+
+func isMigrationSlice(t types.Type) bool {
+	slice, ok := t.Underlying().(*types.Slice)
+	return ok && isNamed(slice.Elem(), "Migration")
+}
+
+func isOptions(t types.Type) bool {
+	slice, ok := t.(*types.Slice)
+	return ok && isNamed(slice.Elem(), "Option")
+}
+
+// isNamed reports whether t is the named type name of the migrations package.
+func isNamed(t types.Type, name string) bool {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == migrationsPath && obj.Name() == name
+}
+
+func constInt(info *types.Info, expr ast.Expr) (int64, bool) {
+	tv, ok := info.Types[expr]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.Int {
+		return 0, false
+	}
+	return constant.Int64Val(tv.Value)
+}
+
+func constString(info *types.Info, expr ast.Expr) (string, bool) {
+	tv, ok := info.Types[expr]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
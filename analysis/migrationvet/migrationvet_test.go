@@ -0,0 +1,58 @@
+package migrationvet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestAnalyzer(t *testing.T) {
+	testdata := analysistest.TestData()
+	if err := Analyzer.Flags.Set("baseline", filepath.Join(testdata, "baseline.sum")); err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("baseline", "")
+
+	analysistest.Run(t, testdata, Analyzer, "a", "b")
+}
+
+func TestRecord(t *testing.T) {
+	testdata := analysistest.TestData()
+	path := filepath.Join(t.TempDir(), "migrations.sum")
+	// An edited migration keeps its recorded checksum.
+	edited := "a.Migrations 2 0000\n"
+	if err := os.WriteFile(path, []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &packages.Config{Dir: testdata, Env: append(os.Environ(), "GOPATH="+testdata, "GO111MODULE=off", "GOPROXY=off")}
+
+	added, err := record(cfg, path, []string{"a"})
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	b, err := readBaseline(path)
+	if err != nil {
+		t.Fatalf("read baseline: %v", err)
+	}
+	want := baseline{
+		"a.Migrations":  {1: checksum("CREATE TABLE users (id INT)"), 2: "0000"},
+		"a.removed":     {1: checksum("CREATE TABLE a (id INT)"), 2: checksum("CREATE TABLE b (id INT);\nCREATE TABLE c (id INT)")},
+		"a.gaps":        {1: checksum("CREATE TABLE a (id INT)"), 2: checksum(""), 3: checksum(""), 4: checksum("CREATE TABLE d (id INT)")},
+		"a.apply.local": {1: checksum("CREATE TABLE a (id INT)"), 2: checksum("CREATE TABLE b (id INT)")},
+		"a.apply.grown": {1: checksum("CREATE TABLE a (id INT)")},
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Fatalf("got baseline %v, want %v", b, want)
+	}
+	if n := 10; added != n {
+		t.Fatalf("got %d added migrations, want %d", added, n)
+	}
+
+	if added, err := record(cfg, path, []string{"a"}); err != nil || added != 0 {
+		t.Fatalf("recording again: got %d added, err %v", added, err)
+	}
+}
@@ -0,0 +1,49 @@
+package a
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pechorka/migrations"
+)
+
+var Migrations = []string{
+	"CREATE TABLE users (id INT)",
+	"ALTER TABLE users ADD COLUMN email TEXT", // want `migration 2 of a.Migrations differs from the baseline`
+}
+
+var removed = []migrations.Migration{ // want `migration 3 of a.removed was recorded in the baseline but is no longer in the list`
+	{UpSQL: "CREATE TABLE a (id INT)"},
+	{Version: 2, Statements: []string{"CREATE TABLE b (id INT)", "CREATE TABLE c (id INT)"}},
+}
+
+var gaps = []string{ // want `migration list has no versions 2 to 3`
+	0: "CREATE TABLE a (id INT)",
+	3: "CREATE TABLE d (id INT)",
+}
+
+func apply(ctx context.Context, db *sql.DB, opts []migrations.Option, opt migrations.Option) {
+	migrations.Apply(ctx, db, Migrations)                                // want `migrations.Apply without WithDialect uses the sqlite dialect`
+	migrations.Apply(ctx, db, Migrations, migrations.WithTableName("x")) // want `migrations.Apply without WithDialect`
+	migrations.Apply(ctx, db, Migrations, migrations.WithDialect(migrations.DialectPostgres))
+	migrations.Apply(ctx, db, Migrations, opts...)
+	migrations.Apply(ctx, db, Migrations, opt)
+	migrations.GenerateScript(Migrations, 0, migrations.DialectPostgres)
+
+	pg := migrations.WithDialect(migrations.DialectPostgres)
+	migrations.ApplyTo(ctx, db, Migrations, 2, pg)
+	migrations.ApplyTo(ctx, db, Migrations, 3, pg)           // want `ApplyTo version 3 is outside the list of 2 migrations`
+	migrations.ApplyTo(ctx, db, []string{"SELECT 1"}, 2, pg) // want `ApplyTo version 2 is outside the list of 1 migrations`
+	migrations.ApplyTo(ctx, db, gaps, 4, pg)
+
+	local := []migrations.Migration{
+		{UpSQL: "CREATE TABLE a (id INT)"},
+		{Version: 3, UpSQL: "CREATE TABLE b (id INT)"}, // want `migration at position 2 has Version 3: versions are positional, use 2 or leave it unset`
+	}
+	migrations.ApplyMigrations(ctx, db, local, pg)
+	migrations.RollbackTo(ctx, db, local, 5, pg) // want `RollbackTo version 5 is outside the list of 2 migrations`
+
+	grown := []migrations.Migration{{UpSQL: "CREATE TABLE a (id INT)"}}
+	grown = append(grown, removed...)
+	migrations.RollbackTo(ctx, db, grown, 3, pg)
+}
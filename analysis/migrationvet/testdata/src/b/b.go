@@ -0,0 +1,16 @@
+package b
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pechorka/migrations"
+)
+
+func init() {
+	migrations.SetDefaultOptions(migrations.WithDialect(migrations.DialectPostgres))
+}
+
+func apply(ctx context.Context, db *sql.DB) error {
+	return migrations.Apply(ctx, db, []string{"CREATE TABLE users (id INT)"})
+}
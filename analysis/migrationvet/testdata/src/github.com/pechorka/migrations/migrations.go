@@ -0,0 +1,52 @@
+// Package migrations is a stub of the real package with the signatures the
+// analyzer looks at.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Dialect int
+
+const (
+	DialectSqlite Dialect = iota
+	DialectPostgres
+)
+
+type Option func(*Options) error
+
+type Options struct{}
+
+type Migration struct {
+	Version    int64
+	Name       string
+	UpSQL      string
+	Statements []string
+}
+
+func Apply(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) error {
+	return nil
+}
+
+func ApplyTo(ctx context.Context, db *sql.DB, migrations []string, target int, userOptions ...Option) error {
+	return nil
+}
+
+func ApplyMigrations(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) error {
+	return nil
+}
+
+func RollbackTo(ctx context.Context, db *sql.DB, migrations []Migration, version int, userOptions ...Option) error {
+	return nil
+}
+
+func GenerateScript(migrations []string, fromVersion int, dialect Dialect, userOptions ...Option) (string, error) {
+	return "", nil
+}
+
+func SetDefaultOptions(opts ...Option) {}
+
+func WithDialect(dialect Dialect) Option { return nil }
+
+func WithTableName(table string) Option { return nil }
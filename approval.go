@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrNotApproved is returned by Apply with WithApprovalToken when the
+// verifier rejects the token for the plan about to be executed.
+var ErrNotApproved = errors.New("migration plan not approved")
+
+// Plan describes the migrations an Apply run is about to execute.
+type Plan struct {
+	Dialect     Dialect
+	FromVersion int   // version the database is at
+	Versions    []int // versions that will be applied, in order
+	// Hash identifies the plan: the hex-encoded SHA-256 of the dialect, the
+	// bookkeeping table name, FromVersion and the version and SQL of every
+	// pending migration. Any change to those changes the hash.
+	Hash string
+	// Statements lists the migration statements Apply would execute, in
+	// order. Only DryRun fills it in.
+	Statements []PlannedStatement
+}
+
+// PlannedStatement is a migration statement DryRun expects Apply to execute.
+type PlannedStatement struct {
+	Version int
+	SQL     string
+}
+
+// NewPlan returns the Plan Apply would execute against db right now, without
+// changing the database, e.g. to post its Hash to a change ticket or a chat
+// channel for approval (see WithApprovalToken).
+func NewPlan(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (Plan, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return Plan{}, err
+	}
+	from, err := readHead(ctx, db, dialects[opts.Dialect], opts.TableName)
+	if err != nil {
+		return Plan{}, err
+	}
+	return newPlan(migrations, min(from, len(migrations)), opts), nil
+}
+
+func newPlan(migrations []string, from int, opts Options) Plan {
+	h := sha256.New()
+	writeField := func(s string) {
+		h.Write([]byte(strconv.Itoa(len(s)) + ":" + s + "\n"))
+	}
+	writeField(opts.Dialect.String())
+	writeField(opts.TableName)
+	writeField(strconv.Itoa(from))
+
+	plan := Plan{Dialect: opts.Dialect, FromVersion: from}
+	for version := from + 1; version <= len(migrations); version++ {
+		writeField(strconv.Itoa(version))
+		writeField(migrations[version-1])
+		plan.Versions = append(plan.Versions, version)
+	}
+	plan.Hash = hex.EncodeToString(h.Sum(nil))
+	return plan
+}
+
+// checkApproval asks opts.ApprovalVerifier to approve applying migrations
+// to a database at version head.
+func checkApproval(migrations []string, head int, opts Options) error {
+	plan := newPlan(migrations, max(head, 0), opts)
+	if len(plan.Versions) == 0 {
+		return nil
+	}
+	if err := opts.ApprovalVerifier(opts.ApprovalToken, plan); err != nil {
+		return fmt.Errorf("%w: plan %s: %w", ErrNotApproved, plan.Hash, err)
+	}
+	return nil
+}
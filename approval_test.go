@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestApprovalToken(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2"}
+
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+	rec.Return("sqlite_master", []string{"count"}, []any{1})
+	rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+	plan, err := NewPlan(context.Background(), db, migs)
+	if err != nil {
+		t.Fatalf("new plan: %v", err)
+	}
+	if plan.FromVersion != 1 || !reflect.DeepEqual(plan.Versions, []int{2}) || len(plan.Hash) != 64 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if other := newPlan([]string{"SELECT 1", "SELECT 2 "}, 1, Options{Dialect: DialectSqlite, TableName: "migrations"}); other.Hash == plan.Hash {
+		t.Fatal("expected a different hash for different SQL")
+	}
+
+	verify := func(token string, p Plan) error {
+		if token != "approved:"+plan.Hash || p.Hash != plan.Hash {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+
+	rec.Reset()
+	err = Apply(context.Background(), db, migs, WithApprovalToken("approved:0000", verify))
+	if !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("expected ErrNotApproved, got %v", err)
+	}
+	if countQuery(rec, "SELECT 2") != 0 {
+		t.Fatalf("expected nothing to be applied, got %q", rec.Queries())
+	}
+
+	rec.Reset()
+	if err := Apply(context.Background(), db, migs, WithApprovalToken("approved:"+plan.Hash, verify)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, "SELECT 2") != 1 {
+		t.Fatalf("expected migration #2 to be applied, got %q", rec.Queries())
+	}
+
+	if err := Apply(context.Background(), db, migs[:1], WithApprovalToken("", func(string, Plan) error {
+		t.Fatal("verifier called with nothing to apply")
+		return nil
+	})); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	if err := Apply(context.Background(), db, migs, WithApprovalToken("token", nil)); err == nil {
+		t.Fatal("expected error for token without verifier")
+	}
+}
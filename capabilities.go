@@ -0,0 +1,29 @@
+package migrations
+
+// Capabilities describes what a dialect's database supports, so callers and
+// hooks such as WithExecutor or Func migrations can adapt instead of
+// hard-coding dialect checks.
+type Capabilities struct {
+	// TransactionalDDL is true when schema changes take part in transactions
+	// and are rolled back with them. MySQL commits implicitly before and after
+	// most DDL statements, so a failed migration can leave earlier statements
+	// applied.
+	TransactionalDDL bool
+	// MultiStatementExec is true when a single Exec call may run several
+	// statements separated by ";" with the usual drivers and no extra
+	// configuration (MySQL needs multiStatements=true in the DSN).
+	MultiStatementExec bool
+	// AdvisoryLocks is true when the database offers named locks that are not
+	// tied to a row, e.g. pg_advisory_lock or GET_LOCK. Apply itself
+	// serializes runs with a row lock instead.
+	AdvisoryLocks bool
+	// ConcurrentIndex is true when indexes can be built without blocking
+	// writes from outside a transaction, see WithConcurrentIndexes.
+	ConcurrentIndex bool
+}
+
+// DialectCapabilities returns the capabilities of d, or the zero value when
+// d is not supported.
+func DialectCapabilities(d Dialect) Capabilities {
+	return dialects[d].capabilities
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import "testing"
+
+func TestDialectCapabilities(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    Capabilities
+	}{
+		{DialectSqlite, Capabilities{TransactionalDDL: true, MultiStatementExec: true}},
+		{DialectPostgres, Capabilities{TransactionalDDL: true, MultiStatementExec: true, AdvisoryLocks: true, ConcurrentIndex: true}},
+		{DialectMysql, Capabilities{AdvisoryLocks: true}},
+		{dialectEnd, Capabilities{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect.String(), func(t *testing.T) {
+			if got := DialectCapabilities(tt.dialect); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrChecksumMismatch is returned by Apply with WithChecksums when an applied
+// migration was edited afterwards. The concrete error is a
+// *ChecksumMismatchError listing the versions.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// ChecksumMismatchError reports applied migrations whose SQL no longer
+// matches the checksum recorded when they were applied. It matches
+// ErrChecksumMismatch with errors.Is.
+type ChecksumMismatchError struct {
+	Versions []int
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s: applied migrations %v were modified", ErrChecksumMismatch, e.Versions)
+}
+
+func (e *ChecksumMismatchError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// checksum returns the hex-encoded SHA-256 of a migration.
+func checksum(migration string) string {
+	sum := sha256.Sum256([]byte(migration))
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationChecksum returns the checksum of migration, or, when m has Open,
+// of the SQL it reads, hashed as it streams so the migration is never held in
+// memory.
+func migrationChecksum(m Migration, version int, migration string) (string, error) {
+	if m.Open == nil {
+		return checksum(migration), nil
+	}
+	r, err := m.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open migration #%d: %w", version, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to read migration #%d: %w", version, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// createMetaTable returns the DDL of the sidecar table holding the checksums
+// recorded with WithChecksums, kept apart so the bookkeeping table stays
+// byte-compatible with other tools reading it.
+func createMetaTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                version INTEGER PRIMARY KEY,
+                checksum VARCHAR(64) NOT NULL
+            )`
+}
+
+// checkChecksums compares the checksums recorded in the meta table with the
+// applied migrations up to head, and records the missing ones. migs holds the
+// per-migration settings, if any.
+func checkChecksums(ctx context.Context, tx *sql.Tx, d dialect, table string, migrations []string, migs []Migration, head int) error {
+	if _, err := tx.ExecContext(ctx, createMetaTable(table)); err != nil {
+		return fmt.Errorf("failed to create migrations meta table: %w", err)
+	}
+
+	recorded, err := readChecksums(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+
+	var modified []int
+	for version := 1; version <= min(head, len(migrations)); version++ {
+		var m Migration
+		if migs != nil {
+			m = migs[version-1]
+		}
+		sum, err := migrationChecksum(m, version, migrations[version-1])
+		if err != nil {
+			return err
+		}
+		recordedSum, ok := recorded[version]
+		if !ok {
+			// Applied before checksums were enabled: trust the current SQL.
+			if err := recordChecksum(ctx, tx, d, table, version, sum); err != nil {
+				return err
+			}
+			continue
+		}
+		if recordedSum != sum {
+			modified = append(modified, version)
+		}
+	}
+	if len(modified) > 0 {
+		return &ChecksumMismatchError{Versions: modified}
+	}
+	return nil
+}
+
+func recordChecksum(ctx context.Context, tx *sql.Tx, d dialect, table string, version int, sum string) error {
+	insert := d.rebind("INSERT INTO " + table + " (version, checksum) VALUES (?, ?)")
+	if _, err := tx.ExecContext(ctx, insert, version, sum); err != nil {
+		return fmt.Errorf("failed to record checksum of migration #%d: %w", version, err)
+	}
+	return nil
+}
+
+// readChecksums returns the checksum recorded for every version in table.
+func readChecksums(ctx context.Context, q queryer, table string) (map[int]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, checksum FROM "+table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration checksums: %w", err)
+	}
+	defer rows.Close()
+	recorded := map[int]string{}
+	for rows.Next() {
+		var version int
+		var sum string
+		if err := rows.Scan(&version, &sum); err != nil {
+			return nil, fmt.Errorf("failed to scan migration checksum: %w", err)
+		}
+		recorded[version] = sum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read migration checksums: %w", err)
+	}
+	return recorded, nil
+}
+
+// AppliedChecksums returns the hex-encoded SHA-256 every applied version was
+// recorded with by Apply with WithChecksums, without changing the database.
+// Comparing the result of two environments, or with Checksums of the
+// migrations a service ships, detects drift before it surfaces as a failed
+// Apply. Versions applied without checksums are missing from the result,
+// which is empty when checksums were never enabled.
+func AppliedChecksums(ctx context.Context, db *sql.DB, userOptions ...Option) (map[int]string, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return nil, err
+	}
+	d := dialects[opts.Dialect]
+	table := opts.TableName + "_meta"
+
+	var existingTables int
+	if err := db.QueryRowContext(ctx, d.rebind(d.tableExists), table).Scan(&existingTables); err != nil {
+		return nil, fmt.Errorf("failed to check if migrations meta table %q exists: %w", table, err)
+	}
+	if existingTables == 0 {
+		return map[int]string{}, nil
+	}
+	return readChecksums(ctx, db, d.quoteIdent(table))
+}
+
+// Checksums returns the checksum of every migration as recorded by Apply with
+// WithChecksums, keyed by version, for comparing with AppliedChecksums.
+func Checksums(migrations []string) map[int]string {
+	sums := make(map[int]string, len(migrations))
+	for i, migration := range migrations {
+		sums[i+1] = checksum(migration)
+	}
+	return sums
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestChecksums(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2"}
+
+	t.Run("records checksums", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("SELECT version, checksum", []string{"version", "checksum"})
+
+		if err := Apply(context.Background(), db, migs, WithChecksums(true)); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		var recorded []any
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, `INSERT INTO "migrations_meta"`) {
+				recorded = append(recorded, s.Args...)
+			}
+		}
+		want := []any{int64(1), checksum("SELECT 1"), int64(2), checksum("SELECT 2")}
+		if !reflect.DeepEqual(recorded, want) {
+			t.Fatalf("got %v, want %v", recorded, want)
+		}
+	})
+
+	t.Run("detects edited migrations", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{2})
+		rec.Return("SELECT version, checksum", []string{"version", "checksum"}, []any{1, checksum("SELECT 1")}, []any{2, checksum("SELECT 20")})
+
+		err := Apply(context.Background(), db, migs, WithChecksums(true))
+		var mismatch *ChecksumMismatchError
+		if !errors.As(err, &mismatch) || !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected *ChecksumMismatchError, got %v", err)
+		}
+		if !reflect.DeepEqual(mismatch.Versions, []int{2}) {
+			t.Fatalf("got versions %v, want [2]", mismatch.Versions)
+		}
+	})
+
+	t.Run("hashes streamed migrations", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{1})
+		rec.Return("SELECT version, checksum", []string{"version", "checksum"}, []any{1, checksum("SELECT 1;\nSELECT 2;\n")})
+
+		body := "SELECT 1;\nSELECT 2;\n"
+		streamed := []Migration{
+			{Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(body)), nil }},
+			{Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("SELECT 3")), nil }},
+		}
+		if err := ApplyMigrations(context.Background(), db, streamed, WithChecksums(true)); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		var recorded []any
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, `INSERT INTO "migrations_meta"`) {
+				recorded = append(recorded, s.Args...)
+			}
+		}
+		if want := []any{int64(2), checksum("SELECT 3")}; !reflect.DeepEqual(recorded, want) {
+			t.Fatalf("got %v, want %v", recorded, want)
+		}
+
+		body = "SELECT 1;\nSELECT 20;\n"
+		err := ApplyMigrations(context.Background(), db, streamed, WithChecksums(true))
+		var mismatch *ChecksumMismatchError
+		if !errors.As(err, &mismatch) || !reflect.DeepEqual(mismatch.Versions, []int{1}) {
+			t.Fatalf("expected a mismatch of version 1, got %v", err)
+		}
+	})
+
+	t.Run("applied checksums", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+
+		// Without a meta table the existence check counts 0.
+		got, err := AppliedChecksums(context.Background(), db)
+		if err != nil {
+			t.Fatalf("applied checksums: %v", err)
+		}
+		if len(got) != 0 {
+			t.Fatalf("expected no checksums without a meta table, got %v", got)
+		}
+
+		rec.Return("sqlite_master", []string{"count"}, []any{1})
+		rec.Return("SELECT version, checksum", []string{"version", "checksum"}, []any{1, checksum("SELECT 1")}, []any{2, checksum("SELECT 2")})
+		got, err = AppliedChecksums(context.Background(), db)
+		if err != nil {
+			t.Fatalf("applied checksums: %v", err)
+		}
+		if !reflect.DeepEqual(got, Checksums(migs)) {
+			t.Fatalf("got %v, want %v", got, Checksums(migs))
+		}
+		for _, q := range rec.Queries() {
+			if q == migrationsmock.Begin || strings.Contains(q, "CREATE") || strings.Contains(q, "INSERT") {
+				t.Fatalf("applied checksums must not modify the database, got %q", rec.Queries())
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pechorka/migrations"
+)
+
+// file describes the Go file written by generate.
+type file struct {
+	// pkg is the package clause.
+	pkg string
+	// name is the name of the migrations variable.
+	name string
+	// prefix starts the name of every version constant.
+	prefix string
+	// dir is the directory the migrations were read from, for the comments.
+	dir string
+}
+
+// generate renders migs as a Go file declaring them in order, with a
+// constant per version.
+func generate(migs []migrations.Migration, f file) ([]byte, error) {
+	for _, ident := range []string{f.pkg, f.name, f.prefix} {
+		if !token.IsIdentifier(ident) {
+			return nil, fmt.Errorf("%q is not a valid Go identifier", ident)
+		}
+	}
+	if len(migs) == 0 {
+		return nil, fmt.Errorf("no migrations in %q", f.dir)
+	}
+	consts := constNames(migs, f.prefix)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"migrations generate\" from %s; DO NOT EDIT.\n\n", f.dir)
+	fmt.Fprintf(&buf, "package %s\n\n", f.pkg)
+	buf.WriteString("import \"github.com/pechorka/migrations\"\n\n")
+	fmt.Fprintf(&buf, "// Versions of the migrations in %s.\nconst (\n", f.name)
+	for i, c := range consts {
+		fmt.Fprintf(&buf, "%s = %d\n", c, i+1)
+	}
+	buf.WriteString(")\n\n")
+	fmt.Fprintf(&buf, "// %s holds the migrations of %s in order, for\n// migrations.ApplyMigrations.\n", f.name, f.dir)
+	fmt.Fprintf(&buf, "var %s = []migrations.Migration{\n", f.name)
+	for i, m := range migs {
+		fmt.Fprintf(&buf, "{\nVersion: %s,\n", consts[i])
+		if m.Name != "" {
+			fmt.Fprintf(&buf, "Name: %s,\n", strconv.Quote(m.Name))
+		}
+		if m.UpSQL != "" {
+			fmt.Fprintf(&buf, "UpSQL: %s,\n", literal(m.UpSQL))
+		}
+		if m.DownSQL != "" {
+			fmt.Fprintf(&buf, "DownSQL: %s,\n", literal(m.DownSQL))
+		}
+		if len(m.DialectSQL) > 0 {
+			buf.WriteString("DialectSQL: map[migrations.Dialect]string{\n")
+			dialects := make([]migrations.Dialect, 0, len(m.DialectSQL))
+			for d := range m.DialectSQL {
+				dialects = append(dialects, d)
+			}
+			slices.Sort(dialects)
+			for _, d := range dialects {
+				fmt.Fprintf(&buf, "%s: %s,\n", dialectIdent(d), literal(m.DialectSQL[d]))
+			}
+			buf.WriteString("},\n")
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// constNames returns the name of the constant of every version: prefix
+// followed by the migration name in camel case, e.g. "VersionCreateUsers".
+// Versions without a name, or sharing it with an earlier one, get their
+// number too, e.g. "Version3" and "Version7AddIndex".
+func constNames(migs []migrations.Migration, prefix string) []string {
+	names := make([]string, len(migs))
+	used := map[string]bool{}
+	for i, m := range migs {
+		name := prefix + camel(m.Name)
+		if name == prefix || used[name] {
+			name = prefix + strconv.Itoa(i+1) + camel(m.Name)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// camel joins the letters and digits of s in camel case, e.g. "AddUsersName"
+// for "add_users-name".
+func camel(s string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		r, size := utf8.DecodeRuneInString(part)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(part[size:])
+	}
+	return b.String()
+}
+
+// literal returns s as a Go string literal, a raw one when s can be written
+// as such so SQL stays readable in the generated file.
+func literal(s string) string {
+	if utf8.ValidString(s) && !strings.ContainsAny(s, "`\r\x00\ufeff") {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+// dialectIdent returns the Go expression of d, e.g. migrations.DialectPostgres.
+func dialectIdent(d migrations.Dialect) string {
+	return "migrations.Dialect" + camel(d.String())
+}
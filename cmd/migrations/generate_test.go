@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pechorka/migrations"
+)
+
+func TestGenerate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);\n")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;\n")},
+		"0002.sql":                   {Data: []byte("SELECT 1")},
+		"0003_search.postgres.sql":   {Data: []byte("CREATE INDEX search ON users USING gin (name)")},
+		"0003_search.sql":            {Data: []byte("-- nothing to do")},
+		"0004-create-users.sql":      {Data: []byte("COMMENT ON TABLE users IS 'uses `backticks`'")},
+	}
+	migs, err := migrations.LoadFS(fsys, ".")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got, err := generate(migs, file{pkg: "db", name: "Migrations", prefix: "Version", dir: "sql"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	want := "// Code generated by \"migrations generate\" from sql; DO NOT EDIT.\n" + `
+package db
+
+import "github.com/pechorka/migrations"
+
+// Versions of the migrations in Migrations.
+const (
+	VersionCreateUsers  = 1
+	Version2            = 2
+	VersionSearch       = 3
+	Version4CreateUsers = 4
+)
+
+// Migrations holds the migrations of sql in order, for
+// migrations.ApplyMigrations.
+var Migrations = []migrations.Migration{
+	{
+		Version: VersionCreateUsers,
+		Name:    "create_users",
+		UpSQL: ` + "`CREATE TABLE users (id INT);\n`" + `,
+		DownSQL: ` + "`DROP TABLE users;\n`" + `,
+	},
+	{
+		Version: Version2,
+		UpSQL:   ` + "`SELECT 1`" + `,
+	},
+	{
+		Version: VersionSearch,
+		Name:    "search",
+		UpSQL:   ` + "`-- nothing to do`" + `,
+		DialectSQL: map[migrations.Dialect]string{
+			migrations.DialectPostgres: ` + "`CREATE INDEX search ON users USING gin (name)`" + `,
+		},
+	},
+	{
+		Version: Version4CreateUsers,
+		Name:    "create-users",
+		UpSQL:   "COMMENT ON TABLE users IS 'uses ` + "`backticks`" + `'",
+	},
+}
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0001_init.sql"), []byte("CREATE TABLE t (id INT)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "migrations_gen.go")
+
+	if err := run([]string{"generate", "-dir", dir, "-o", out, "-pkg", "db", "-var", "Schema", "-prefix", "Schema"}, nil); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	src, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"package db\n", "SchemaInit = 1\n", "var Schema = []migrations.Migration{"} {
+		if !strings.Contains(string(src), want) {
+			t.Fatalf("expected %q in:\n%s", want, src)
+		}
+	}
+
+	for _, args := range [][]string{
+		nil,
+		{"apply"},
+		{"generate", "-dir", dir, "-pkg", "db", "extra"},
+		{"generate", "-dir", dir, "-pkg", "not-an-identifier"},
+		{"generate", "-dir", filepath.Join(dir, "missing"), "-pkg", "db"},
+	} {
+		if err := run(args, &bytes.Buffer{}); err == nil {
+			t.Fatalf("expected an error for %q", args)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Command migrations is the command line companion of the migrations package.
+//
+// "migrations generate" reads the migration files of a directory like
+// migrations.LoadFS and writes a Go file declaring them as an ordered
+// []migrations.Migration, with a constant per version named after its file:
+//
+//	migrations generate -dir sql -pkg db -o migrations_gen.go
+//
+// Projects that prefer generated code over go:embed get the whole migration
+// set checked in as Go, with IDE navigation to every version. Under go
+// generate the package name is taken from $GOPACKAGE:
+//
+//	//go:generate go run github.com/pechorka/migrations/cmd/migrations generate -dir sql -o migrations_gen.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pechorka/migrations"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "migrations:", err)
+		os.Exit(1)
+	}
+}
+
+const usage = "usage: migrations generate [-dir dir] [-o file] [-pkg name] [-var name] [-prefix prefix]"
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return errors.New(usage)
+	}
+	flags := flag.NewFlagSet("migrations generate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dir := flags.String("dir", "migrations", "directory holding the migration files")
+	out := flags.String("o", "", "file to write (default: standard output)")
+	pkg := flags.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated file (default: $GOPACKAGE)")
+	name := flags.String("var", "Migrations", "name of the generated variable")
+	prefix := flags.String("prefix", "Version", "prefix of the generated version constants")
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q\n%s", flags.Args(), usage)
+	}
+	if *pkg == "" {
+		return errors.New("-pkg is required outside go generate")
+	}
+
+	migs, err := migrations.LoadFS(os.DirFS(*dir), ".")
+	if err != nil {
+		return err
+	}
+	src, err := generate(migs, file{pkg: *pkg, name: *name, prefix: *prefix, dir: filepath.ToSlash(*dir)})
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(*out, src, 0o644)
+}
@@ -0,0 +1,154 @@
+// Package conformance is a reusable test suite for checking that a database
+// driver, or a database speaking one of the supported dialects, works with
+// the migrations package. Call Run from a regular test in your own CI:
+//
+//	func TestMigrationsConformance(t *testing.T) {
+//		conformance.Run(t, "pgx", os.Getenv("DSN"), migrations.DialectPostgres)
+//	}
+//
+// The suite creates and drops tables prefixed with "conformance_" in the
+// database dsn points at; do not run it against a database holding data you
+// care about.
+package conformance
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/pechorka/migrations"
+)
+
+// tables lists everything the suite may create, dropped before and after
+// every subtest.
+var tables = []string{"conformance_items", "conformance_counter", "conformance_migrations"}
+
+// Run exercises applying, re-applying, statement splitting, rollback on
+// failure and concurrent Apply calls against the database at dsn. Extra
+// options (e.g. WithCompatibility) are passed to every Apply call; the
+// bookkeeping table name is fixed by the suite.
+func Run(t *testing.T, driverName, dsn string, dialect migrations.Dialect, opts ...migrations.Option) {
+	t.Helper()
+	opts = append([]migrations.Option{migrations.WithDialect(dialect)}, opts...)
+	opts = append(opts, migrations.WithTableName("conformance_migrations"))
+
+	t.Run("apply and reapply", func(t *testing.T) {
+		db := open(t, driverName, dsn)
+		migs := []string{
+			`CREATE TABLE conformance_items (id INT PRIMARY KEY, name VARCHAR(50) NOT NULL)`,
+			`INSERT INTO conformance_items (id, name) VALUES (1, 'a'); INSERT INTO conformance_items (id, name) VALUES (2, 'b')`,
+		}
+		apply(t, db, migs[:1], opts)
+		apply(t, db, migs, opts)
+		apply(t, db, migs, opts)
+		expectCount(t, db, "conformance_items", 2)
+	})
+
+	t.Run("statement splitting", func(t *testing.T) {
+		db := open(t, driverName, dsn)
+		migs := []string{`
+			CREATE TABLE conformance_items (id INT PRIMARY KEY, name VARCHAR(50) NOT NULL);
+			-- a comment; with a semicolon
+			INSERT INTO conformance_items (id, name) VALUES (1, 'semi;colon');
+			/* block; comment */
+			INSERT INTO conformance_items (id, name) VALUES (2, 'it''s');
+		`}
+		apply(t, db, migs, opts)
+		expectCount(t, db, "conformance_items", 2)
+
+		var name string
+		if err := db.QueryRow(`SELECT name FROM conformance_items WHERE id = 1`).Scan(&name); err != nil {
+			t.Fatalf("read back: %v", err)
+		}
+		if name != "semi;colon" {
+			t.Fatalf("got name %q, want %q", name, "semi;colon")
+		}
+	})
+
+	t.Run("failed migration rolls back", func(t *testing.T) {
+		db := open(t, driverName, dsn)
+		migs := []string{`CREATE TABLE conformance_items (id INT PRIMARY KEY, name VARCHAR(50) NOT NULL)`}
+		apply(t, db, migs, opts)
+
+		migs = append(migs,
+			`INSERT INTO conformance_items (id, name) VALUES (1, 'a')`,
+			`INSERT INTO conformance_missing (id) VALUES (1)`,
+		)
+		if err := migrations.Apply(context.Background(), db, migs, opts...); err == nil {
+			t.Fatal("expected the failing migration to return an error")
+		}
+		expectCount(t, db, "conformance_items", 0)
+		if err := migrations.Verify(context.Background(), db, migs, opts...); err == nil {
+			t.Fatal("expected migrations #2 and #3 to be pending")
+		}
+	})
+
+	t.Run("concurrent apply runs each migration once", func(t *testing.T) {
+		db := open(t, driverName, dsn)
+		migs := []string{
+			`CREATE TABLE conformance_counter (id INT PRIMARY KEY)`,
+			`INSERT INTO conformance_counter (id) VALUES (1)`,
+		}
+
+		// Engines without row locks (SQLite) may reject concurrent writers
+		// outright; that is fine as long as nothing runs twice.
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := migrations.Apply(context.Background(), db, migs, opts...); err != nil {
+					t.Logf("concurrent apply: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		apply(t, db, migs, opts)
+		expectCount(t, db, "conformance_counter", 1)
+	})
+}
+
+func open(t *testing.T, driverName, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	dropTables(t, db)
+	t.Cleanup(func() {
+		dropTables(t, db)
+		if err := db.Close(); err != nil {
+			t.Errorf("close: %v", err)
+		}
+	})
+	return db
+}
+
+func dropTables(t *testing.T, db *sql.DB) {
+	t.Helper()
+	for _, table := range tables {
+		if _, err := db.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			t.Fatalf("drop %s: %v", table, err)
+		}
+	}
+}
+
+func apply(t *testing.T, db *sql.DB, migs []string, opts []migrations.Option) {
+	t.Helper()
+	if err := migrations.Apply(context.Background(), db, migs, opts...); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+}
+
+func expectCount(t *testing.T, db *sql.DB, table string, want int) {
+	t.Helper()
+	var got int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&got); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	if got != want {
+		t.Fatalf("%s has %d rows, want %d", table, got, want)
+	}
+}
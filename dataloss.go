@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// ErrDataLoss is returned by Apply with WithDataLossCheck when a migration
+// would drop a table that has rows or a column that holds values.
+var ErrDataLoss = errors.New("migration would lose data")
+
+// dropTarget is a table, or a column of it, dropped by a statement.
+type dropTarget struct {
+	table  string
+	column string // empty when the whole table is dropped
+}
+
+// dropTargets returns the tables dropped by a DROP TABLE statement and the
+// columns dropped by an ALTER TABLE statement. Schema-qualified tables are
+// left out. Unquoted names are folded to lower case on Postgres; backslashes
+// escape in string literals on MySQL.
+func dropTargets(stmt string, postgres, mysql bool) []dropTarget {
+	if i, ok := expectWord(stmt, 0, "DROP"); ok {
+		if j, ok := expectWord(stmt, i, "TEMPORARY"); ok {
+			i = j
+		}
+		if i, ok = expectWord(stmt, i, "TABLE"); !ok {
+			return nil
+		}
+		i = skipIfExists(stmt, i)
+		var targets []dropTarget
+		for {
+			end, table, qualified := readTableName(stmt, i, postgres)
+			if table == "" {
+				return targets
+			}
+			if !qualified {
+				targets = append(targets, dropTarget{table: table})
+			}
+			if i = skipSpace(stmt, end); i >= len(stmt) || stmt[i] != ',' {
+				return targets
+			}
+			i++
+		}
+	}
+
+	i, ok := expectWord(stmt, 0, "ALTER")
+	if !ok {
+		return nil
+	}
+	if i, ok = expectWord(stmt, i, "TABLE"); !ok {
+		return nil
+	}
+	i = skipIfExists(stmt, i)
+	if j, ok := expectWord(stmt, i, "ONLY"); ok {
+		i = j
+	}
+	i, table, qualified := readTableName(stmt, i, postgres)
+	if table == "" || qualified {
+		return nil
+	}
+	var targets []dropTarget
+	for i < len(stmt) {
+		if column := droppedColumn(stmt, i, postgres); column != "" {
+			targets = append(targets, dropTarget{table: table, column: column})
+		}
+		// Skip to the next top-level comma separating ALTER TABLE actions.
+		for depth := 0; i < len(stmt); {
+			if end := skipQuotedOrComment(stmt, i, mysql); end > i {
+				i = end
+				continue
+			}
+			c := stmt[i]
+			i++
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				depth--
+			} else if c == ',' && depth == 0 {
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// droppedColumn returns the column dropped by the ALTER TABLE action
+// "DROP [COLUMN] [IF EXISTS] name" at i, or "" for any other action.
+func droppedColumn(stmt string, i int, postgres bool) string {
+	i, ok := expectWord(stmt, i, "DROP")
+	if !ok {
+		return ""
+	}
+	if j, ok := expectWord(stmt, i, "COLUMN"); ok {
+		i = j
+	} else {
+		for _, other := range []string{"CONSTRAINT", "INDEX", "KEY", "PRIMARY", "FOREIGN", "CHECK", "PARTITION", "DEFAULT", "SYSTEM"} {
+			if _, ok := expectWord(stmt, i, other); ok {
+				return ""
+			}
+		}
+	}
+	i = skipSpace(stmt, skipIfExists(stmt, i))
+	_, column := readIdent(stmt, i)
+	if column != "" && postgres && stmt[i] != '"' {
+		column = strings.ToLower(column)
+	}
+	return column
+}
+
+// skipIfExists skips an "IF EXISTS" clause at i.
+func skipIfExists(s string, i int) int {
+	if j, ok := expectWord(s, i, "IF"); ok {
+		if j, ok = expectWord(s, j, "EXISTS"); ok {
+			return j
+		}
+	}
+	return i
+}
+
+// readTableName reads a possibly schema-qualified table name at i and returns
+// the offset after it, its last part and whether it was qualified.
+func readTableName(s string, i int, fold bool) (end int, name string, qualified bool) {
+	for parts := 0; ; parts++ {
+		start := skipSpace(s, i)
+		end, part := readIdent(s, start)
+		if part == "" {
+			return i, "", false
+		}
+		if fold && s[start] != '"' {
+			part = strings.ToLower(part)
+		}
+		if end >= len(s) || s[end] != '.' {
+			return end, part, parts > 0
+		}
+		i = end + 1
+	}
+}
+
+// checkDataLoss fails with ErrDataLoss when stmt drops a table of the current
+// schema that has rows, or a column that holds a non-NULL value.
+func checkDataLoss(ctx context.Context, tx *sql.Tx, d dialect, stmt string, postgres bool) error {
+	for _, target := range dropTargets(stmt, postgres, d.flavor == utils.FlavorMysql) {
+		cols, err := readColumns(ctx, tx, d, target.table)
+		if err != nil {
+			return err
+		}
+		query := "SELECT 1 FROM " + d.quoteIdent(target.table)
+		what := fmt.Sprintf("table %q has rows", target.table)
+		if target.column != "" {
+			found := false
+			for _, c := range cols {
+				found = found || strings.EqualFold(c.name, target.column)
+			}
+			if !found {
+				continue
+			}
+			query += " WHERE " + d.quoteIdent(target.column) + " IS NOT NULL"
+			what = fmt.Sprintf("column %q of table %q holds values", target.column, target.table)
+		} else if len(cols) == 0 {
+			continue // dropped with IF EXISTS
+		}
+
+		var one int
+		err = tx.QueryRowContext(ctx, query+" LIMIT 1").Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check data of table %q: %w", target.table, err)
+		}
+		return fmt.Errorf("%w: %s; confirm with WithConfirmDataLoss(true)", ErrDataLoss, what)
+	}
+	return nil
+}
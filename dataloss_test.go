@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDropTargets(t *testing.T) {
+	for _, tc := range []struct {
+		stmt     string
+		postgres bool
+		want     []dropTarget
+	}{
+		{"DROP TABLE users", false, []dropTarget{{table: "users"}}},
+		{"drop table if exists Users, \"Orders\", audit.log CASCADE", true, []dropTarget{{table: "users"}, {table: "Orders"}}},
+		{"DROP TEMPORARY TABLE `tmp`", false, []dropTarget{{table: "tmp"}}},
+		{"ALTER TABLE users DROP COLUMN email", false, []dropTarget{{table: "users", column: "email"}}},
+		{"ALTER TABLE IF EXISTS ONLY Users DROP Email, ADD COLUMN c INT DEFAULT (1), DROP COLUMN IF EXISTS \"Phone\" CASCADE", true,
+			[]dropTarget{{table: "users", column: "email"}, {table: "users", column: "Phone"}}},
+		{"ALTER TABLE users DROP CONSTRAINT users_pk, DROP INDEX i, DROP PRIMARY KEY", false, nil},
+		{"ALTER TABLE users ALTER COLUMN email DROP DEFAULT", false, nil},
+		{"ALTER TABLE audit.log DROP COLUMN c", false, nil},
+		{"DROP INDEX users_email", false, nil},
+		{"CREATE TABLE t (id INT)", false, nil},
+	} {
+		if got := dropTargets(tc.stmt, tc.postgres, false); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("dropTargets(%q) = %+v, want %+v", tc.stmt, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SqliteTableDDL returns the exact statement Apply uses to create the
+// bookkeeping table on SQLite. Pre-creating the table with it (e.g. from a
+// reviewed baseline) makes Apply's own CREATE TABLE IF NOT EXISTS a no-op.
+func SqliteTableDDL(table string) string {
+	return createTableStmt(DialectSqlite, table)
+}
+
+// PostgresTableDDL returns the exact statement Apply uses to create the
+// bookkeeping table on Postgres.
+func PostgresTableDDL(table string) string {
+	return createTableStmt(DialectPostgres, table)
+}
+
+// MysqlTableDDL returns the exact statement Apply uses to create the
+// bookkeeping table on MySQL.
+func MysqlTableDDL(table string) string {
+	return createTableStmt(DialectMysql, table)
+}
+
+// ErrTableNonConformant is returned by CheckTableConformance when the
+// bookkeeping table is missing or its columns differ from the DDL Apply uses.
+var ErrTableNonConformant = errors.New("migrations table does not conform to expected DDL")
+
+// column is a bookkeeping table column as reported by the database catalog.
+type column struct {
+	name     string
+	dataType string
+}
+
+// CheckTableConformance verifies that the bookkeeping table selected by
+// userOptions exists and has exactly the columns and column types created by
+// the dialect's *TableDDL function. It is meant for tables that were created
+// outside of Apply, e.g. by Terraform, and only reads the catalog.
+func CheckTableConformance(ctx context.Context, db *sql.DB, userOptions ...Option) error {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return err
+	}
+	d := dialects[opts.Dialect]
+
+	got, err := readColumns(ctx, db, d, opts.TableName)
+	if err != nil {
+		return err
+	}
+
+	if len(got) == 0 {
+		return fmt.Errorf("%w: table %q not found", ErrTableNonConformant, opts.TableName)
+	}
+	if !equalColumns(got, d.columns) {
+		return fmt.Errorf("%w: table %q has columns %s, want %s",
+			ErrTableNonConformant, opts.TableName, formatColumns(got), formatColumns(d.columns))
+	}
+	return nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// readColumns returns the columns of the unquoted table, or none when it does
+// not exist.
+func readColumns(ctx context.Context, q queryer, d dialect, table string) ([]column, error) {
+	rows, err := q.QueryContext(ctx, d.rebind(d.tableColumns), table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of table %q: %w", table, err)
+	}
+	defer rows.Close()
+
+	var cols []column
+	for rows.Next() {
+		var c column
+		if err := rows.Scan(&c.name, &c.dataType); err != nil {
+			return nil, fmt.Errorf("failed to read columns of table %q: %w", table, err)
+		}
+		cols = append(cols, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read columns of table %q: %w", table, err)
+	}
+	return cols, nil
+}
+
+func equalColumns(got, want []column) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !strings.EqualFold(got[i].name, want[i].name) || !strings.EqualFold(got[i].dataType, want[i].dataType) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatColumns(cols []column) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = c.name + " " + c.dataType
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestTableDDL(t *testing.T) {
+	ddl := PostgresTableDDL("schema_migrations")
+	if !strings.HasPrefix(ddl, `CREATE TABLE IF NOT EXISTS "schema_migrations" (`) {
+		t.Fatalf("unexpected postgres DDL: %s", ddl)
+	}
+	if ddl := MysqlTableDDL("schema_migrations"); !strings.Contains(ddl, "`schema_migrations`") {
+		t.Fatalf("unexpected mysql DDL: %s", ddl)
+	}
+
+	db, rec := migrationsmock.DB()
+	if err := Apply(context.Background(), db, nil, WithTableName("schema_migrations")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := SqliteTableDDL("schema_migrations")
+	for _, q := range rec.Queries() {
+		if strings.Contains(q, "CREATE TABLE") {
+			if q != want {
+				t.Fatalf("Apply used DDL %q, want %q", q, want)
+			}
+			return
+		}
+	}
+	t.Fatal("Apply did not create the table")
+}
+
+func TestCheckTableConformance(t *testing.T) {
+	ctx := context.Background()
+	opts := []Option{WithDialect(DialectPostgres)}
+
+	db, rec := migrationsmock.DB()
+	rec.Return("information_schema.columns", []string{"column_name", "data_type"},
+		[]any{"version", "integer"}, []any{"applied_at", "timestamp with time zone"})
+	if err := CheckTableConformance(ctx, db, opts...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db, rec = migrationsmock.DB()
+	rec.Return("information_schema.columns", []string{"column_name", "data_type"},
+		[]any{"version", "bigint"}, []any{"applied_at", "timestamp with time zone"})
+	if err := CheckTableConformance(ctx, db, opts...); !errors.Is(err, ErrTableNonConformant) {
+		t.Fatalf("expected ErrTableNonConformant, got %v", err)
+	}
+
+	db, rec = migrationsmock.DB()
+	rec.Return("information_schema.columns", []string{"column_name", "data_type"})
+	if err := CheckTableConformance(ctx, db, opts...); !errors.Is(err, ErrTableNonConformant) {
+		t.Fatalf("expected ErrTableNonConformant for missing table, got %v", err)
+	}
+}
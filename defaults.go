@@ -0,0 +1,111 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+var (
+	defaultOptionsMu sync.RWMutex
+	defaultOptions   []Option
+)
+
+// SetDefaultOptions sets options applied before the ones passed to every
+// function of this package, so codebases with many call sites (services,
+// tools, tests) configure the dialect, table name or policy options once,
+// e.g. in main or TestMain:
+//
+//	migrations.SetDefaultOptions(
+//		migrations.WithDialect(migrations.DialectPostgres),
+//		migrations.WithTableName("schema_migrations"),
+//	)
+//
+// Options passed to a Migrator or to a call override the defaults. Each call
+// replaces the previous defaults; SetDefaultOptions() clears them. It is safe
+// for concurrent use, but a call racing with Apply may or may not affect it.
+func SetDefaultOptions(opts ...Option) {
+	defaultOptionsMu.Lock()
+	defer defaultOptionsMu.Unlock()
+	defaultOptions = append([]Option(nil), opts...)
+}
+
+// currentDefaultOptions returns the options set with SetDefaultOptions.
+func currentDefaultOptions() []Option {
+	defaultOptionsMu.RLock()
+	defer defaultOptionsMu.RUnlock()
+	return defaultOptions
+}
+
+// Migrator runs the functions of this package with a fixed set of options, for
+// code talking to several databases with different settings. Its options
+// override the defaults set with SetDefaultOptions; options passed to a method
+// override both. The zero Migrator uses the defaults only.
+type Migrator struct {
+	opts []Option
+}
+
+// NewMigrator returns a Migrator applying opts on every call.
+func NewMigrator(opts ...Option) *Migrator {
+	return &Migrator{opts: append([]Option(nil), opts...)}
+}
+
+// with returns the Migrator's options followed by userOptions.
+func (m *Migrator) with(userOptions []Option) []Option {
+	return append(m.opts[:len(m.opts):len(m.opts)], userOptions...)
+}
+
+// Apply calls Apply with the Migrator's options.
+func (m *Migrator) Apply(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) error {
+	return Apply(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// ApplyReport calls ApplyReport with the Migrator's options.
+func (m *Migrator) ApplyReport(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (Report, error) {
+	return ApplyReport(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// ApplyMigrations calls ApplyMigrations with the Migrator's options.
+func (m *Migrator) ApplyMigrations(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) error {
+	return ApplyMigrations(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// ApplyTo calls ApplyTo with the Migrator's options.
+func (m *Migrator) ApplyTo(ctx context.Context, db *sql.DB, migrations []string, target int, userOptions ...Option) error {
+	return ApplyTo(ctx, db, migrations, target, m.with(userOptions)...)
+}
+
+// ApplyOne calls ApplyOne with the Migrator's options.
+func (m *Migrator) ApplyOne(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (int, error) {
+	return ApplyOne(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// RollbackTo calls RollbackTo with the Migrator's options.
+func (m *Migrator) RollbackTo(ctx context.Context, db *sql.DB, migrations []Migration, version int, userOptions ...Option) error {
+	return RollbackTo(ctx, db, migrations, version, m.with(userOptions)...)
+}
+
+// Verify calls Verify with the Migrator's options.
+func (m *Migrator) Verify(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) error {
+	return Verify(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// VerifyMigrations calls VerifyMigrations with the Migrator's options.
+func (m *Migrator) VerifyMigrations(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) error {
+	return VerifyMigrations(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// Pending calls Pending with the Migrator's options.
+func (m *Migrator) Pending(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) ([]Migration, error) {
+	return Pending(ctx, db, migrations, m.with(userOptions)...)
+}
+
+// CurrentVersion calls CurrentVersion with the Migrator's options.
+func (m *Migrator) CurrentVersion(ctx context.Context, db *sql.DB, userOptions ...Option) (int64, error) {
+	return CurrentVersion(ctx, db, m.with(userOptions)...)
+}
+
+// DryRun calls DryRun with the Migrator's options.
+func (m *Migrator) DryRun(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (Plan, error) {
+	return DryRun(ctx, db, migrations, m.with(userOptions)...)
+}
@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	defer SetDefaultOptions()
+	SetDefaultOptions(WithTableName("default_migrations"))
+
+	for _, tc := range []struct {
+		name     string
+		migrator *Migrator
+		opts     []Option
+		want     string
+	}{
+		{name: "defaults", migrator: &Migrator{}, want: "default_migrations"},
+		{name: "migrator overrides defaults", migrator: NewMigrator(WithTableName("migrator_migrations")), want: "migrator_migrations"},
+		{name: "call overrides migrator", migrator: NewMigrator(WithTableName("migrator_migrations")), opts: []Option{WithTableName("call_migrations")}, want: "call_migrations"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("sqlite_master", []string{"count"}, []any{1})
+			rec.Return("MAX(version)", []string{"max"}, []any{2})
+
+			version, err := tc.migrator.CurrentVersion(context.Background(), db, tc.opts...)
+			if err != nil {
+				t.Fatalf("current version: %v", err)
+			}
+			if version != 2 {
+				t.Fatalf("got version %d, want 2", version)
+			}
+			var read bool
+			for _, q := range rec.Queries() {
+				if strings.Contains(q, "MAX(version)") {
+					read = true
+					if !strings.Contains(q, tc.want) {
+						t.Fatalf("expected a query on %s, got %q", tc.want, q)
+					}
+				}
+			}
+			if !read {
+				t.Fatalf("expected the head to be read, got %q", rec.Queries())
+			}
+		})
+	}
+
+	t.Run("invalid default", func(t *testing.T) {
+		SetDefaultOptions(func(*Options) error { return errors.New("boom") })
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		_, err := CurrentVersion(context.Background(), db)
+		if err == nil || !strings.Contains(err.Error(), "issue with default option #1") {
+			t.Fatalf("expected a default option error, got %v", err)
+		}
+	})
+}
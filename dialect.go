@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"strconv"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// dialect describes how the executor talks to a particular database.
+type dialect struct {
+	// name is used in error messages.
+	name string
+	// flavor selects the statement splitting rules.
+	flavor utils.Flavor
+	// quoteIdent quotes the bookkeeping table name.
+	quoteIdent func(ident string) string
+	// placeholders is the bind parameter style; internal queries are written
+	// with "?" and converted with rebind.
+	placeholders PlaceholderStyle
+	// createTable returns the bookkeeping table DDL for an already quoted name.
+	createTable func(table string) string
+	// tableExists is a query returning a count > 0 when the unquoted table name
+	// bound to its single "?" placeholder exists in the current schema.
+	tableExists string
+	// tableColumns is a query returning (name, type) rows for the columns of
+	// the unquoted table name bound to its single "?", in definition order.
+	tableColumns string
+	// columns is what tableColumns reports for a table created by createTable.
+	columns []column
+	// utcNow is an expression for the current UTC time used to set applied_at
+	// explicitly. It is empty when the column default already records UTC.
+	utcNow string
+	// legacyAppliedAtType is the applied_at type reported by tableColumns for
+	// tables created by older releases, which recorded server-local times.
+	// upgradeAppliedAt returns the statements converting such a table.
+	legacyAppliedAtType string
+	upgradeAppliedAt    func(table string) []string
+	// backendID is a query returning the id of the current connection on the
+	// server and cancelBackend the statement cancelling the query running on
+	// the connection with that id. Both are empty for embedded databases.
+	backendID     string
+	cancelBackend func(id int64) string
+	// insertSentinel returns the statement creating the version 0 row that is
+	// locked with SELECT ... FOR UPDATE to serialize concurrent Apply calls.
+	// Dialects without row-level locking leave it nil.
+	insertSentinel func(table string) string
+	// recordAfterBatch makes the executor write bookkeeping rows in a separate
+	// transaction after each migrations transaction committed, for engines that
+	// cannot mix DDL and bookkeeping DML in one unit. A crash between the two
+	// transactions leaves applied migrations unrecorded.
+	recordAfterBatch bool
+	// capabilities is what DialectCapabilities reports.
+	capabilities Capabilities
+}
+
+var dialects = map[Dialect]dialect{
+	DialectSqlite: {
+		name:         "sqlitedb",
+		flavor:       utils.FlavorSqlite,
+		quoteIdent:   utils.QuoteIdentDouble,
+		placeholders: PlaceholderQuestion,
+		createTable: func(table string) string {
+			return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                version INTEGER PRIMARY KEY,
+                applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+            )`
+		},
+		tableExists:  `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		tableColumns: `SELECT name, type FROM pragma_table_info(?) ORDER BY cid`,
+		columns:      []column{{"version", "INTEGER"}, {"applied_at", "TIMESTAMP"}},
+		capabilities: Capabilities{TransactionalDDL: true, MultiStatementExec: true},
+	},
+	DialectPostgres: {
+		name:         "postgres",
+		flavor:       utils.FlavorPostgres,
+		quoteIdent:   utils.QuoteIdentDouble,
+		placeholders: PlaceholderDollar,
+		createTable: func(table string) string {
+			return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                version INTEGER PRIMARY KEY,
+                applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+            )`
+		},
+		tableExists:  `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`,
+		tableColumns: `SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? ORDER BY ordinal_position`,
+		columns:      []column{{"version", "integer"}, {"applied_at", "timestamp with time zone"}},
+		// The implicit timestamp -> timestamptz cast interprets old values in
+		// the session time zone, which is the zone they were recorded in.
+		legacyAppliedAtType: "timestamp without time zone",
+		upgradeAppliedAt: func(table string) []string {
+			return []string{`ALTER TABLE ` + table + ` ALTER COLUMN applied_at TYPE TIMESTAMPTZ`}
+		},
+		backendID: `SELECT pg_backend_pid()`,
+		cancelBackend: func(id int64) string {
+			return `SELECT pg_cancel_backend(` + strconv.FormatInt(id, 10) + `)`
+		},
+		insertSentinel: func(table string) string {
+			return `INSERT INTO ` + table + ` (version) VALUES (0) ON CONFLICT DO NOTHING`
+		},
+		capabilities: Capabilities{TransactionalDDL: true, MultiStatementExec: true, AdvisoryLocks: true, ConcurrentIndex: true},
+	},
+	DialectMysql: {
+		name:         "mysql",
+		flavor:       utils.FlavorMysql,
+		quoteIdent:   utils.QuoteIdentBacktick,
+		placeholders: PlaceholderQuestion,
+		createTable: func(table string) string {
+			return `CREATE TABLE IF NOT EXISTS ` + table + `(
+                version INT NOT NULL PRIMARY KEY,
+                applied_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+            )`
+		},
+		tableExists:  `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`,
+		tableColumns: `SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position`,
+		columns:      []column{{"version", "int"}, {"applied_at", "datetime"}},
+		// DATETIME has no time zone, so rows are written with UTC_TIMESTAMP
+		// instead of the session-local column default.
+		utcNow:              `UTC_TIMESTAMP(6)`,
+		legacyAppliedAtType: "timestamp",
+		upgradeAppliedAt: func(table string) []string {
+			// TIMESTAMP values are stored in UTC and converted to the session
+			// time zone on MODIFY, so run it in UTC and restore the zone after.
+			return []string{
+				`SET @migrations_time_zone = @@session.time_zone`,
+				`SET time_zone = '+00:00'`,
+				`ALTER TABLE ` + table + ` MODIFY applied_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)`,
+				`SET time_zone = @migrations_time_zone`,
+			}
+		},
+		backendID: `SELECT CONNECTION_ID()`,
+		cancelBackend: func(id int64) string {
+			return `KILL QUERY ` + strconv.FormatInt(id, 10)
+		},
+		insertSentinel: func(table string) string {
+			return `INSERT IGNORE INTO ` + table + ` (version, applied_at) VALUES (0, UTC_TIMESTAMP(6))`
+		},
+		capabilities: Capabilities{AdvisoryLocks: true},
+	},
+}
+
+// rebind converts "?" placeholders in an internal query to the dialect style.
+func (d dialect) rebind(query string) string {
+	return rewritePlaceholders(query, PlaceholderQuestion, d.placeholders, d.flavor == utils.FlavorMysql)
+}
+
+// insertVersion returns the bookkeeping INSERT for an already quoted table
+// name, with version as the value expression ("?" or a literal).
+func (d dialect) insertVersion(table, version string) string {
+	if d.utcNow == "" {
+		return "INSERT INTO " + table + " (version) VALUES (" + version + ")"
+	}
+	return "INSERT INTO " + table + " (version, applied_at) VALUES (" + version + ", " + d.utcNow + ")"
+}
+
+// createTableStmt returns the bookkeeping table DDL for the dialect.
+func createTableStmt(d Dialect, table string) string {
+	spec := dialects[d]
+	return spec.createTable(spec.quoteIdent(table))
+}
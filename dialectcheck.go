@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrDialectMismatch is returned by Apply with WithDialectCheck when applied
+// migrations were recorded with another dialect. The concrete error is a
+// *DialectMismatchError.
+var ErrDialectMismatch = errors.New("migration dialect mismatch")
+
+// DialectMismatchError reports applied migrations recorded with a dialect
+// other than the one Apply runs with. It matches ErrDialectMismatch with
+// errors.Is.
+type DialectMismatchError struct {
+	// Dialect is the dialect Apply runs with.
+	Dialect Dialect
+	// Recorded is the dialect the first of Versions was applied with.
+	Recorded Dialect
+	// Versions lists the applied versions recorded with another dialect.
+	Versions []int
+}
+
+func (e *DialectMismatchError) Error() string {
+	return fmt.Sprintf("%s: versions %v were applied with %s, not %s", ErrDialectMismatch, e.Versions, e.Recorded, e.Dialect)
+}
+
+func (e *DialectMismatchError) Unwrap() error {
+	return ErrDialectMismatch
+}
+
+// createDialectTable returns the DDL of the sidecar table holding the dialect
+// every version was applied with, used by WithDialectCheck.
+func createDialectTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                version INTEGER PRIMARY KEY,
+                dialect VARCHAR(16) NOT NULL
+            )`
+}
+
+// checkDialect fails when versions up to head were recorded with another
+// dialect than opts.Dialect, and records the missing ones.
+func checkDialect(ctx context.Context, tx *sql.Tx, d dialect, table string, opts Options, head int) error {
+	if _, err := tx.ExecContext(ctx, createDialectTable(table)); err != nil {
+		return fmt.Errorf("failed to create migrations dialect table: %w", err)
+	}
+	recorded, err := readDialects(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+
+	mismatch := &DialectMismatchError{Dialect: opts.Dialect}
+	for version := 1; version <= head; version++ {
+		dialect, ok := recorded[version]
+		if !ok {
+			// Applied before the check was enabled: assume the current dialect.
+			if err := recordDialect(ctx, tx, d, table, version, opts.Dialect); err != nil {
+				return err
+			}
+			continue
+		}
+		if dialect != opts.Dialect {
+			if len(mismatch.Versions) == 0 {
+				mismatch.Recorded = dialect
+			}
+			mismatch.Versions = append(mismatch.Versions, version)
+		}
+	}
+	if len(mismatch.Versions) > 0 {
+		return mismatch
+	}
+	return nil
+}
+
+func recordDialect(ctx context.Context, tx *sql.Tx, d dialect, table string, version int, dialect Dialect) error {
+	insert := d.rebind("INSERT INTO " + table + " (version, dialect) VALUES (?, ?)")
+	if _, err := tx.ExecContext(ctx, insert, version, dialect.String()); err != nil {
+		return fmt.Errorf("failed to record dialect of migration #%d: %w", version, err)
+	}
+	return nil
+}
+
+// readDialects returns the dialect recorded for every version in table.
+func readDialects(ctx context.Context, q queryer, table string) (map[int]Dialect, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, dialect FROM "+table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration dialects: %w", err)
+	}
+	defer rows.Close()
+	recorded := map[int]Dialect{}
+	for rows.Next() {
+		var version int
+		var name string
+		if err := rows.Scan(&version, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan migration dialect: %w", err)
+		}
+		dialects, err := parseDialects([]string{name})
+		if err != nil {
+			return nil, fmt.Errorf("migration #%d: %w", version, err)
+		}
+		recorded[version] = dialects[0]
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read migration dialects: %w", err)
+	}
+	return recorded, nil
+}
+
+// AppliedDialects returns the dialect every applied version was recorded with
+// by Apply with WithDialectCheck, without changing the database. Versions
+// applied without the check are missing from the result, which is empty when
+// the check was never enabled.
+func AppliedDialects(ctx context.Context, db *sql.DB, userOptions ...Option) (map[int]Dialect, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return nil, err
+	}
+	d := dialects[opts.Dialect]
+	table := opts.TableName + "_dialect"
+
+	var existingTables int
+	if err := db.QueryRowContext(ctx, d.rebind(d.tableExists), table).Scan(&existingTables); err != nil {
+		return nil, fmt.Errorf("failed to check if migrations dialect table %q exists: %w", table, err)
+	}
+	if existingTables == 0 {
+		return map[int]Dialect{}, nil
+	}
+	return readDialects(ctx, db, d.quoteIdent(table))
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestDialectCheck(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2"}
+
+	t.Run("records dialects", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{1})
+		rec.Return("SELECT version, dialect", []string{"version", "dialect"})
+
+		if err := Apply(context.Background(), db, migs, WithDialectCheck(true)); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		var recorded []any
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, `INSERT INTO "migrations_dialect"`) {
+				recorded = append(recorded, s.Args...)
+			}
+		}
+		// Version 1 was applied before the check was enabled.
+		want := []any{int64(1), "sqlite", int64(2), "sqlite"}
+		if !reflect.DeepEqual(recorded, want) {
+			t.Fatalf("got %v, want %v", recorded, want)
+		}
+	})
+
+	t.Run("detects another dialect", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{2})
+		rec.Return("SELECT version, dialect", []string{"version", "dialect"}, []any{1, "postgres"}, []any{2, "postgres"})
+
+		err := Apply(context.Background(), db, migs, WithDialectCheck(true))
+		var mismatch *DialectMismatchError
+		if !errors.As(err, &mismatch) || !errors.Is(err, ErrDialectMismatch) {
+			t.Fatalf("expected *DialectMismatchError, got %v", err)
+		}
+		want := DialectMismatchError{Dialect: DialectSqlite, Recorded: DialectPostgres, Versions: []int{1, 2}}
+		if !reflect.DeepEqual(*mismatch, want) {
+			t.Fatalf("got %+v, want %+v", *mismatch, want)
+		}
+		if !strings.Contains(err.Error(), "versions [1 2] were applied with postgres, not sqlite") {
+			t.Fatalf("unexpected message %q", err)
+		}
+
+		rec.Return("sqlite_master", []string{"count"}, []any{1})
+		got, err := AppliedDialects(context.Background(), db)
+		if err != nil {
+			t.Fatalf("applied dialects: %v", err)
+		}
+		if !reflect.DeepEqual(got, map[int]Dialect{1: DialectPostgres, 2: DialectPostgres}) {
+			t.Fatalf("got %v", got)
+		}
+	})
+}
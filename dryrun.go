@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// DryRun returns the Plan Apply would execute against db right now together
+// with its Statements, without opening a transaction or changing the
+// database, e.g. for CI gates and change review:
+//
+//	plan, err := migrations.DryRun(ctx, db, migs, opts...)
+//	if err != nil { ... }
+//	for _, stmt := range plan.Statements {
+//		fmt.Printf("-- migration %d\n%s;\n", stmt.Version, stmt.SQL)
+//	}
+//
+// It fails like Apply would before running anything: on invalid options, an
+// empty or oversized migration list, a truncated history, a pending migration
+// that cannot be split cleanly (see Validate), or an environment variable
+// WithEnvAllowlist does not allow. Statements carry the StatementTag and the
+// statements of the StatementInjector, with environment variables
+// interpolated and Maskers applied; indexes deferred by WithConcurrentIndexes
+// come last. Bookkeeping statements are left out.
+func DryRun(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (Plan, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return Plan{}, err
+	}
+	if opts.RequireNonEmpty && len(migrations) == 0 {
+		return Plan{}, ErrEmptyMigrations
+	}
+	if err := checkMigrationSizes(migrations, opts); err != nil {
+		return Plan{}, err
+	}
+	d := dialects[opts.Dialect]
+	from, err := readHead(ctx, db, d, opts.TableName)
+	if err != nil {
+		return Plan{}, err
+	}
+	if err := checkTruncatedHistory(ctx, db, d, from, len(migrations), opts); err != nil {
+		return Plan{}, err
+	}
+
+	plan := newPlan(migrations, min(from, len(migrations)), opts)
+	mask := masking(opts)
+	var indexes []PlannedStatement
+	for _, version := range plan.Versions {
+		migration := migrations[version-1]
+		stmts, err := utils.SplitStatementsStrictFlavor(migration, d.flavor)
+		if err != nil {
+			return Plan{}, fmt.Errorf("failed to split migration #%d: %w", version, err)
+		}
+		if opts.StatementInjector != nil {
+			before, after := opts.StatementInjector(Migration{Version: int64(version), UpSQL: migration})
+			stmts = slices.Concat(before, stmts, after)
+		}
+		tag := ""
+		if opts.StatementTag != "" {
+			tag = "/* migration " + strconv.Itoa(version) + ": " + opts.StatementTag + " */ "
+		}
+		for i, stmt := range stmts {
+			if opts.EnvAllowlist != nil {
+				if stmt, err = interpolateEnv(stmt, opts.EnvAllowlist); err != nil {
+					return Plan{}, fmt.Errorf("migration #%d, statement #%d: %w", version, i+1, err)
+				}
+			}
+			if opts.ConcurrentIndexes {
+				if index, ok := concurrentIndex(stmt); ok {
+					indexes = append(indexes, PlannedStatement{Version: version, SQL: tag + index})
+					continue
+				}
+			}
+			if mask {
+				stmt = maskInsert(stmt, opts.Maskers, opts.Dialect == DialectMysql)
+			}
+			plan.Statements = append(plan.Statements, PlannedStatement{Version: version, SQL: tag + stmt})
+		}
+	}
+	plan.Statements = append(plan.Statements, indexes...)
+	return plan, nil
+}
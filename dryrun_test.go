@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestDryRun(t *testing.T) {
+	migs := []string{
+		"CREATE TABLE users (id INT, email TEXT)",
+		"CREATE INDEX users_email ON users (email); INSERT INTO users (id, email) VALUES (1, 'a@example.com')",
+	}
+
+	t.Run("lists pending statements", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("information_schema.tables", []string{"count"}, []any{1})
+		rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+		plan, err := DryRun(context.Background(), db, migs,
+			WithDialect(DialectPostgres),
+			WithStatementTag("sha=abc"),
+			WithConcurrentIndexes(true),
+			WithEnvironment("staging"),
+			WithMasker("users.email", func(string) string { return "masked" }),
+			WithStatementInjector(func(m Migration) (before, after []string) {
+				return nil, []string{"ANALYZE users"}
+			}),
+		)
+		if err != nil {
+			t.Fatalf("dry run: %v", err)
+		}
+		want := []PlannedStatement{
+			{Version: 2, SQL: "/* migration 2: sha=abc */ INSERT INTO users (id, email) VALUES (1, 'masked')"},
+			{Version: 2, SQL: "/* migration 2: sha=abc */ ANALYZE users"},
+			{Version: 2, SQL: "/* migration 2: sha=abc */ CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email)"},
+		}
+		if !reflect.DeepEqual(plan.Statements, want) {
+			t.Fatalf("got %+v, want %+v", plan.Statements, want)
+		}
+		if !reflect.DeepEqual(plan.Versions, []int{2}) || plan.FromVersion != 1 {
+			t.Fatalf("unexpected plan %+v", plan)
+		}
+		for _, q := range rec.Queries() {
+			if !strings.HasPrefix(q, "SELECT") {
+				t.Fatalf("expected read-only queries, got %q", rec.Queries())
+			}
+		}
+	})
+
+	t.Run("fails like apply", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("sqlite_master", []string{"count"}, []any{1})
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		_, err := DryRun(context.Background(), db, []string{"SELECT 1", "SELECT 'unterminated"})
+		if err == nil || !strings.Contains(err.Error(), "migration #2") {
+			t.Fatalf("expected a split error for migration #2, got %v", err)
+		}
+		if _, err := DryRun(context.Background(), db, nil, WithRequireNonEmpty(true)); !errors.Is(err, ErrEmptyMigrations) {
+			t.Fatalf("got %v, want %v", err, ErrEmptyMigrations)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrEnvNotAllowed is returned by Apply with WithEnvAllowlist when a migration
+// refers to a variable that is not in the allowlist.
+var ErrEnvNotAllowed = errors.New("environment variable not in allowlist")
+
+// ErrEnvUnset is returned by Apply with WithEnvAllowlist when a migration
+// refers to an allowed variable that is not set.
+var ErrEnvUnset = errors.New("environment variable not set")
+
+// interpolateEnv replaces every ${NAME} in stmt with the value of the
+// environment variable NAME, which must be in allowlist and set, and every
+// $${ with ${. Quoted literals, comments and dollar-quoted bodies are not
+// skipped. Text that only looks similar, e.g. "${a.b}", is left as is.
+func interpolateEnv(stmt string, allowlist []string) (string, error) {
+	if !strings.Contains(stmt, "${") {
+		return stmt, nil
+	}
+	var b strings.Builder
+	rest := stmt
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			break
+		}
+		if start > 0 && rest[start-1] == '$' { // escaped
+			b.WriteString(rest[:start])
+			b.WriteString("{")
+			rest = rest[start+2:]
+			continue
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 || !isEnvName(rest[start+2:start+end]) {
+			b.WriteString(rest[:start+2])
+			rest = rest[start+2:]
+			continue
+		}
+		name := rest[start+2 : start+end]
+		if !slices.Contains(allowlist, name) {
+			return "", fmt.Errorf("%w: ${%s}", ErrEnvNotAllowed, name)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%w: ${%s}", ErrEnvUnset, name)
+		}
+		b.WriteString(rest[:start])
+		b.WriteString(value)
+		rest = rest[start+end+1:]
+	}
+	b.WriteString(rest)
+	return b.String(), nil
+}
+
+// isEnvName reports whether name matches [A-Za-z_][A-Za-z0-9_]*.
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9' && i > 0) {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("MIGRATIONS_SCHEMA", "tenant_a")
+	t.Setenv("MIGRATIONS_EMPTY", "")
+	allowlist := []string{"MIGRATIONS_SCHEMA", "MIGRATIONS_EMPTY", "MIGRATIONS_UNSET"}
+
+	for _, tc := range []struct {
+		name    string
+		stmt    string
+		want    string
+		wantErr error
+	}{
+		{name: "no variables", stmt: "SELECT 1", want: "SELECT 1"},
+		{name: "variables", stmt: "CREATE TABLE ${MIGRATIONS_SCHEMA}.t (id INT)${MIGRATIONS_EMPTY}", want: "CREATE TABLE tenant_a.t (id INT)"},
+		{name: "repeated variable", stmt: "${MIGRATIONS_SCHEMA}.a, ${MIGRATIONS_SCHEMA}.b", want: "tenant_a.a, tenant_a.b"},
+		{name: "literals and bodies", stmt: "CREATE TABLESPACE t LOCATION '/data/${MIGRATIONS_SCHEMA}' -- ${MIGRATIONS_SCHEMA}\nCOMMENT ON t IS $$ ${MIGRATIONS_SCHEMA}$$", want: "CREATE TABLESPACE t LOCATION '/data/tenant_a' -- tenant_a\nCOMMENT ON t IS $$ tenant_a$$"},
+		{name: "escaped", stmt: "SELECT '$${HOME}', $${a.b}, $$$${MIGRATIONS_SCHEMA}$$", want: "SELECT '${HOME}', ${a.b}, $$${MIGRATIONS_SCHEMA}$$"},
+		{name: "not a variable", stmt: "SELECT '${a.b}', '${', '${}', $1", want: "SELECT '${a.b}', '${', '${}', $1"},
+		{name: "not allowed", stmt: "SELECT '${HOME}'", wantErr: ErrEnvNotAllowed},
+		{name: "unset", stmt: "SELECT '${MIGRATIONS_UNSET}'", wantErr: ErrEnvUnset},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := interpolateEnv(tc.stmt, allowlist)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %q, %v", tc.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("interpolate: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEnvAllowlist(t *testing.T) {
+	t.Setenv("MIGRATIONS_TABLESPACE", "fast_ssd")
+	migs := []string{"CREATE TABLE t (id INT) TABLESPACE ${MIGRATIONS_TABLESPACE}"}
+
+	t.Run("interpolates statements", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("SELECT version, checksum", []string{"version", "checksum"})
+
+		err := Apply(context.Background(), db, migs, WithEnvAllowlist("MIGRATIONS_TABLESPACE"), WithChecksums(true))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, "CREATE TABLE t (id INT) TABLESPACE fast_ssd") != 1 {
+			t.Fatalf("expected the interpolated statement, got %q", rec.Queries())
+		}
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, `INSERT INTO "migrations_meta"`) && s.Args[1] != checksum(migs[0]) {
+				t.Fatalf("expected the checksum of the uninterpolated migration, got %v", s.Args)
+			}
+		}
+	})
+
+	t.Run("leaves statements alone by default", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+
+		if err := Apply(context.Background(), db, migs); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, migs[0]) != 1 {
+			t.Fatalf("expected the statement as written, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("fails on variables outside the allowlist", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+
+		err := Apply(context.Background(), db, migs, WithEnvAllowlist("MIGRATIONS_SCHEMA"))
+		if !errors.Is(err, ErrEnvNotAllowed) || !strings.Contains(err.Error(), "${MIGRATIONS_TABLESPACE}") {
+			t.Fatalf("expected ErrEnvNotAllowed, got %v", err)
+		}
+		for _, q := range rec.Queries() {
+			if strings.HasPrefix(q, "CREATE TABLE t") {
+				t.Fatalf("statement must not run, got %q", rec.Queries())
+			}
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+
+		plan, err := DryRun(context.Background(), db, migs, WithEnvAllowlist("MIGRATIONS_TABLESPACE"))
+		if err != nil {
+			t.Fatalf("dry run: %v", err)
+		}
+		if len(plan.Statements) != 1 || plan.Statements[0].SQL != "CREATE TABLE t (id INT) TABLESPACE fast_ssd" {
+			t.Fatalf("got statements %+v", plan.Statements)
+		}
+	})
+
+	t.Run("rejects invalid names", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+
+		err := Apply(context.Background(), db, migs, WithEnvAllowlist("TABLE SPACE"))
+		if err == nil || !strings.Contains(err.Error(), `invalid environment variable name "TABLE SPACE"`) {
+			t.Fatalf("expected a validation error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,536 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// apply runs the pending migrations using the dialect selected in opts.
+func apply(ctx context.Context, db *sql.DB, migrations []string, migs []Migration, opts Options) (Report, error) {
+	return applyDialect(ctx, db, dialects[opts.Dialect], migrations, migs, opts)
+}
+
+// applyDialect runs the pending migrations with d.
+func applyDialect(ctx context.Context, db *sql.DB, d dialect, migrations []string, migs []Migration, opts Options) (Report, error) {
+	start := time.Now()
+	t := d.quoteIdent(opts.TableName)
+	insertStmt := d.rebind(d.insertVersion(t, "?"))
+	chunked := opts.MaxStatementsPerTx > 0
+	progress := d.quoteIdent(opts.TableName + "_progress")
+	fleet := d.quoteIdent(opts.TableName + "_fleet")
+	meta := d.quoteIdent(opts.TableName + "_meta")
+	dialectTable := d.quoteIdent(opts.TableName + "_dialect")
+	exec := opts.Executor
+	if exec == nil {
+		exec = func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+			return tx.ExecContext(ctx, stmt)
+		}
+	}
+
+	var report Report
+	var head int   // MAX(version) expected after the run
+	var fresh bool // the bookkeeping table did not exist before the first transaction
+	for first := true; ; first = false {
+		var chunk Report
+		var chunkUnrecorded []int
+		var chunkIndexes []deferredIndex // built after commit (WithConcurrentIndexes only)
+		var more bool                    // MaxStatementsPerTx was reached, another transaction follows
+		err := opts.TxRunner(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+			// Custom runners may retry fn, so start every attempt from scratch.
+			chunk, head, chunkUnrecorded, chunkIndexes, more = Report{}, 0, nil, nil, false
+
+			var existingTables int
+			if err := tx.QueryRowContext(ctx, d.rebind(d.tableExists), opts.TableName).Scan(&existingTables); err != nil {
+				return fmt.Errorf("failed to check if migrations table %q exists: %w", opts.TableName, err)
+			}
+
+			if _, err := tx.ExecContext(ctx, d.createTable(t)); err != nil {
+				return fmt.Errorf("failed to create migrations table %q: %w", opts.TableName, err)
+			}
+
+			if existingTables > 0 && d.upgradeAppliedAt != nil {
+				if err := upgradeAppliedAt(ctx, tx, d, t, opts.TableName); err != nil {
+					return err
+				}
+			}
+
+			if err := lockTable(ctx, tx, d, t); err != nil {
+				return err
+			}
+
+			if opts.Compatibility == CompatVitess {
+				strategy := opts.DDLStrategy
+				if strategy == "" {
+					strategy = "direct"
+				}
+				if _, err := tx.ExecContext(ctx, "SET @@ddl_strategy = '"+strategy+"'"); err != nil {
+					return fmt.Errorf("failed to set ddl strategy: %w", err)
+				}
+			}
+
+			if opts.ServerSideCancel && d.cancelBackend != nil {
+				stop, err := cancelOnDone(ctx, db, tx, d)
+				if err != nil {
+					return err
+				}
+				defer stop()
+			}
+
+			if chunked {
+				if _, err := tx.ExecContext(ctx, createProgressTable(progress)); err != nil {
+					return fmt.Errorf("failed to create migrations progress table: %w", err)
+				}
+			}
+
+			var lastAppliedVersion int
+			queryLast := "SELECT COALESCE(MAX(version), -1) FROM " + t
+			if err := tx.QueryRowContext(ctx, queryLast).Scan(&lastAppliedVersion); err != nil {
+				return fmt.Errorf("failed to read last applied migration version: %w", err)
+			}
+
+			if err := checkTruncatedHistory(ctx, tx, d, lastAppliedVersion, len(migrations), opts); err != nil {
+				return err
+			}
+			head = lastAppliedVersion
+
+			if first && opts.Checksums {
+				if err := checkChecksums(ctx, tx, d, meta, migrations, migs, lastAppliedVersion); err != nil {
+					return err
+				}
+			}
+
+			if first && opts.DialectCheck {
+				if err := checkDialect(ctx, tx, d, dialectTable, opts, lastAppliedVersion); err != nil {
+					return err
+				}
+			}
+
+			if first && opts.FleetInstance != "" {
+				if err := checkFleet(ctx, tx, d, fleet, opts, lastAppliedVersion, len(migrations)); err != nil {
+					return err
+				}
+			}
+
+			if first && opts.ApprovalVerifier != nil {
+				approved := migrations
+				if opts.limit > 0 {
+					approved = migrations[:min(len(migrations), max(lastAppliedVersion, 0)+opts.limit)]
+				}
+				if err := checkApproval(approved, lastAppliedVersion, opts); err != nil {
+					return err
+				}
+			}
+
+			if first {
+				// A concurrent Apply may have created the table after our check; it
+				// would also have recorded its migrations before we got the lock.
+				fresh = existingTables == 0 && lastAppliedVersion <= 0
+			}
+
+			executed := 0
+			var record *sql.Stmt // insertStmt, prepared once and reused for every version
+			var open io.Closer   // reader of the current Open migration
+			defer func() {
+				if open != nil {
+					open.Close()
+				}
+			}()
+			for version, migration := range migrations {
+				version++ // so first version is 1 instead of 0
+				if version <= lastAppliedVersion {
+					if first {
+						chunk.Skipped++
+					}
+					continue
+				}
+				if opts.limit > 0 && len(report.Applied)+len(chunk.Applied) == opts.limit {
+					break
+				}
+				migrationStart := time.Now()
+				var m Migration // per-migration settings, if any
+				if migs != nil {
+					m = migs[version-1]
+				}
+				var deadline time.Time // bounds every statement of the migration
+				if m.Timeout > 0 {
+					deadline = migrationStart.Add(m.Timeout)
+				}
+				runs := len(m.Dialects) == 0 || slices.Contains(m.Dialects, opts.Dialect)
+				var stmts []string
+				var reader *utils.StatementReader // set instead of stmts for streamed migrations
+				switch {
+				case !runs:
+					// Recorded without running anything on other dialects.
+				case m.Func != nil:
+					if chunked && executed == opts.MaxStatementsPerTx {
+						more = true
+						return nil
+					}
+					if err := withDeadline(ctx, deadline, func(ctx context.Context) error { return m.Func(ctx, tx) }); err != nil {
+						return fmt.Errorf("failed to apply migration #%d: %w", version, err)
+					}
+					executed++
+				case len(m.Statements) > 0:
+					stmts = m.Statements
+				case m.Open != nil:
+					r, err := m.Open()
+					if err != nil {
+						return fmt.Errorf("failed to open migration #%d: %w", version, err)
+					}
+					open = r
+					reader = utils.NewStatementReader(r, d.flavor)
+				default:
+					stmts = utils.SplitStatementsFlavor(migration, d.flavor)
+				}
+				source := &statementSource{stmts: stmts, reader: reader}
+				if opts.StatementInjector != nil && runs && m.Func == nil {
+					injected := m
+					injected.Version = int64(version)
+					if len(m.Statements) == 0 && m.Open == nil {
+						injected.UpSQL = migration
+					}
+					source.before, source.after = opts.StatementInjector(injected)
+				}
+
+				resume := 0 // statements committed by an earlier transaction
+				if chunked && version == lastAppliedVersion+1 {
+					var err error
+					if resume, err = readProgress(ctx, tx, d, progress, version); err != nil {
+						return err
+					}
+				}
+
+				mask := masking(opts)
+				tag := ""
+				if opts.StatementTag != "" {
+					tag = "/* migration " + strconv.Itoa(version) + ": " + opts.StatementTag + " */ "
+				}
+				for i := 0; ; i++ {
+					stmt, injected, err := source.next()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						return fmt.Errorf("failed to read migration #%d: %w", version, err)
+					}
+					if i < resume {
+						continue
+					}
+					if opts.EnvAllowlist != nil {
+						interpolated, err := interpolateEnv(stmt, opts.EnvAllowlist)
+						if err != nil {
+							return fmt.Errorf("failed to apply migration #%d (%s): %w", version, statementLabel(i, stmt, injected), err)
+						}
+						stmt = interpolated
+					}
+					if chunked && executed == opts.MaxStatementsPerTx {
+						more = true
+						if i == 0 {
+							return nil
+						}
+						return saveProgress(ctx, tx, d, progress, version, i)
+					}
+					if opts.ConcurrentIndexes {
+						if index, ok := concurrentIndex(stmt); ok {
+							chunkIndexes = append(chunkIndexes, deferredIndex{version: version, stmt: tag + index})
+							continue
+						}
+					}
+					if mask {
+						stmt = maskInsert(stmt, opts.Maskers, opts.Dialect == DialectMysql)
+					}
+					if opts.DataLossCheck && !opts.ConfirmDataLoss {
+						if err := checkDataLoss(ctx, tx, d, stmt, opts.Dialect == DialectPostgres); err != nil {
+							return fmt.Errorf("failed to apply migration #%d (%s): %w", version, statementLabel(i, stmt, injected), err)
+						}
+					}
+					if err := withDeadline(ctx, deadline, func(ctx context.Context) error {
+						_, err := exec(ctx, tx, tag+stmt)
+						return err
+					}); err != nil {
+						return fmt.Errorf("failed to apply migration #%d (%s): %w", version, statementLabel(i, stmt, injected), err)
+					}
+					executed++
+				}
+				if open != nil {
+					open.Close()
+					open = nil
+				}
+
+				if resume > 0 {
+					if err := clearProgress(ctx, tx, d, progress, version); err != nil {
+						return err
+					}
+				}
+
+				if d.recordAfterBatch {
+					chunkUnrecorded = append(chunkUnrecorded, version)
+				} else {
+					if record == nil {
+						var err error
+						if record, err = tx.PrepareContext(ctx, insertStmt); err != nil {
+							return fmt.Errorf("failed to prepare migration record statement: %w", err)
+						}
+						defer record.Close()
+					}
+					if _, err := record.ExecContext(ctx, version); err != nil {
+						return fmt.Errorf("failed to record migration #%d: %w", version, err)
+					}
+				}
+
+				if opts.Checksums {
+					sum, err := migrationChecksum(m, version, migration)
+					if err != nil {
+						return err
+					}
+					if err := recordChecksum(ctx, tx, d, meta, version, sum); err != nil {
+						return err
+					}
+				}
+				if opts.DialectCheck {
+					if err := recordDialect(ctx, tx, d, dialectTable, version, opts.Dialect); err != nil {
+						return err
+					}
+				}
+
+				head = version
+				chunk.Applied = append(chunk.Applied, version)
+				chunk.Timings = append(chunk.Timings, MigrationTiming{Version: version, Duration: time.Since(migrationStart)})
+			}
+
+			if fresh && opts.OnFreshDatabase != nil {
+				if err := opts.OnFreshDatabase(ctx, tx); err != nil {
+					return fmt.Errorf("fresh database callback failed: %w", err)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to apply migrations for %s: %w", d.name, err)
+		}
+
+		report.Applied = append(report.Applied, chunk.Applied...)
+		report.Timings = append(report.Timings, chunk.Timings...)
+		report.Skipped += chunk.Skipped
+		// Record before the next chunk reads the head, or it would apply the
+		// same migrations again.
+		if len(chunkUnrecorded) > 0 {
+			if err := recordBatch(ctx, db, d, insertStmt, chunkUnrecorded, opts); err != nil {
+				return Report{}, err
+			}
+		}
+		// The chunk's migrations are recorded now, so a later failing chunk
+		// must not leave their indexes unbuilt.
+		if err := buildIndexes(ctx, db, chunkIndexes); err != nil {
+			return Report{}, err
+		}
+		if !more {
+			break
+		}
+	}
+
+	if opts.PostApplyVerification {
+		if err := verifyHead(ctx, db, t, head); err != nil {
+			return Report{}, err
+		}
+	}
+
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// statementSource yields the statements of one migration in execution order:
+// those injected before it, its own, read from stmts or streamed from reader,
+// and those injected after it.
+type statementSource struct {
+	before, stmts, after []string
+	reader               *utils.StatementReader
+}
+
+// next returns the next statement and whether it was injected, or io.EOF
+// after the last one.
+func (s *statementSource) next() (stmt string, injected bool, err error) {
+	if len(s.before) > 0 {
+		stmt, s.before = s.before[0], s.before[1:]
+		return stmt, true, nil
+	}
+	if s.reader != nil {
+		stmt, err := s.reader.Next()
+		if err != io.EOF {
+			return stmt, false, err
+		}
+		s.reader = nil
+	}
+	if len(s.stmts) > 0 {
+		stmt, s.stmts = s.stmts[0], s.stmts[1:]
+		return stmt, false, nil
+	}
+	if len(s.after) > 0 {
+		stmt, s.after = s.after[0], s.after[1:]
+		return stmt, true, nil
+	}
+	return "", false, io.EOF
+}
+
+// statementLabel describes the i-th statement of a migration in errors.
+func statementLabel(i int, stmt string, injected bool) string {
+	if injected {
+		return fmt.Sprintf("injected statement %q", stmt)
+	}
+	return fmt.Sprintf("statement %d", i+1)
+}
+
+// withDeadline calls fn with ctx bounded by deadline, or with ctx itself when
+// deadline is zero.
+func withDeadline(ctx context.Context, deadline time.Time, fn func(ctx context.Context) error) error {
+	if deadline.IsZero() {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	return fn(ctx)
+}
+
+// cancelOnDone watches ctx until stop is called and, if ctx is done first,
+// cancels whatever tx's connection is executing from a separate connection.
+func cancelOnDone(ctx context.Context, db *sql.DB, tx *sql.Tx, d dialect) (stop func(), err error) {
+	var id int64
+	if err := tx.QueryRowContext(ctx, d.backendID).Scan(&id); err != nil {
+		return nil, fmt.Errorf("failed to read connection id: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelTimeout)
+		defer cancel()
+		_, _ = db.ExecContext(cancelCtx, d.cancelBackend(id)) // best effort, the migration error is what matters
+	}()
+	return func() { close(done) }, nil
+}
+
+// lockTable serializes concurrent Apply and RollbackTo calls for the duration
+// of tx by locking the sentinel version 0 row of the already quoted table,
+// creating the row if needed. It does nothing on dialects without row-level
+// locking.
+func lockTable(ctx context.Context, tx *sql.Tx, d dialect, table string) error {
+	if d.insertSentinel == nil {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, d.insertSentinel(table)); err != nil {
+		return fmt.Errorf("failed to ensure sentinel lock row: %w", err)
+	}
+	lockStmt := `SELECT version FROM ` + table + ` WHERE version = 0 FOR UPDATE`
+	if _, err := tx.ExecContext(ctx, lockStmt); err != nil {
+		return fmt.Errorf("failed to lock migrations table: %w", err)
+	}
+	return nil
+}
+
+// cancelTimeout bounds the server-side cancellation request.
+const cancelTimeout = 5 * time.Second
+
+// createProgressTable returns the DDL of the table recording how many
+// statements of a partially applied migration were committed, used with
+// MaxStatementsPerTx.
+func createProgressTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                version INTEGER PRIMARY KEY,
+                statements INTEGER NOT NULL
+            )`
+}
+
+func readProgress(ctx context.Context, tx *sql.Tx, d dialect, table string, version int) (int, error) {
+	var statements int
+	query := d.rebind("SELECT COALESCE(MAX(statements), 0) FROM " + table + " WHERE version = ?")
+	if err := tx.QueryRowContext(ctx, query, version).Scan(&statements); err != nil {
+		return 0, fmt.Errorf("failed to read progress of migration #%d: %w", version, err)
+	}
+	return statements, nil
+}
+
+func saveProgress(ctx context.Context, tx *sql.Tx, d dialect, table string, version, statements int) error {
+	if err := clearProgress(ctx, tx, d, table, version); err != nil {
+		return err
+	}
+	insert := d.rebind("INSERT INTO " + table + " (version, statements) VALUES (?, ?)")
+	if _, err := tx.ExecContext(ctx, insert, version, statements); err != nil {
+		return fmt.Errorf("failed to save progress of migration #%d: %w", version, err)
+	}
+	return nil
+}
+
+func clearProgress(ctx context.Context, tx *sql.Tx, d dialect, table string, version int) error {
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM "+table+" WHERE version = ?"), version); err != nil {
+		return fmt.Errorf("failed to clear progress of migration #%d: %w", version, err)
+	}
+	return nil
+}
+
+// upgradeAppliedAt converts an applied_at column created by an older release,
+// which recorded server-local times, to the dialect's UTC-aware type.
+func upgradeAppliedAt(ctx context.Context, tx *sql.Tx, d dialect, quotedTable, table string) error {
+	cols, err := readColumns(ctx, tx, d, table)
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		if !strings.EqualFold(c.name, "applied_at") || !strings.EqualFold(c.dataType, d.legacyAppliedAtType) {
+			continue
+		}
+		for _, stmt := range d.upgradeAppliedAt(quotedTable) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to upgrade applied_at column of migrations table %q: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyHead re-reads MAX(version) outside of any transaction, on a dedicated
+// connection, and compares it with the version Apply just committed.
+func verifyHead(ctx context.Context, db *sql.DB, table string, want int) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("post-apply verification: failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var got int
+	queryLast := "SELECT COALESCE(MAX(version), -1) FROM " + table
+	if err := conn.QueryRowContext(ctx, queryLast).Scan(&got); err != nil {
+		return fmt.Errorf("post-apply verification: failed to read last applied migration version: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("%w: expected version %d after commit, read %d", ErrVerificationFailed, want, got)
+	}
+	return nil
+}
+
+// recordBatch writes bookkeeping rows for dialects with recordAfterBatch.
+func recordBatch(ctx context.Context, db *sql.DB, d dialect, insertStmt string, unrecorded []int, opts Options) error {
+	err := opts.TxRunner(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		for _, version := range unrecorded {
+			if _, err := tx.ExecContext(ctx, insertStmt, version); err != nil {
+				return fmt.Errorf("failed to record migration #%d: %w", version, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("migrations %v were applied but not recorded for %s: %w", unrecorded, d.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// maxFleetInstanceLen is the size of the fleet table's instance column.
+const maxFleetInstanceLen = 255
+
+// createFleetTable returns the DDL of the table in which WithFleetGuard
+// records the version every instance of a fleet expects.
+func createFleetTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                instance VARCHAR(255) PRIMARY KEY,
+                expected_version INTEGER NOT NULL,
+                seen_at BIGINT NOT NULL
+            )`
+}
+
+// checkFleet registers opts.FleetInstance as expecting version expected and
+// fails with ErrFleetSkew when migrating from head to expected would move the
+// database more than opts.FleetMaxAhead versions past the lowest version the
+// live fleet expects.
+func checkFleet(ctx context.Context, tx *sql.Tx, d dialect, table string, opts Options, head, expected int) error {
+	if _, err := tx.ExecContext(ctx, createFleetTable(table)); err != nil {
+		return fmt.Errorf("failed to create migrations fleet table: %w", err)
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM "+table+" WHERE instance = ?"), opts.FleetInstance); err != nil {
+		return fmt.Errorf("failed to register fleet instance %q: %w", opts.FleetInstance, err)
+	}
+	insert := d.rebind("INSERT INTO " + table + " (instance, expected_version, seen_at) VALUES (?, ?, ?)")
+	if _, err := tx.ExecContext(ctx, insert, opts.FleetInstance, expected, now.Unix()); err != nil {
+		return fmt.Errorf("failed to register fleet instance %q: %w", opts.FleetInstance, err)
+	}
+
+	var cutoff int64
+	if opts.FleetTTL > 0 {
+		cutoff = now.Add(-opts.FleetTTL).Unix()
+	}
+	var lowest int
+	query := d.rebind("SELECT MIN(expected_version) FROM " + table + " WHERE seen_at >= ?")
+	if err := tx.QueryRowContext(ctx, query, cutoff).Scan(&lowest); err != nil {
+		return fmt.Errorf("failed to read fleet versions: %w", err)
+	}
+
+	if limit := lowest + opts.FleetMaxAhead; expected > limit && expected > head {
+		return fmt.Errorf("%w: instance %q expects version %d, but the fleet allows at most %d (lowest expected version %d, max ahead %d)",
+			ErrFleetSkew, opts.FleetInstance, expected, limit, lowest, opts.FleetMaxAhead)
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package migrations
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FromFS reads the migrations stored as .sql files directly in dir of fsys,
+// typically an embed.FS:
+//
+//	//go:embed migrations/*.sql
+//	var migrationFiles embed.FS
+//
+//	migs, err := migrations.FromFS(migrationFiles, "migrations")
+//
+// Every file name must start with its version number, optionally followed by
+// a description: "0001_create_users.sql", "2-add-index.sql", "3.sql". Versions
+// must run from 1 without gaps or duplicates, since a migration's version is
+// its position in the returned slice. Files ending in ".sql.gz" are
+// decompressed transparently, e.g. for large seed data. Other files and
+// subdirectories are ignored.
+//
+// Files written for goose or sql-migrate contribute only their Up section; see
+// ParseMigration. golang-migrate pairs ("0001_init.up.sql" and
+// "0001_init.down.sql") form a single migration whose .down.sql half is never
+// run by Apply. Flyway names are accepted too: "V1__create_users.sql" has
+// version 1 and its undo file "U1__create_users.sql" is kept as the Down half.
+// Flyway's repeatable (R__) migrations and dotted versions are rejected.
+//
+// File names can be held to stricter rules with WithFileNamePattern and
+// WithNamingConvention.
+func FromFS(fsys fs.FS, dir string, options ...FileOption) ([]string, error) {
+	return upSections(fromFS(fsys, dir, false, options))
+}
+
+// LoadFS works like FromFS but returns every migration file as a Migration
+// with its version, the name following the version in the file name, and the
+// Down section kept for rolling migrations back. The result can be passed to
+// ApplyMigrations.
+//
+// Files with a dialect suffix, e.g. "0003_search.postgres.sql" next to
+// "0003_search.mysql.sql", hold dialect-specific variants of a version (see
+// Migration.DialectSQL); a plain "0003_search.sql" is the fallback for the
+// other dialects. Variants hold no Down section: the Down SQL of a version
+// comes from its fallback file or its .down.sql file.
+func LoadFS(fsys fs.FS, dir string, options ...FileOption) ([]Migration, error) {
+	return fromFS(fsys, dir, false, options)
+}
+
+// FromFSAllowGaps works like FromFS but accepts gaps in the version numbers,
+// e.g. when abandoned migration files were deleted: every missing version
+// becomes an empty migration, which Apply records like any other. Files must
+// still not share a version.
+//
+// A file added later with a version below the database's current version is
+// skipped, exactly like in FromFS. At most maxGapFill versions may be missing
+// in total, so timestamp-style versions are rejected.
+func FromFSAllowGaps(fsys fs.FS, dir string, options ...FileOption) ([]string, error) {
+	return upSections(fromFS(fsys, dir, true, options))
+}
+
+// maxGapFill bounds the empty migrations FromFSAllowGaps inserts.
+const maxGapFill = 10000
+
+func fromFS(fsys fs.FS, dir string, allowGaps bool, options []FileOption) ([]Migration, error) {
+	var opts fileOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory %q: %w", dir, err)
+	}
+
+	type file struct {
+		version int
+		name    string
+		goMig   *Migration // set for migrations given with WithGoMigrations
+		variant bool       // only dialect-specific files exist for the version
+	}
+	describe := func(f file) string {
+		if f.goMig != nil {
+			return fmt.Sprintf("Go migration %q", f.name)
+		}
+		return fmt.Sprintf("migration file %q", f.name)
+	}
+	var files []file
+	downs := map[int]string{}                // version -> name of its .down.sql or Flyway undo file
+	variants := map[int]map[Dialect]string{} // version -> names of its .<dialect>.sql files
+	for _, entry := range entries {
+		if entry.IsDir() || !isSQLFile(entry.Name()) {
+			continue
+		}
+		version, err := fileVersion(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFileName(entry.Name(), version, opts); err != nil {
+			return nil, err
+		}
+		if isDownFile(entry.Name()) {
+			if other, ok := downs[version]; ok {
+				return nil, fmt.Errorf("migration files %q and %q have the same version %d", other, entry.Name(), version)
+			}
+			downs[version] = entry.Name()
+			continue
+		}
+		if dialect, ok := fileDialect(entry.Name()); ok {
+			if variants[version] == nil {
+				variants[version] = map[Dialect]string{}
+			}
+			if other, ok := variants[version][dialect]; ok {
+				return nil, fmt.Errorf("migration files %q and %q have the same version %d", other, entry.Name(), version)
+			}
+			variants[version][dialect] = entry.Name()
+			continue
+		}
+		files = append(files, file{version: version, name: entry.Name()})
+	}
+	for version, names := range variants {
+		if !slices.ContainsFunc(files, func(f file) bool { return f.version == version }) {
+			first := ""
+			for _, name := range names {
+				if first == "" || name < first {
+					first = name
+				}
+			}
+			files = append(files, file{version: version, name: first, variant: true})
+		}
+	}
+	for i := range opts.goMigrations {
+		m := &opts.goMigrations[i]
+		files = append(files, file{version: int(m.Version), name: m.Name, goMig: m})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].version < files[j].version })
+
+	if allowGaps && len(files) > 0 {
+		if last := files[len(files)-1]; last.version-len(files) > maxGapFill {
+			return nil, fmt.Errorf("%s has version %d: more than %d versions are missing", describe(last), last.version, maxGapFill)
+		}
+	}
+
+	if opts.convention.UpDownPairs {
+		for _, f := range files {
+			if _, ok := downs[f.version]; !ok && f.goMig == nil {
+				return nil, fmt.Errorf("migration file %q has no matching .down.sql file", f.name)
+			}
+		}
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	for i, f := range files {
+		if i > 0 && f.version == files[i-1].version {
+			if files[i-1].goMig == nil && f.goMig == nil {
+				return nil, fmt.Errorf("migration files %q and %q have the same version %d", files[i-1].name, f.name, f.version)
+			}
+			return nil, fmt.Errorf("%s and %s have the same version %d", describe(files[i-1]), describe(f), f.version)
+		}
+		if !allowGaps && f.version != i+1 {
+			return nil, fmt.Errorf("%s has version %d, want %d: versions must start at 1 without gaps", describe(f), f.version, i+1)
+		}
+		if f.version < 1 {
+			return nil, fmt.Errorf("%s has version %d: versions start at 1", describe(f), f.version)
+		}
+		for len(migrations) < f.version-1 {
+			migrations = append(migrations, Migration{Version: int64(len(migrations) + 1)})
+		}
+		if f.goMig != nil {
+			migrations = append(migrations, *f.goMig)
+			continue
+		}
+		var m Migration
+		if !f.variant {
+			content, err := readFile(fsys, dir, f.name)
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasSuffix(trimGzip(f.name), ".up.sql") {
+				m.UpSQL = content
+			} else if m, err = ParseMigration(content); err != nil {
+				return nil, fmt.Errorf("migration file %q: %w", f.name, err)
+			}
+		}
+		for dialect, name := range variants[f.version] {
+			content, err := readFile(fsys, dir, name)
+			if err != nil {
+				return nil, err
+			}
+			variant, err := ParseMigration(content)
+			if err != nil {
+				return nil, fmt.Errorf("migration file %q: %w", name, err)
+			}
+			if variant.DownSQL != "" {
+				return nil, fmt.Errorf("migration file %q: dialect variants cannot have a Down section, put it in the fallback file", name)
+			}
+			if m.DialectSQL == nil {
+				m.DialectSQL = map[Dialect]string{}
+			}
+			m.DialectSQL[dialect] = variant.UpSQL
+		}
+		if down, ok := downs[f.version]; ok {
+			if m.DownSQL != "" {
+				return nil, fmt.Errorf("migration file %q: version %d already has a Down section in %q", down, f.version, f.name)
+			}
+			content, err := readFile(fsys, dir, down)
+			if err != nil {
+				return nil, err
+			}
+			m.DownSQL = content
+			delete(downs, f.version)
+		}
+		m.Version, m.Name = int64(f.version), fileName(f.name)
+		migrations = append(migrations, m)
+	}
+	if len(downs) > 0 {
+		orphan := -1
+		for version := range downs {
+			if orphan < 0 || version < orphan {
+				orphan = version
+			}
+		}
+		return nil, fmt.Errorf("migration file %q has no matching up migration for version %d", downs[orphan], orphan)
+	}
+	return migrations, nil
+}
+
+// upSections returns the Up section of every migration.
+func upSections(migrations []Migration, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range migrations {
+		if m.Func != nil {
+			return nil, fmt.Errorf("Go migration %q (version %d) can only be loaded with LoadFS", m.Name, m.Version)
+		}
+		if len(m.DialectSQL) > 0 {
+			return nil, fmt.Errorf("migration %q (version %d) has dialect-specific files and can only be loaded with LoadFS", m.Name, m.Version)
+		}
+	}
+	return upSQL(migrations, dialectBegin)
+}
+
+// fileVersion parses the leading version number of a migration file name,
+// which may use Flyway's "V<version>__" and "U<version>__" prefixes.
+func fileVersion(name string) (int, error) {
+	rest, flyway := flywayName(name)
+	if strings.HasPrefix(name, "R__") {
+		return 0, fmt.Errorf("migration file %q: Flyway repeatable migrations are not supported", name)
+	}
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	if digits == 0 {
+		return 0, fmt.Errorf("migration file %q does not start with a version number", name)
+	}
+	if flyway && !strings.HasPrefix(rest[digits:], "__") && trimGzip(rest[digits:]) != ".sql" {
+		return 0, fmt.Errorf("migration file %q: only whole-number Flyway versions followed by \"__\" are supported", name)
+	}
+	if after := rest[digits:]; !flyway && len(after) > 1 && after[0] == '.' && after[1] >= '0' && after[1] <= '9' {
+		// A hotfix numbered between two merged migrations would shift the
+		// positional versions of every later one on databases that applied them.
+		return 0, fmt.Errorf("migration file %q: fractional versions are not supported, give a hotfix the next free version instead", name)
+	}
+	version, err := strconv.Atoi(rest[:digits])
+	if err != nil {
+		return 0, fmt.Errorf("migration file %q: invalid version: %w", name, err)
+	}
+	return version, nil
+}
+
+// fileName returns the description part of a migration file name, e.g.
+// "create_users" for "0001_create_users.up.sql" or "V1__create_users.sql".
+func fileName(name string) string {
+	rest, _ := flywayName(trimGzip(name))
+	rest = strings.TrimLeft(rest, "0123456789")
+	for _, suffix := range []string{".up.sql", ".down.sql", ".sql"} {
+		if trimmed, ok := strings.CutSuffix(rest, suffix); ok {
+			rest = trimmed
+			break
+		}
+	}
+	if dialect, ok := fileDialect(name); ok {
+		rest = strings.TrimSuffix(rest, "."+dialect.String())
+	}
+	return strings.TrimLeft(rest, "_-.")
+}
+
+// flywayName strips the "V" (versioned) or "U" (undo) prefix of a Flyway
+// migration file name.
+func flywayName(name string) (rest string, ok bool) {
+	if len(name) > 1 && (name[0] == 'V' || name[0] == 'U') && name[1] >= '0' && name[1] <= '9' {
+		return name[1:], true
+	}
+	return name, false
+}
+
+// isDownFile reports whether name holds the Down half of a migration: a
+// golang-migrate "<version>_<name>.down.sql" or a Flyway "U<version>__<name>.sql" file.
+func isDownFile(name string) bool {
+	_, flyway := flywayName(name)
+	return strings.HasSuffix(trimGzip(name), ".down.sql") || (flyway && name[0] == 'U')
+}
+
+// fileDialect reports whether name holds the variant of a migration for one
+// dialect, e.g. "0003_search.postgres.sql", and returns the dialect.
+func fileDialect(name string) (Dialect, bool) {
+	base := strings.TrimSuffix(trimGzip(name), ".sql")
+	for d := dialectBegin + 1; d < dialectEnd; d++ {
+		if strings.HasSuffix(base, "."+d.String()) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// isSQLFile reports whether name is a migration file: ".sql", or ".sql.gz"
+// for gzip-compressed ones.
+func isSQLFile(name string) bool {
+	return strings.HasSuffix(trimGzip(name), ".sql")
+}
+
+func trimGzip(name string) string {
+	return strings.TrimSuffix(name, ".gz")
+}
+
+// readFile returns the content of the migration file name in dir,
+// decompressing .gz files.
+func readFile(fsys fs.FS, dir, name string) (string, error) {
+	content, err := fs.ReadFile(fsys, path.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read migration file %q: %w", name, err)
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return string(content), nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress migration file %q: %w", name, err)
+	}
+	var b strings.Builder
+	if _, err := io.Copy(&b, zr); err != nil {
+		return "", fmt.Errorf("failed to decompress migration file %q: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// FromDir reads the migrations stored as .sql files in the directory at path,
+// for deployments that ship migrations next to the binary instead of
+// embedding them. File naming and ordering rules are the same as for FromFS.
+func FromDir(path string, options ...FileOption) ([]string, error) {
+	return FromFS(os.DirFS(path), ".", options...)
+}
+
+// MustFromFS is like FromFS but panics on failure. It is meant for
+// package-level variables next to the embed directive, so that a misnamed
+// file fails unit tests instead of production startup:
+//
+//	//go:embed migrations/*.sql
+//	var migrationFiles embed.FS
+//
+//	var migs = migrations.MustFromFS(migrationFiles, "migrations")
+func MustFromFS(fsys fs.FS, dir string, options ...FileOption) []string {
+	migrations, err := FromFS(fsys, dir, options...)
+	if err != nil {
+		panic("migrations: " + err.Error())
+	}
+	return migrations
+}
@@ -0,0 +1,331 @@
+package migrations
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_index.sql": {Data: []byte("CREATE INDEX i ON t (id)")},
+		"migrations/0001_init.sql":      {Data: []byte("CREATE TABLE t (id INT)")},
+		"migrations/10.sql":             {Data: []byte("SELECT 10")},
+		"migrations/README.md":          {Data: []byte("docs")},
+		"migrations/old/0001_x.sql":     {Data: []byte("SELECT 0")},
+	}
+	for v := 3; v <= 9; v++ {
+		fsys[fmt.Sprintf("migrations/%03d.sql", v)] = &fstest.MapFile{Data: []byte("SELECT 1")}
+	}
+
+	got, err := FromFS(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 10 {
+		t.Fatalf("got %d migrations, want 10", len(got))
+	}
+	if !reflect.DeepEqual(got[:2], []string{"CREATE TABLE t (id INT)", "CREATE INDEX i ON t (id)"}) || got[9] != "SELECT 10" {
+		t.Fatalf("unexpected order: %q", got)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"gap", fstest.MapFS{"m/1.sql": {}, "m/3.sql": {}}, `"3.sql" has version 3, want 2`},
+		{"duplicate", fstest.MapFS{"m/1_a.sql": {}, "m/1_b.sql": {}}, "have the same version 1"},
+		{"starts at zero", fstest.MapFS{"m/0_a.sql": {}}, "has version 0, want 1"},
+		{"no version", fstest.MapFS{"m/init.sql": {}}, "does not start with a version number"},
+		{"fractional version", fstest.MapFS{"m/1_a.sql": {}, "m/1.1_hotfix.sql": {}}, `"1.1_hotfix.sql": fractional versions are not supported`},
+		{"missing dir", fstest.MapFS{}, `failed to read migrations directory "m"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FromFS(tc.files, "m")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+//go:embed testdata/migrations/*.sql
+var testMigrationFiles embed.FS
+
+func TestMustFromFS(t *testing.T) {
+	got := MustFromFS(testMigrationFiles, "testdata/migrations")
+	want := []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY);\n",
+		"ALTER TABLE users ADD COLUMN name TEXT;\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic for missing directory")
+		}
+	}()
+	MustFromFS(testMigrationFiles, "testdata/missing")
+}
+
+func TestFromDir(t *testing.T) {
+	got, err := FromDir("testdata/migrations")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0] != "CREATE TABLE users (id INTEGER PRIMARY KEY);\n" {
+		t.Fatalf("unexpected migrations %q", got)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "init.sql"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromDir(dir); err == nil || !strings.Contains(err.Error(), `"init.sql" does not start with a version number`) {
+		t.Fatalf("got error %v", err)
+	}
+	if _, err := FromDir(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error for missing directory")
+	}
+}
+
+func TestFromFSAllowGaps(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_init.sql":  {Data: []byte("SELECT 1")},
+		"m/0002_users.sql": {Data: []byte("SELECT 2")},
+		"m/0005_index.sql": {Data: []byte("SELECT 5")},
+	}
+	got, err := FromFSAllowGaps(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"SELECT 1", "SELECT 2", "", "", "SELECT 5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"duplicate", fstest.MapFS{"m/1_a.sql": {}, "m/1_b.sql": {}}, "have the same version 1"},
+		{"zero", fstest.MapFS{"m/0_a.sql": {}}, "versions start at 1"},
+		{"timestamps", fstest.MapFS{"m/20240101120000_a.sql": {}}, "versions are missing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FromFSAllowGaps(tc.files, "m")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFromFSUpDownPairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/000001_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"m/000001_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"m/000002_index.up.sql":   {Data: []byte("CREATE INDEX i ON users (id);")},
+		"m/000003_name.sql":       {Data: []byte("ALTER TABLE users ADD name TEXT;")},
+	}
+
+	got, err := LoadFS(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "users", UpSQL: "CREATE TABLE users (id INT);", DownSQL: "DROP TABLE users;"},
+		{Version: 2, Name: "index", UpSQL: "CREATE INDEX i ON users (id);"},
+		{Version: 3, Name: "name", UpSQL: "ALTER TABLE users ADD name TEXT;"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"orphan down", fstest.MapFS{"m/1_a.up.sql": {}, "m/2_b.down.sql": {}}, `"2_b.down.sql" has no matching up migration for version 2`},
+		{"duplicate down", fstest.MapFS{"m/1_a.up.sql": {}, "m/1_a.down.sql": {}, "m/1_b.down.sql": {}}, "have the same version 1"},
+		{"duplicate up", fstest.MapFS{"m/1_a.up.sql": {}, "m/1_b.up.sql": {}}, "have the same version 1"},
+		{
+			"two down sections",
+			fstest.MapFS{"m/1_a.sql": {Data: []byte("-- +goose Up\n-- +goose Down\nSELECT 1;\n")}, "m/1_a.down.sql": {}},
+			`version 1 already has a Down section in "1_a.sql"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadFS(tc.files, "m")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFromFSFlyway(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/V1__create_users.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+		"m/U1__create_users.sql": {Data: []byte("DROP TABLE users;")},
+		"m/V2__add_index.sql":    {Data: []byte("CREATE INDEX i ON users (id);")},
+	}
+
+	got, err := LoadFS(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "create_users", UpSQL: "CREATE TABLE users (id INT);", DownSQL: "DROP TABLE users;"},
+		{Version: 2, Name: "add_index", UpSQL: "CREATE INDEX i ON users (id);"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"repeatable", fstest.MapFS{"m/V1__a.sql": {}, "m/R__views.sql": {}}, "repeatable migrations are not supported"},
+		{"dotted version", fstest.MapFS{"m/V1.1__a.sql": {}}, "only whole-number Flyway versions"},
+		{"lowercase prefix", fstest.MapFS{"m/v1__a.sql": {}}, "does not start with a version number"},
+		{"orphan undo", fstest.MapFS{"m/V1__a.sql": {}, "m/U2__b.sql": {}}, `"U2__b.sql" has no matching up migration for version 2`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadFS(tc.files, "m")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFromFSGzip(t *testing.T) {
+	gz := func(s string) []byte {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	fsys := fstest.MapFS{
+		"m/0001_init.sql":         {Data: []byte("CREATE TABLE t (id INT)")},
+		"m/0002_seed.up.sql.gz":   {Data: gz("INSERT INTO t VALUES (1)")},
+		"m/0002_seed.down.sql.gz": {Data: gz("DELETE FROM t")},
+		"m/0003_backfill.sql.gz":  {Data: gz("-- +goose Up\nUPDATE t SET id = 2;\n")},
+		"m/0004_notes.txt.gz":     {Data: []byte("ignored")},
+	}
+	got, err := LoadFS(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "init", UpSQL: "CREATE TABLE t (id INT)"},
+		{Version: 2, Name: "seed", UpSQL: "INSERT INTO t VALUES (1)", DownSQL: "DELETE FROM t"},
+		{Version: 3, Name: "backfill", UpSQL: "UPDATE t SET id = 2;\n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	fsys["m/0004_broken.sql.gz"] = &fstest.MapFile{Data: []byte("not gzip")}
+	if _, err := LoadFS(fsys, "m"); err == nil || !strings.Contains(err.Error(), `failed to decompress migration file "0004_broken.sql.gz"`) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestLoadFSGoMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_init.sql":        {Data: []byte("CREATE TABLE users (id INT, email TEXT)")},
+		"m/0003_email_index.sql": {Data: []byte("CREATE INDEX i ON users (email)")},
+	}
+	backfill := Migration{Version: 2, Name: "backfill_email", Func: func(context.Context, *sql.Tx) error { return nil }}
+
+	got, err := LoadFS(fsys, "m", WithGoMigrations(backfill))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 3 || got[0].Name != "init" || got[1].Name != "backfill_email" || got[1].Func == nil || got[2].Name != "email_index" {
+		t.Fatalf("got %+v", got)
+	}
+
+	if _, err := FromFS(fsys, "m", WithGoMigrations(backfill)); err == nil || !strings.Contains(err.Error(), `Go migration "backfill_email" (version 2) can only be loaded with LoadFS`) {
+		t.Fatalf("got error %v", err)
+	}
+
+	backfill.Version = 1
+	_, err = LoadFS(fsys, "m", WithGoMigrations(backfill))
+	if err == nil || !strings.Contains(err.Error(), `migration file "0001_init.sql" and Go migration "backfill_email" have the same version 1`) {
+		t.Fatalf("got error %v", err)
+	}
+
+	backfill.Version = 0
+	if _, err := LoadFS(fsys, "m", WithGoMigrations(backfill)); err == nil || !strings.Contains(err.Error(), `Go migration "backfill_email" has version 0`) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestLoadFSDialectVariants(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_init.sql":            {Data: []byte("CREATE TABLE docs (id INT, body TEXT)")},
+		"m/0002_search.sql":          {Data: []byte("SELECT 1")},
+		"m/0002_search.postgres.sql": {Data: []byte("-- +goose Up\nCREATE INDEX docs_body ON docs USING gin (to_tsvector('english', body));\n")},
+		"m/0003_fulltext.mysql.sql":  {Data: []byte("ALTER TABLE docs ADD FULLTEXT (body)")},
+	}
+	got, err := LoadFS(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "init", UpSQL: "CREATE TABLE docs (id INT, body TEXT)"},
+		{Version: 2, Name: "search", UpSQL: "SELECT 1", DialectSQL: map[Dialect]string{
+			DialectPostgres: "CREATE INDEX docs_body ON docs USING gin (to_tsvector('english', body));\n",
+		}},
+		{Version: 3, Name: "fulltext", DialectSQL: map[Dialect]string{DialectMysql: "ALTER TABLE docs ADD FULLTEXT (body)"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	up, err := upSQL(got, DialectMysql)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{want[0].UpSQL, "SELECT 1", "ALTER TABLE docs ADD FULLTEXT (body)"}; !reflect.DeepEqual(up, want) {
+		t.Fatalf("got %q, want %q", up, want)
+	}
+	if up, err = upSQL(got[:2], DialectPostgres); err != nil || up[1] != want[1].DialectSQL[DialectPostgres] {
+		t.Fatalf("got %q, %v", up, err)
+	}
+	if _, err := upSQL(got, DialectSqlite); err == nil || !strings.Contains(err.Error(), "migration #3 has no SQL for dialect sqlite") {
+		t.Fatalf("got error %v", err)
+	}
+
+	if _, err := FromFS(fsys, "m"); err == nil || !strings.Contains(err.Error(), "can only be loaded with LoadFS") {
+		t.Fatalf("got error %v", err)
+	}
+
+	fsys["m/0003_fulltext.mysql.sql"] = &fstest.MapFile{Data: []byte("-- +migrate Up\nALTER TABLE docs ADD FULLTEXT (body);\n-- +migrate Down\nALTER TABLE docs DROP INDEX body;\n")}
+	if _, err := LoadFS(fsys, "m"); err == nil || !strings.Contains(err.Error(), `migration file "0003_fulltext.mysql.sql": dialect variants cannot have a Down section`) {
+		t.Fatalf("got error %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package migrations
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// GenerateScript renders the SQL that Apply would execute for every migration
+// with version > fromVersion, without connecting to a database. It is meant for
+// air-gapped or restricted environments where a DBA runs the script by hand.
+//
+// The script creates the bookkeeping table if needed, wraps all migrations in a
+// single transaction and records each version right after its statements, just
+// like Apply. It assumes the target database is exactly at fromVersion; unlike
+// Apply it cannot skip versions that are already recorded.
+//
+// On MySQL, statements with semicolons of their own, such as routines and
+// triggers with BEGIN ... END bodies, are wrapped in DELIMITER $$ ... $$
+// DELIMITER ; for the mysql client; such a statement must not contain "$$".
+//
+// The bookkeeping table name can be changed with WithTableName; the dialect
+// argument always takes precedence over WithDialect.
+func GenerateScript(migrations []string, fromVersion int, dialect Dialect, userOptions ...Option) (string, error) {
+	opts, err := buildOptions(append(userOptions[:len(userOptions):len(userOptions)], WithDialect(dialect)))
+	if err != nil {
+		return "", err
+	}
+	if err := checkMigrationSizes(migrations, opts); err != nil {
+		return "", err
+	}
+	if fromVersion < 0 || fromVersion > len(migrations) {
+		return "", fmt.Errorf("from version %d is out of range [0, %d]", fromVersion, len(migrations))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Generated by github.com/pechorka/migrations (%s).\n", dialect)
+	fmt.Fprintf(&b, "-- Applies versions %d..%d to a database at version %d.\n\n", fromVersion+1, len(migrations), fromVersion)
+
+	if dialect == DialectMysql {
+		b.WriteString("START TRANSACTION;\n\n")
+	} else {
+		b.WriteString("BEGIN;\n\n")
+	}
+	b.WriteString(createTableStmt(dialect, opts.TableName))
+	b.WriteString(";\n")
+
+	for version, migration := range migrations {
+		version++ // so first version is 1 instead of 0
+		if version <= fromVersion {
+			continue
+		}
+		stmts, err := utils.SplitStatementsStrictFlavor(migration, dialects[dialect].flavor)
+		if err != nil {
+			return "", fmt.Errorf("migration #%d: %w", version, err)
+		}
+
+		fmt.Fprintf(&b, "\n-- migration #%d\n", version)
+		for _, stmt := range stmts {
+			if dialect == DialectMysql && containsSemicolon(stmt) {
+				// The mysql client ends a statement at the first ";", which
+				// would cut routines and triggers inside their BEGIN ... END.
+				if strings.Contains(stmt, "$$") {
+					return "", fmt.Errorf("migration #%d: statement with a compound body contains the script delimiter $$", version)
+				}
+				b.WriteString("DELIMITER $$\n")
+				b.WriteString(stmt)
+				b.WriteString("$$\nDELIMITER ;\n")
+				continue
+			}
+			b.WriteString(stmt)
+			b.WriteString(";\n")
+		}
+		b.WriteString(recordVersionLiteral(dialect, opts.TableName, version))
+		b.WriteString(";\n")
+	}
+
+	b.WriteString("\nCOMMIT;\n")
+	return b.String(), nil
+}
+
+// containsSemicolon reports whether stmt has a ";" outside quotes and comments.
+func containsSemicolon(stmt string) bool {
+	for i := 0; i < len(stmt); {
+		if end := skipQuotedOrComment(stmt, i, true); end > i {
+			i = end
+			continue
+		}
+		if stmt[i] == ';' {
+			return true
+		}
+		i++
+	}
+	return false
+}
+
+// recordVersionLiteral returns the bookkeeping INSERT with the version inlined.
+func recordVersionLiteral(dialect Dialect, table string, version int) string {
+	d := dialects[dialect]
+	return d.insertVersion(d.quoteIdent(table), strconv.Itoa(version))
+}
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateScript(t *testing.T) {
+	migs := []string{
+		"CREATE TABLE a (id INT)",
+		"CREATE TABLE b (id INT); INSERT INTO b VALUES (1);",
+		"-- only a comment\nCREATE TABLE c (id INT)",
+	}
+
+	t.Run("postgres", func(t *testing.T) {
+		got, err := GenerateScript(migs, 1, DialectPostgres, WithTableName("schema_migrations"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := `-- Generated by github.com/pechorka/migrations (postgres).
+-- Applies versions 2..3 to a database at version 1.
+
+BEGIN;
+
+` + createTableStmt(DialectPostgres, "schema_migrations") + `;
+
+-- migration #2
+CREATE TABLE b (id INT);
+INSERT INTO b VALUES (1);
+INSERT INTO "schema_migrations" (version) VALUES (2);
+
+-- migration #3
+CREATE TABLE c (id INT);
+INSERT INTO "schema_migrations" (version) VALUES (3);
+
+COMMIT;
+`
+		if got != want {
+			t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		got, err := GenerateScript(migs, 2, DialectMysql)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, want := range []string{"START TRANSACTION;\n", "INSERT INTO `migrations` (version, applied_at) VALUES (3, UTC_TIMESTAMP(6));\n", "COMMIT;\n"} {
+			if !strings.Contains(got, want) {
+				t.Fatalf("script does not contain %q:\n%s", want, got)
+			}
+		}
+		if strings.Contains(got, "CREATE TABLE b") {
+			t.Fatalf("script contains already applied migration:\n%s", got)
+		}
+	})
+
+	t.Run("mysql compound statements", func(t *testing.T) {
+		trigger := "CREATE TRIGGER a_ins BEFORE INSERT ON a FOR EACH ROW BEGIN\n  SET NEW.id = NEW.id + 1;\n  SET NEW.id = NEW.id * 2;\nEND"
+		got, err := GenerateScript([]string{trigger + ";\nINSERT INTO a VALUES (';')"}, 0, DialectMysql)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "-- migration #1\nDELIMITER $$\n" + trigger + "$$\nDELIMITER ;\nINSERT INTO a VALUES (';');\n"
+		if !strings.Contains(got, want) {
+			t.Fatalf("script does not contain %q:\n%s", want, got)
+		}
+
+		body := "CREATE PROCEDURE p() BEGIN SELECT '$$'; SELECT 1; END"
+		if _, err := GenerateScript([]string{body}, 0, DialectMysql); err == nil || !strings.Contains(err.Error(), "migration #1: statement with a compound body contains the script delimiter $$") {
+			t.Fatalf("got error %v", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := GenerateScript(migs, 4, DialectSqlite); err == nil {
+			t.Fatal("expected error for out of range version")
+		}
+		if _, err := GenerateScript([]string{"SELECT 'x"}, 0, DialectSqlite); err == nil {
+			t.Fatal("expected error for malformed migration")
+		}
+	})
+}
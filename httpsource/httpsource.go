@@ -0,0 +1,180 @@
+// Package httpsource loads migrations from a central artifact server over
+// HTTPS, for fleets that distribute schema changes separately from the
+// binaries applying them:
+//
+//	src, err := httpsource.New("https://artifacts.example.com/orders/manifest.json", nil)
+//	if err != nil { ... }
+//	err = migrations.ApplySource(ctx, db, src, migrations.WithDialect(migrations.DialectPostgres))
+//
+// The manifest lists every migration in order together with the SHA-256 of
+// its file, relative to the manifest URL:
+//
+//	{"migrations": [
+//		{"version": 1, "name": "create_orders", "path": "0001_create_orders.sql", "sha256": "9f86d0..."},
+//		{"version": 2, "name": "add_index", "path": "0002_add_index.sql", "sha256": "60303a..."}
+//	]}
+//
+// A Source remembers the manifest's ETag and the files it downloaded, so
+// asking it again only transfers what changed.
+package httpsource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pechorka/migrations"
+)
+
+// ErrChecksumMismatch is returned when a downloaded file does not match the
+// checksum listed in the manifest.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// maxBodySize bounds every response read from the server.
+const maxBodySize = 64 << 20
+
+// Manifest is the JSON document a Source reads first.
+type Manifest struct {
+	Migrations []ManifestEntry `json:"migrations"`
+}
+
+// ManifestEntry describes one migration file.
+type ManifestEntry struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`   // relative to the manifest URL
+	SHA256  string `json:"sha256"` // hex-encoded checksum of the file
+}
+
+var _ migrations.Source = (*Source)(nil)
+
+// Source is a migrations.Source backed by a manifest served over HTTPS. It is
+// safe for concurrent use.
+type Source struct {
+	manifest *url.URL
+	client   *http.Client
+
+	mu    sync.Mutex
+	etag  string
+	cache []migrations.Migration
+	files map[string]string // sha256 -> content of every verified file
+}
+
+// New returns a Source reading the manifest at manifestURL, which must use
+// https, with client (http.DefaultClient when nil).
+func New(manifestURL string, client *http.Client) (*Source, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest url: %w", err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("manifest url %q does not use https", manifestURL)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Source{manifest: u, client: client, files: map[string]string{}}, nil
+}
+
+// Migrations downloads the manifest, unless the server reports it unchanged,
+// and every listed file not downloaded before, verifying their checksums.
+func (s *Source) Migrations(ctx context.Context) ([]migrations.Migration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, etag, notModified, err := s.get(ctx, s.manifest, s.etag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	if notModified && s.cache != nil {
+		return clone(s.cache), nil
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	migs := make([]migrations.Migration, 0, len(manifest.Migrations))
+	files := make(map[string]string, len(manifest.Migrations))
+	for _, entry := range manifest.Migrations {
+		content, err := s.file(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		files[strings.ToLower(entry.SHA256)] = content
+		migs = append(migs, migrations.Migration{Version: entry.Version, Name: entry.Name, UpSQL: content})
+	}
+
+	s.etag, s.cache, s.files = etag, migs, files
+	return clone(migs), nil
+}
+
+// file returns the verified content of entry, from the cache when possible.
+func (s *Source) file(ctx context.Context, entry ManifestEntry) (string, error) {
+	sum := strings.ToLower(entry.SHA256)
+	if content, ok := s.files[sum]; ok {
+		return content, nil
+	}
+	ref, err := url.Parse(entry.Path)
+	if err != nil || entry.Path == "" {
+		return "", fmt.Errorf("migration #%d: invalid path %q", entry.Version, entry.Path)
+	}
+	u := s.manifest.ResolveReference(ref)
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("migration #%d: %q does not use https", entry.Version, u)
+	}
+
+	body, _, _, err := s.get(ctx, u, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch migration #%d: %w", entry.Version, err)
+	}
+	got := sha256.Sum256(body)
+	if hex.EncodeToString(got[:]) != sum {
+		return "", fmt.Errorf("%w: migration #%d (%s) has sha256 %x, manifest lists %s", ErrChecksumMismatch, entry.Version, entry.Path, got, entry.SHA256)
+	}
+	return string(body), nil
+}
+
+// get fetches u, sending etag as If-None-Match when set.
+func (s *Source) get(ctx context.Context, u *url.URL, etag string) (body []byte, newETag string, notModified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, etag, true, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", false, fmt.Errorf("GET %s: unexpected status %s", u, resp.Status)
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, "", false, fmt.Errorf("GET %s: %w", u, err)
+	}
+	if len(body) > maxBodySize {
+		return nil, "", false, fmt.Errorf("GET %s: response larger than %d bytes", u, maxBodySize)
+	}
+	return body, resp.Header.Get("ETag"), false, nil
+}
+
+func clone(migs []migrations.Migration) []migrations.Migration {
+	return append([]migrations.Migration(nil), migs...)
+}
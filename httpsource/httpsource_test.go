@@ -0,0 +1,93 @@
+package httpsource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pechorka/migrations"
+)
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestSource(t *testing.T) {
+	files := map[string]string{
+		"/m/0001_init.sql":  "CREATE TABLE t (id INT)",
+		"/m/0002_index.sql": "CREATE INDEX i ON t (id)",
+	}
+	manifest := `{"migrations": [
+		{"version": 1, "name": "init", "path": "0001_init.sql", "sha256": "` + sum(files["/m/0001_init.sql"]) + `"},
+		{"version": 2, "name": "index", "path": "0002_index.sql", "sha256": "` + sum(files["/m/0002_index.sql"]) + `"}
+	]}`
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/m/manifest.json" {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte(manifest))
+			return
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	src, err := New(srv.URL+"/m/manifest.json", srv.Client())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := src.Migrations(context.Background())
+		if err != nil {
+			t.Fatalf("migrations: %v", err)
+		}
+		want := []migrations.Migration{
+			{Version: 1, Name: "init", UpSQL: files["/m/0001_init.sql"]},
+			{Version: 2, Name: "index", UpSQL: files["/m/0002_index.sql"]},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+	if requests["/m/manifest.json"] != 2 || requests["/m/0001_init.sql"] != 1 {
+		t.Fatalf("expected cached files, got requests %v", requests)
+	}
+
+	files["/m/0002_index.sql"] = "DROP TABLE t"
+	src, err = New(srv.URL+"/m/manifest.json", srv.Client())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if _, err := src.Migrations(context.Background()); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestNewRequiresHTTPS(t *testing.T) {
+	_, err := New("http://example.com/manifest.json", nil)
+	if err == nil || !strings.Contains(err.Error(), "does not use https") {
+		t.Fatalf("got error %v", err)
+	}
+}
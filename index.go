@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// deferredIndex is a CREATE INDEX statement run after commit by
+// WithConcurrentIndexes.
+type deferredIndex struct {
+	version int
+	stmt    string
+}
+
+// concurrentIndex rewrites a "CREATE [UNIQUE] INDEX [CONCURRENTLY] [IF NOT
+// EXISTS] name ON ..." statement to its CONCURRENTLY IF NOT EXISTS form. It
+// reports false for other statements, unnamed indexes and indexes on ONLY a
+// partitioned parent, which Postgres cannot build concurrently.
+func concurrentIndex(stmt string) (string, bool) {
+	i, ok := expectWord(stmt, 0, "CREATE")
+	if !ok {
+		return "", false
+	}
+	prefix := "CREATE INDEX"
+	if j, ok := expectWord(stmt, i, "UNIQUE"); ok {
+		i, prefix = j, "CREATE UNIQUE INDEX"
+	}
+	if i, ok = expectWord(stmt, i, "INDEX"); !ok {
+		return "", false
+	}
+	if j, ok := expectWord(stmt, i, "CONCURRENTLY"); ok {
+		i = j
+	}
+	if j, ok := expectWord(stmt, i, "IF"); ok {
+		if j, ok = expectWord(stmt, j, "NOT"); !ok {
+			return "", false
+		}
+		if i, ok = expectWord(stmt, j, "EXISTS"); !ok {
+			return "", false
+		}
+	}
+	if _, ok := expectWord(stmt, i, "ON"); ok {
+		return "", false // unnamed: IF NOT EXISTS needs a name
+	}
+	name := skipSpace(stmt, i)
+	end, ident := readIdent(stmt, name)
+	if ident == "" {
+		return "", false
+	}
+	j, ok := expectWord(stmt, end, "ON")
+	if !ok {
+		return "", false
+	}
+	if _, only := expectWord(stmt, j, "ONLY"); only {
+		return "", false
+	}
+	return prefix + " CONCURRENTLY IF NOT EXISTS " + stmt[name:], true
+}
+
+// buildIndexes runs the indexes deferred by WithConcurrentIndexes outside of
+// any transaction.
+func buildIndexes(ctx context.Context, db *sql.DB, indexes []deferredIndex) error {
+	for _, index := range indexes {
+		if _, err := db.ExecContext(ctx, index.stmt); err != nil {
+			return fmt.Errorf("failed to build index of migration #%d concurrently; the migration is recorded as applied, so drop any INVALID index left behind and create it by hand: %w", index.version, err)
+		}
+	}
+	return nil
+}
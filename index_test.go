@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+func TestConcurrentIndex(t *testing.T) {
+	for _, tc := range []struct {
+		stmt string
+		want string
+	}{
+		{"CREATE INDEX users_email ON users (email)", "CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email)"},
+		{"\n  create unique index \"Users_Email\" on users (lower(email))", `CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS "Users_Email" on users (lower(email))`},
+		{"CREATE INDEX IF NOT EXISTS i ON t USING gin (doc)", "CREATE INDEX CONCURRENTLY IF NOT EXISTS i ON t USING gin (doc)"},
+		{"CREATE INDEX CONCURRENTLY i ON t (a)", "CREATE INDEX CONCURRENTLY IF NOT EXISTS i ON t (a)"},
+		{"CREATE INDEX ON t (a)", ""},
+		{"CREATE INDEX i ON ONLY parent (a)", ""},
+		{"CREATE TABLE t (a INT)", ""},
+		{"CREATE UNIQUE TABLE", ""},
+	} {
+		got, ok := concurrentIndex(tc.stmt)
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("concurrentIndex(%q) = %q, %v; want %q", tc.stmt, got, ok, tc.want)
+		}
+	}
+}
+
+func TestConcurrentIndexes(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	migs := []string{
+		"CREATE TABLE users (id INT, email TEXT); CREATE INDEX users_email ON users (email)",
+		"CREATE INDEX ON users (id)",
+	}
+	opts := []Option{WithDialect(DialectPostgres), WithConcurrentIndexes(true)}
+	if err := Apply(context.Background(), db, migs, opts...); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	queries := rec.Queries()
+	index := slices.Index(queries, "CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email)")
+	if index < 0 || !slices.Contains(queries[:index], migrationsmock.Commit) || countQuery(rec, "CREATE INDEX ON users (id)") != 1 {
+		t.Fatalf("expected the named index to be built after commit, got %q", queries)
+	}
+
+	err := Apply(context.Background(), db, migs, WithConcurrentIndexes(true))
+	if err == nil || !strings.Contains(err.Error(), "concurrent indexes require the postgres dialect") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestConcurrentIndexesChunked(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	// The mock does not keep rows, so the second transaction runs the
+	// committed migrations again; make it fail there.
+	boom := errors.New("boom")
+	txs := 0
+	runner := func(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+		err := utils.InTx(ctx, db, fn)
+		if txs++; txs == 1 {
+			rec.Fail("CREATE TABLE orders", boom)
+		}
+		return err
+	}
+	migs := []string{
+		"CREATE INDEX users_email ON users (email)",
+		"CREATE TABLE orders (id INT)",
+		"CREATE TABLE items (id INT)",
+	}
+	err := Apply(context.Background(), db, migs, WithDialect(DialectPostgres), WithConcurrentIndexes(true), WithMaxStatementsPerTx(1), WithTxRunner(runner))
+	if !errors.Is(err, boom) {
+		t.Fatalf("got error %v, want %v", err, boom)
+	}
+	if countQuery(rec, "CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email)") != 1 {
+		t.Fatalf("expected the index of the committed chunk to be built, got %q", rec.Queries())
+	}
+}
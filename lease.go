@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLockLost is returned by Apply with WithLockTTL when another process took
+// over the lock lease because Apply failed to renew it in time.
+var ErrLockLost = errors.New("migrations lock lease lost")
+
+// maxLeaseRetryInterval bounds how long Apply sleeps between attempts to take
+// a lease held by another process.
+const maxLeaseRetryInterval = time.Second
+
+// createLeaseTable returns the DDL of the table holding the lease taken by
+// WithLockTTL. It has at most one row, with id 1.
+func createLeaseTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+                id INTEGER PRIMARY KEY,
+                holder VARCHAR(64) NOT NULL,
+                expires_at BIGINT NOT NULL
+            )`
+}
+
+// acquireLease takes the lease in table for ttl, waiting while another process
+// holds one that has not expired, and renews it every ttl/3 until release is
+// called. The returned context is canceled with cause ErrLockLost when a
+// renewal finds the lease taken over. release stops the renewal and gives the
+// lease up, also when ctx is already done.
+func acquireLease(ctx context.Context, db *sql.DB, d dialect, table string, ttl time.Duration) (leaseCtx context.Context, release func(), err error) {
+	if _, err := db.ExecContext(ctx, createLeaseTable(table)); err != nil {
+		return nil, nil, fmt.Errorf("failed to create migrations lock table: %w", err)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate lock holder id: %w", err)
+	}
+	holder := hex.EncodeToString(id[:])
+
+	retry := min(max(ttl/4, time.Millisecond), maxLeaseRetryInterval)
+	for {
+		taken, err := tryLease(ctx, db, d, table, holder, ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		if taken {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("failed to acquire migrations lock: %w", ctx.Err())
+		case <-time.After(retry):
+		}
+	}
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(max(ttl/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Failed renewals are retried on the next tick: the lease is only
+			// lost once another process took it over.
+			if renewed, err := renewLease(leaseCtx, db, d, table, holder, ttl); err == nil && !renewed {
+				cancel(ErrLockLost)
+				return
+			}
+		}
+	}()
+
+	release = func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+		releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), cancelTimeout)
+		defer cancelRelease()
+		query := d.rebind("DELETE FROM " + table + " WHERE id = 1 AND holder = ?")
+		_, _ = db.ExecContext(releaseCtx, query, holder) // best effort, an unreleased lease expires
+	}
+	return leaseCtx, release, nil
+}
+
+// tryLease takes the lease in table for holder unless another process holds
+// one that has not expired.
+func tryLease(ctx context.Context, db *sql.DB, d dialect, table, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if _, err := db.ExecContext(ctx, d.rebind("DELETE FROM "+table+" WHERE id = 1 AND expires_at < ?"), now.UnixMilli()); err != nil {
+		return false, fmt.Errorf("failed to clear expired migrations lock: %w", err)
+	}
+	insert := d.rebind("INSERT INTO " + table + " (id, holder, expires_at) VALUES (1, ?, ?)")
+	_, insertErr := db.ExecContext(ctx, insert, holder, now.Add(ttl).UnixMilli())
+	if insertErr == nil {
+		return true, nil
+	}
+
+	// The insert fails on the primary key while another process holds the lease.
+	var current string
+	err := db.QueryRowContext(ctx, "SELECT holder FROM "+table+" WHERE id = 1").Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to acquire migrations lock: %w", insertErr)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read migrations lock holder: %w", err)
+	}
+	return false, nil
+}
+
+// renewLease extends the lease of holder by ttl. It reports false when the
+// lease is no longer held by holder.
+func renewLease(ctx context.Context, db *sql.DB, d dialect, table, holder string, ttl time.Duration) (bool, error) {
+	query := d.rebind("UPDATE " + table + " SET expires_at = ? WHERE id = 1 AND holder = ?")
+	result, err := db.ExecContext(ctx, query, time.Now().Add(ttl).UnixMilli(), holder)
+	if err != nil {
+		return false, fmt.Errorf("failed to renew migrations lock: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew migrations lock: %w", err)
+	}
+	return n > 0, nil
+}
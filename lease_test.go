@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestLockTTL(t *testing.T) {
+	migs := []string{"SELECT 1"}
+
+	t.Run("takes and releases the lease", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		if err := Apply(context.Background(), db, migs, WithLockTTL(time.Minute)); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		stmts := rec.Statements()
+		if len(stmts) < 4 || !strings.HasPrefix(stmts[0].Query, `CREATE TABLE IF NOT EXISTS "migrations_lock"`) {
+			t.Fatalf("expected the lock table first, got %v", rec.Queries())
+		}
+		insert := stmts[2]
+		if !strings.HasPrefix(insert.Query, `INSERT INTO "migrations_lock"`) {
+			t.Fatalf("expected the lease to be taken before migrating, got %v", rec.Queries())
+		}
+		release := stmts[len(stmts)-1]
+		if !strings.HasPrefix(release.Query, `DELETE FROM "migrations_lock" WHERE id = 1 AND holder = ?`) {
+			t.Fatalf("expected the lease to be released last, got %v", rec.Queries())
+		}
+		if release.Args[0] != insert.Args[0] {
+			t.Fatalf("released holder %v, took %v", release.Args[0], insert.Args[0])
+		}
+	})
+
+	t.Run("waits for a held lease", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Fail(`INSERT INTO "migrations_lock"`, errors.New("UNIQUE constraint failed"))
+		rec.Return("SELECT holder", []string{"holder"}, []any{"other"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := Apply(ctx, db, migs, WithLockTTL(20*time.Millisecond))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+		if n := countQuery(rec, "SELECT COALESCE(MAX(version), -1) FROM \"migrations\""); n != 0 {
+			t.Fatalf("expected no migration to start, got %d", n)
+		}
+	})
+
+	t.Run("cancels the migration when the lease is lost", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		// The mock reports no affected rows, so the first renewal finds the
+		// lease taken over.
+		block := WithExecutor(func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		err := Apply(context.Background(), db, migs, WithLockTTL(30*time.Millisecond), block)
+		if !errors.Is(err, ErrLockLost) {
+			t.Fatalf("expected ErrLockLost, got %v", err)
+		}
+	})
+
+	t.Run("rejects a negative ttl", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		if err := Apply(context.Background(), db, migs, WithLockTTL(-time.Second)); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
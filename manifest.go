@@ -0,0 +1,115 @@
+package migrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// manifest is the format read by LoadManifest.
+type manifest struct {
+	Migrations []manifestEntry `json:"migrations"`
+}
+
+type manifestEntry struct {
+	Version       int64    `json:"version"`
+	Name          string   `json:"name"`
+	File          string   `json:"file"`
+	Timeout       string   `json:"timeout"`
+	Dialects      []string `json:"dialects"`
+	NoTransaction bool     `json:"no_transaction"`
+}
+
+// LoadManifest reads the manifest at file in fsys, which declares the
+// migrations in order together with their settings, and returns them ready for
+// ApplyMigrations:
+//
+//	{"migrations": [
+//		{"file": "0001_init.sql"},
+//		{"file": "0002_backfill.sql", "name": "backfill", "timeout": "10m"},
+//		{"file": "0003_pg_trgm.sql", "dialects": ["postgres"]}
+//	]}
+//
+// File paths are relative to the manifest's directory and the files are read
+// like in FromFS, so ".sql.gz" files and goose Up/Down annotations work. The
+// order of the entries decides the versions; an entry's optional "version"
+// must match its position. "name" defaults to the description in the file
+// name, "timeout" (a time.ParseDuration string) sets Migration.Timeout and
+// "dialects" sets Migration.Dialects. "no_transaction": true is rejected,
+// since Apply runs every migration in a transaction.
+//
+// The manifest is JSON. Since the package has no dependencies, a
+// migrations.yaml is only understood when written in YAML's JSON-compatible
+// flow style.
+func LoadManifest(fsys fs.FS, file string) ([]Migration, error) {
+	content, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations manifest %q: %w", file, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	var m manifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse migrations manifest %q: %w", file, err)
+	}
+
+	dir := path.Dir(file)
+	migrations := make([]Migration, 0, len(m.Migrations))
+	for i, entry := range m.Migrations {
+		version := int64(i + 1)
+		if entry.File == "" {
+			return nil, fmt.Errorf("migrations manifest %q: entry %d has no file", file, i)
+		}
+		if entry.Version != 0 && entry.Version != version {
+			return nil, fmt.Errorf("migrations manifest %q: %q has version %d, want %d: versions are positional", file, entry.File, entry.Version, version)
+		}
+		if entry.NoTransaction {
+			return nil, fmt.Errorf("migrations manifest %q: %q: no_transaction is not supported: migrations always run in a transaction", file, entry.File)
+		}
+		dialects, err := parseDialects(entry.Dialects)
+		if err != nil {
+			return nil, fmt.Errorf("migrations manifest %q: %q: %w", file, entry.File, err)
+		}
+		var timeout time.Duration
+		if entry.Timeout != "" {
+			if timeout, err = time.ParseDuration(entry.Timeout); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("migrations manifest %q: %q: invalid timeout %q", file, entry.File, entry.Timeout)
+			}
+		}
+
+		content, err := readFile(fsys, dir, entry.File)
+		if err != nil {
+			return nil, err
+		}
+		mig, err := ParseMigration(content)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q: %w", entry.File, err)
+		}
+		mig.Version, mig.Name = version, entry.Name
+		if mig.Name == "" {
+			mig.Name = fileName(path.Base(entry.File))
+		}
+		mig.Dialects, mig.Timeout = dialects, timeout
+		migrations = append(migrations, mig)
+	}
+	return migrations, nil
+}
+
+// parseDialects converts dialect names as returned by Dialect.String.
+func parseDialects(names []string) ([]Dialect, error) {
+	var dialects []Dialect
+	for _, name := range names {
+		d := dialectBegin + 1
+		for d < dialectEnd && d.String() != name {
+			d++
+		}
+		if d == dialectEnd {
+			return nil, fmt.Errorf("unknown dialect %q", name)
+		}
+		dialects = append(dialects, d)
+	}
+	return dialects, nil
+}
@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestLoadManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/migrations.yaml": {Data: []byte(`{"migrations": [
+			{"file": "0001_init.sql"},
+			{"file": "sql/backfill.sql", "name": "backfill", "timeout": "10m", "version": 2},
+			{"file": "0003_trgm.sql", "dialects": ["postgres"]}
+		]}`)},
+		"db/0001_init.sql":     {Data: []byte("-- +goose Up\nCREATE TABLE users (id INT);\n-- +goose Down\nDROP TABLE users;\n")},
+		"db/sql/backfill.sql":  {Data: []byte("UPDATE users SET id = id")},
+		"db/0003_trgm.sql":     {Data: []byte("CREATE EXTENSION pg_trgm")},
+		"db/0004_unlisted.sql": {Data: []byte("SELECT 1")},
+	}
+	got, err := LoadManifest(fsys, "db/migrations.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "init", UpSQL: "CREATE TABLE users (id INT);\n", DownSQL: "DROP TABLE users;\n"},
+		{Version: 2, Name: "backfill", UpSQL: "UPDATE users SET id = id", Timeout: 10 * time.Minute},
+		{Version: 3, Name: "trgm", UpSQL: "CREATE EXTENSION pg_trgm", Dialects: []Dialect{DialectPostgres}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// The postgres-only migration is recorded without running on SQLite.
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+	if err := ApplyMigrations(context.Background(), db, got); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, "UPDATE users SET id = id") != 1 || countQuery(rec, "CREATE EXTENSION pg_trgm") != 0 {
+		t.Fatalf("unexpected statements %q", rec.Queries())
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{"unknown field", `{"migrations": [{"file": "1.sql", "transactional": false}]}`, `unknown field "transactional"`},
+		{"missing file", `{"migrations": [{"name": "init"}]}`, "entry 0 has no file"},
+		{"version", `{"migrations": [{"file": "1.sql", "version": 2}]}`, "has version 2, want 1"},
+		{"no transaction", `{"migrations": [{"file": "1.sql", "no_transaction": true}]}`, "no_transaction is not supported"},
+		{"dialect", `{"migrations": [{"file": "1.sql", "dialects": ["oracle"]}]}`, `unknown dialect "oracle"`},
+		{"timeout", `{"migrations": [{"file": "1.sql", "timeout": "soon"}]}`, `invalid timeout "soon"`},
+		{"unreadable", `{"migrations": [{"file": "2.sql"}]}`, `failed to read migration file "2.sql"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"migrations.json": {Data: []byte(tc.manifest)},
+				"1.sql":           {Data: []byte("SELECT 1")},
+			}
+			_, err := LoadManifest(fsys, "migrations.json")
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
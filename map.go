@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+)
+
+// FromMap turns migrations keyed by version into the slice Apply expects,
+// for codebases assembling migrations from several packages that cannot
+// guarantee slice order:
+//
+//	migs, err := migrations.FromMap(users.Migrations, billing.Migrations)
+//
+// Versions must run from 1 without gaps, and a version may appear in only one
+// of the maps.
+func FromMap(parts ...map[int64]string) ([]string, error) {
+	byVersion := map[int64]string{}
+	owner := map[int64]int{} // version -> index of the map defining it
+	for i, part := range parts {
+		for version, migration := range part {
+			if other, ok := owner[version]; ok {
+				return nil, fmt.Errorf("migration version %d is defined in maps #%d and #%d", version, other+1, i+1)
+			}
+			owner[version] = i
+			byVersion[version] = migration
+		}
+	}
+
+	versions := make([]int64, 0, len(byVersion))
+	for version := range byVersion {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+
+	migrations := make([]string, len(versions))
+	for i, version := range versions {
+		if version != int64(i+1) {
+			return nil, fmt.Errorf("migration version %d, want %d: versions must start at 1 without gaps", version, i+1)
+		}
+		migrations[i] = byVersion[version]
+	}
+	return migrations, nil
+}
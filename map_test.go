@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromMap(t *testing.T) {
+	users := map[int64]string{1: "CREATE TABLE users (id INT)", 3: "CREATE INDEX i ON users (id)"}
+	billing := map[int64]string{2: "CREATE TABLE invoices (id INT)"}
+
+	got, err := FromMap(users, billing)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{users[1], billing[2], users[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		parts   []map[int64]string
+		wantErr string
+	}{
+		{"duplicate", []map[int64]string{users, billing, {2: "SELECT 1"}}, "migration version 2 is defined in maps #2 and #3"},
+		{"gap", []map[int64]string{users}, "migration version 3, want 2"},
+		{"zero", []map[int64]string{{0: "SELECT 1"}}, "migration version 0, want 1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := FromMap(tc.parts...); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package migrations
+
+import "strings"
+
+// masking reports whether the maskers in opts apply.
+func masking(opts Options) bool {
+	return len(opts.Maskers) > 0 && opts.Environment != "" && opts.Environment != "production"
+}
+
+// maskInsert returns stmt with the string literals of masked columns
+// rewritten, or stmt itself when it is not an INSERT ... VALUES statement with
+// a column list.
+func maskInsert(stmt string, maskers map[string]func(string) string, mysql bool) string {
+	i, ok := expectWord(stmt, 0, "INSERT")
+	if !ok {
+		return stmt
+	}
+	if j, ok := expectWord(stmt, i, "IGNORE"); ok && mysql {
+		i = j
+	}
+	if j, ok := expectWord(stmt, i, "INTO"); ok {
+		i = j
+	}
+
+	var table string
+	for {
+		var part string
+		if i, part = readIdent(stmt, skipSpace(stmt, i)); part == "" {
+			return stmt
+		}
+		table = part
+		if i >= len(stmt) || stmt[i] != '.' {
+			break
+		}
+		i++
+	}
+
+	i = skipSpace(stmt, i)
+	if i >= len(stmt) || stmt[i] != '(' {
+		return stmt
+	}
+	var columns []string
+	for {
+		var column string
+		if i, column = readIdent(stmt, skipSpace(stmt, i+1)); column == "" {
+			return stmt
+		}
+		columns = append(columns, column)
+		if i = skipSpace(stmt, i); i >= len(stmt) || (stmt[i] != ',' && stmt[i] != ')') {
+			return stmt
+		}
+		if stmt[i] == ')' {
+			i++
+			break
+		}
+	}
+
+	if j, ok := expectWord(stmt, i, "VALUES"); ok {
+		i = j
+	} else if j, ok := expectWord(stmt, i, "VALUE"); ok && mysql {
+		i = j
+	} else {
+		return stmt
+	}
+
+	maskFor := func(column int) func(string) string {
+		if column >= len(columns) {
+			return nil
+		}
+		name := strings.ToLower(columns[column])
+		if mask, ok := maskers[strings.ToLower(table)+"."+name]; ok {
+			return mask
+		}
+		return maskers[name]
+	}
+
+	var b strings.Builder
+	copied := 0 // stmt[:copied] is already in b
+	for {
+		if i = skipSpace(stmt, i); i >= len(stmt) || stmt[i] != '(' {
+			break
+		}
+		column, start, depth := 0, i+1, 1
+		for i++; i < len(stmt) && depth > 0; {
+			if end := skipQuotedOrComment(stmt, i, mysql); end > i {
+				i = end
+				continue
+			}
+			switch stmt[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth == 0 || (depth == 1 && stmt[i] == ',') {
+				if mask := maskFor(column); mask != nil {
+					if lit, from, to, ok := stringLiteral(stmt, start, i, mysql); ok {
+						b.WriteString(stmt[copied:from])
+						b.WriteString(quoteString(mask(lit), mysql))
+						copied = to
+					}
+				}
+				column, start = column+1, i+1
+			}
+			i++
+		}
+		if i = skipSpace(stmt, i); i >= len(stmt) || stmt[i] != ',' {
+			break
+		}
+		i++
+	}
+
+	if copied == 0 {
+		return stmt
+	}
+	b.WriteString(stmt[copied:])
+	return b.String()
+}
+
+// stringLiteral reports whether s[start:end], ignoring surrounding white
+// space, is a single '...' string literal and returns its decoded value and
+// offsets.
+func stringLiteral(s string, start, end int, mysql bool) (value string, from, to int, ok bool) {
+	from = skipSpace(s, start)
+	to = end
+	for to > from && isSpace(s[to-1]) {
+		to--
+	}
+	if from >= to || s[from] != '\'' || skipQuotedOrComment(s[:to], from, mysql) != to || s[to-1] != '\'' || to-from < 2 {
+		return "", 0, 0, false
+	}
+	raw := s[from+1 : to-1]
+	if mysql && strings.Contains(raw, `\`) {
+		return "", 0, 0, false // escape sequences: leave the literal alone
+	}
+	return strings.ReplaceAll(raw, "''", "'"), from, to, true
+}
+
+// quoteString returns v as a string literal.
+func quoteString(v string, mysql bool) string {
+	if mysql {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// expectWord returns the offset just past keyword when it is the next word
+// after i, ignoring white space and comments.
+func expectWord(s string, i int, keyword string) (int, bool) {
+	i = skipSpace(s, i)
+	end := i + len(keyword)
+	if end > len(s) || !strings.EqualFold(s[i:end], keyword) || (end < len(s) && isIdentByte(s[end])) {
+		return i, false
+	}
+	return end, true
+}
+
+// readIdent reads a bare, "double-quoted" or `backquoted` identifier at i and
+// returns the offset after it and its unquoted name ("" when there is none).
+func readIdent(s string, i int) (int, string) {
+	if i < len(s) && (s[i] == '"' || s[i] == '`') {
+		end := skipQuotedOrComment(s, i, false)
+		if end-i < 2 || s[end-1] != s[i] {
+			return i, ""
+		}
+		q := string(s[i])
+		return end, strings.ReplaceAll(s[i+1:end-1], q+q, q)
+	}
+	end := i
+	for end < len(s) && (isIdentByte(s[end]) || s[end] == '$') {
+		end++
+	}
+	return end, s[i:end]
+}
+
+// skipSpace returns the offset of the first byte at or after i that is not
+// white space or part of a comment.
+func skipSpace(s string, i int) int {
+	for i < len(s) {
+		switch {
+		case isSpace(s[i]):
+			i++
+		case strings.HasPrefix(s[i:], "--") || strings.HasPrefix(s[i:], "/*"):
+			i = skipQuotedOrComment(s, i, false)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
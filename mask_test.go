@@ -0,0 +1,106 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestMaskInsert(t *testing.T) {
+	maskers := map[string]func(string) string{
+		"email":      func(v string) string { return "user" + strings.TrimPrefix(v, "real") + "@example.com" },
+		"users.name": func(string) string { return "O'Hara" },
+	}
+	for _, tc := range []struct {
+		name  string
+		stmt  string
+		mysql bool
+		want  string
+	}{
+		{
+			name: "multiple rows",
+			stmt: "INSERT INTO users (id, name, email) VALUES (1, 'Alice', 'real1'), (2, 'Bob', 'real2')",
+			want: "INSERT INTO users (id, name, email) VALUES (1, 'O''Hara', 'user1@example.com'), (2, 'O''Hara', 'user2@example.com')",
+		},
+		{
+			name: "quoted and qualified names",
+			stmt: `/* seed */ insert into public."Users" ("ID", "EMAIL") values (1, 'real1') ON CONFLICT DO NOTHING`,
+			want: `/* seed */ insert into public."Users" ("ID", "EMAIL") values (1, 'user1@example.com') ON CONFLICT DO NOTHING`,
+		},
+		{
+			name: "table-specific masker only for its table",
+			stmt: "INSERT INTO teams (name) VALUES ('Ops')",
+			want: "INSERT INTO teams (name) VALUES ('Ops')",
+		},
+		{
+			name: "expressions and nested commas",
+			stmt: "INSERT INTO users (email, name) VALUES (lower('X, Y'), 'Alice' || 'x'), ('real3', (SELECT 'n'))",
+			want: "INSERT INTO users (email, name) VALUES (lower('X, Y'), 'Alice' || 'x'), ('user3@example.com', (SELECT 'n'))",
+		},
+		{
+			name:  "mysql backslashes",
+			stmt:  "INSERT IGNORE INTO `users` (`name`, email) VALUE ('a\\'b', 'real4')",
+			mysql: true,
+			want:  "INSERT IGNORE INTO `users` (`name`, email) VALUE ('a\\'b', 'user4@example.com')",
+		},
+		{
+			name: "backslash ends a literal elsewhere",
+			stmt: "INSERT INTO users (name, email) VALUES ('C:\\', 'real5')",
+			want: "INSERT INTO users (name, email) VALUES ('O''Hara', 'user5@example.com')",
+		},
+		{
+			name: "no column list",
+			stmt: "INSERT INTO users VALUES (1, 'Alice', 'real1')",
+			want: "INSERT INTO users VALUES (1, 'Alice', 'real1')",
+		},
+		{
+			name: "insert select",
+			stmt: "INSERT INTO users (email) SELECT email FROM staff",
+			want: "INSERT INTO users (email) SELECT email FROM staff",
+		},
+		{
+			name: "not an insert",
+			stmt: "UPDATE users SET email = 'real1'",
+			want: "UPDATE users SET email = 'real1'",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := maskInsert(tc.stmt, maskers, tc.mysql); got != tc.want {
+				t.Fatalf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMasking(t *testing.T) {
+	migs := []string{"INSERT INTO users (email) VALUES ('ceo@corp.com')"}
+	masker := WithMasker("email", func(string) string { return "x@example.com" })
+
+	for _, tc := range []struct {
+		env  string
+		want string
+	}{
+		{"", migs[0]},
+		{"production", migs[0]},
+		{"staging", "INSERT INTO users (email) VALUES ('x@example.com')"},
+	} {
+		db, rec := migrationsmock.DB()
+		if err := Apply(context.Background(), db, migs, WithEnvironment(tc.env), masker); err != nil {
+			t.Fatalf("apply in %q: %v", tc.env, err)
+		}
+		if countQuery(rec, tc.want) != 1 {
+			t.Fatalf("expected %q in %q, got %q", tc.want, tc.env, rec.Queries())
+		}
+		db.Close()
+	}
+
+	db, _ := migrationsmock.DB()
+	defer db.Close()
+	for _, opt := range []Option{WithMasker("email", nil), WithMasker("", strings.ToUpper), WithMasker("users.", strings.ToUpper)} {
+		if err := Apply(context.Background(), db, migs, opt); err == nil {
+			t.Fatal("expected error for invalid masker")
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Migration is a single migration with its metadata.
+type Migration struct {
+	// Version is the migration's position in the list, starting at 1. Zero
+	// means "its position", so only the SQL has to be filled in.
+	Version int64
+	// Name is a human-readable description, e.g. "create_users". Apply does
+	// not record it.
+	Name string
+	// UpSQL holds the statements Apply runs.
+	UpSQL string
+	// DownSQL holds the statements reverting UpSQL, run by RollbackTo.
+	DownSQL string
+	// Statements, when not empty, replaces UpSQL with statements that were
+	// already split, e.g. by an ORM generating them: each one is executed as
+	// is and the statement splitter is skipped for this migration. Checksums
+	// and plan hashes treat them as joined by ";\n".
+	Statements []string
+	// SupersededBy is the version of a later migration that makes this one
+	// obsolete, e.g. by dropping the table it creates (0: not superseded).
+	// Apply still runs superseded migrations; see Superseded.
+	SupersededBy int64
+	// Dialects, when not empty, limits the migration to the listed dialects;
+	// on any other dialect it is recorded without running a statement.
+	Dialects []Dialect
+	// Timeout bounds the time the statements of the migration may take
+	// together (0: no limit besides the context passed to Apply).
+	Timeout time.Duration
+	// Func, when not nil, replaces UpSQL and Statements with Go code run
+	// inside the Apply transaction, for data changes that need application
+	// logic such as re-encoding JSON or rehashing passwords. Checksums and
+	// plan hashes see such a migration as empty.
+	Func func(ctx context.Context, tx *sql.Tx) error
+	// DialectSQL holds dialect-specific variants of UpSQL, so one history can
+	// target every database a product supports. Apply runs the variant of its
+	// dialect, or UpSQL when there is none; a migration without either fails.
+	DialectSQL map[Dialect]string
+	// Open, when not nil, replaces UpSQL with SQL read lazily, e.g. from a
+	// large data-load file: statements are split and executed as they are
+	// read, so the whole migration is never held in memory. The ReadCloser is
+	// closed when the migration is done; Open may be called again when the
+	// migration is resumed (see WithMaxStatementsPerTx) and by WithChecksums,
+	// which hashes the stream. Plan hashes and WithMaxMigrationSize see such a
+	// migration as empty.
+	Open func() (io.ReadCloser, error)
+}
+
+// ApplyMigrations works like Apply for migrations carrying names and down
+// scripts, e.g. the ones returned by LoadFS. Versions stay positional: every
+// Version must be 0 or its index in migrations plus 1.
+func ApplyMigrations(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) error {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return err
+	}
+	up, err := upSQL(migrations, opts.Dialect)
+	if err != nil {
+		return err
+	}
+	_, err = applyReport(ctx, db, up, migrations, userOptions)
+	return err
+}
+
+// Superseded returns the versions of the migrations marked with
+// SupersededBy, in order. Their effect is undone by a later migration, so
+// they are safe to leave out when squashing the history into a baseline.
+func Superseded(migrations []Migration) ([]int64, error) {
+	if _, err := upSQL(migrations, dialectBegin); err != nil {
+		return nil, err
+	}
+	var superseded []int64
+	for i, m := range migrations {
+		if m.SupersededBy != 0 {
+			superseded = append(superseded, int64(i+1))
+		}
+	}
+	return superseded, nil
+}
+
+// upSQL returns the SQL every migration runs with dialect after checking their
+// versions. With dialectBegin, DialectSQL variants are ignored.
+func upSQL(migrations []Migration, dialect Dialect) ([]string, error) {
+	up := make([]string, len(migrations))
+	for i, m := range migrations {
+		version := int64(i + 1)
+		if m.Version != 0 && m.Version != version {
+			return nil, fmt.Errorf("migration %q at index %d has version %d, want %d: versions are positional", m.Name, i, m.Version, version)
+		}
+		if m.SupersededBy != 0 && (m.SupersededBy <= version || m.SupersededBy > int64(len(migrations))) {
+			return nil, fmt.Errorf("migration #%d is superseded by version %d, want a later version up to %d", version, m.SupersededBy, len(migrations))
+		}
+		variant, hasVariant := m.DialectSQL[dialect]
+		switch {
+		case m.Func != nil && (m.UpSQL != "" || len(m.Statements) > 0 || len(m.DialectSQL) > 0 || m.Open != nil):
+			return nil, fmt.Errorf("migration #%d has both Func and SQL", version)
+		case m.Open != nil && (m.UpSQL != "" || len(m.Statements) > 0 || len(m.DialectSQL) > 0):
+			return nil, fmt.Errorf("migration #%d has both Open and SQL", version)
+		case len(m.Statements) > 0 && len(m.DialectSQL) > 0:
+			return nil, fmt.Errorf("migration #%d has both Statements and DialectSQL", version)
+		case hasVariant:
+			up[i] = variant
+		case len(m.DialectSQL) > 0 && m.UpSQL == "" && dialect != dialectBegin:
+			return nil, fmt.Errorf("migration #%d has no SQL for dialect %s", version, dialect)
+		case len(m.Statements) > 0 && m.UpSQL != "":
+			return nil, fmt.Errorf("migration #%d has both UpSQL and Statements", version)
+		case len(m.Statements) > 0:
+			up[i] = strings.Join(m.Statements, ";\n")
+		default:
+			up[i] = m.UpSQL
+		}
+	}
+	return up, nil
+}
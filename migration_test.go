@@ -0,0 +1,204 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestApplyMigrations(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	migs := []Migration{
+		{Version: 1, Name: "create_users", UpSQL: "CREATE TABLE users (id INT)", DownSQL: "DROP TABLE users"},
+		{Name: "add_index", UpSQL: "CREATE INDEX i ON users (id)"},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, "CREATE INDEX i ON users (id)") != 1 || countQuery(rec, "DROP TABLE users") != 0 {
+		t.Fatalf("unexpected statements %q", rec.Queries())
+	}
+
+	migs[1].Version = 3
+	err := ApplyMigrations(context.Background(), db, migs)
+	if err == nil || !strings.Contains(err.Error(), `migration "add_index" at index 1 has version 3, want 2`) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestSuperseded(t *testing.T) {
+	migs := []Migration{
+		{UpSQL: "CREATE TABLE tmp (id INT)", SupersededBy: 3},
+		{UpSQL: "CREATE TABLE users (id INT)"},
+		{UpSQL: "DROP TABLE tmp"},
+	}
+	got, err := Superseded(migs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []int64{1}) {
+		t.Fatalf("got %v, want [1]", got)
+	}
+
+	for _, by := range []int64{1, 4} {
+		migs[0].SupersededBy = by
+		if _, err := Superseded(migs); err == nil || !strings.Contains(err.Error(), "migration #1 is superseded by version") {
+			t.Fatalf("superseded by %d: got error %v", by, err)
+		}
+		if err := ApplyMigrations(context.Background(), nil, migs); err == nil {
+			t.Fatalf("superseded by %d: expected apply to fail", by)
+		}
+	}
+}
+
+func TestApplyMigrationsStatements(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	// The trailing semicolon inside the comment would end the statement early
+	// if it went through the splitter.
+	fn := "CREATE FUNCTION f() RETURNS INT AS 'SELECT 1' /* ; */"
+	migs := []Migration{
+		{UpSQL: "CREATE TABLE users (id INT)"},
+		{Statements: []string{fn, "CREATE INDEX i ON users (id)"}},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, fn) != 1 || countQuery(rec, "CREATE INDEX i ON users (id)") != 1 {
+		t.Fatalf("unexpected statements %q", rec.Queries())
+	}
+
+	migs[1].UpSQL = "SELECT 1"
+	err := ApplyMigrations(context.Background(), db, migs)
+	if err == nil || !strings.Contains(err.Error(), "migration #2 has both UpSQL and Statements") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestApplyMigrationsFunc(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	migs := []Migration{
+		{UpSQL: "CREATE TABLE users (id INT, password TEXT)"},
+		{Name: "rehash", Func: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "UPDATE users SET password = 'rehashed'")
+			return err
+		}},
+		{UpSQL: "CREATE INDEX i ON users (id)"},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, "UPDATE users SET password = 'rehashed'") != 1 || countQuery(rec, "CREATE INDEX i ON users (id)") != 1 {
+		t.Fatalf("unexpected statements %q", rec.Queries())
+	}
+
+	rec.Reset()
+	rec.Return("MAX(version)", []string{"max"}, []any{int64(1)})
+	boom := errors.New("boom")
+	migs[1].Func = func(context.Context, *sql.Tx) error { return boom }
+	err := ApplyMigrations(context.Background(), db, migs)
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "failed to apply migration #2") {
+		t.Fatalf("got error %v", err)
+	}
+	if countQuery(rec, "CREATE INDEX i ON users (id)") != 0 {
+		t.Fatalf("migration after the failed callback was applied")
+	}
+
+	migs[1].UpSQL = "SELECT 1"
+	if err := ApplyMigrations(context.Background(), db, migs); err == nil || !strings.Contains(err.Error(), "migration #2 has both Func and SQL") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestMigrationTimeoutReleased(t *testing.T) {
+	db, _ := migrationsmock.DB()
+	defer db.Close()
+
+	var timed context.Context
+	migs := []Migration{
+		{Timeout: time.Hour, Func: func(ctx context.Context, tx *sql.Tx) error {
+			timed = ctx
+			return nil
+		}},
+		{Func: func(ctx context.Context, tx *sql.Tx) error {
+			if timed.Err() == nil {
+				return errors.New("timeout of migration #1 still running")
+			}
+			return nil
+		}},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestApplyMigrationsOpen(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	body := &closeRecorder{Reader: strings.NewReader("INSERT INTO items VALUES ('a;b');\nINSERT INTO items VALUES (2);\n")}
+	migs := []Migration{
+		{UpSQL: "CREATE TABLE items (v TEXT)"},
+		{Name: "load", Open: func() (io.ReadCloser, error) { return body, nil }},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, "INSERT INTO items VALUES ('a;b')") != 1 || countQuery(rec, "INSERT INTO items VALUES (2)") != 1 || !body.closed {
+		t.Fatalf("unexpected statements %q (closed: %v)", rec.Queries(), body.closed)
+	}
+
+	rec.Reset()
+	rec.Return("MAX(version)", []string{"max"}, []any{int64(1)})
+	missing := errors.New("no such object")
+	migs[1].Open = func() (io.ReadCloser, error) { return nil, missing }
+	if err := ApplyMigrations(context.Background(), db, migs); !errors.Is(err, missing) || !strings.Contains(err.Error(), "failed to open migration #2") {
+		t.Fatalf("got error %v", err)
+	}
+
+	migs[1].UpSQL = "SELECT 1"
+	if err := ApplyMigrations(context.Background(), db, migs); err == nil || !strings.Contains(err.Error(), "migration #2 has both Open and SQL") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestApplyMigrationsOpenClosesEachReader(t *testing.T) {
+	db, _ := migrationsmock.DB()
+	defer db.Close()
+
+	first := &closeRecorder{Reader: strings.NewReader("SELECT 1")}
+	migs := []Migration{
+		{Open: func() (io.ReadCloser, error) { return first, nil }},
+		{Open: func() (io.ReadCloser, error) {
+			if !first.closed {
+				return nil, errors.New("reader of migration #1 still open")
+			}
+			return io.NopCloser(strings.NewReader("SELECT 2")), nil
+		}},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+}
@@ -9,8 +9,15 @@
 //
 // Apply creates a bookkeeping table if it does not exist yet and then executes
 // only the migrations whose version is greater than the maximum recorded
-// version. All statements run inside a single transaction; if any statement
-// fails, nothing is recorded and the transaction is rolled back.
+// version. By default all statements run inside a single transaction; if any
+// statement fails, nothing is recorded and the transaction is rolled back.
+//
+// Two options trade that atomicity away. WithMaxStatementsPerTx splits a run
+// into several transactions of at most n statements: a failure keeps the
+// transactions committed before it, and the next Apply resumes after the last
+// committed statement. WithConcurrentIndexes takes named CREATE INDEX
+// statements out of the transaction and builds them CONCURRENTLY after it
+// commits, so a failed build leaves its migration recorded.
 //
 // Supported dialects: SQLite (default), Postgres, and MySQL.
 package migrations
@@ -18,7 +25,11 @@ package migrations
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pechorka/migrations/pkg/utils"
 )
@@ -33,7 +44,8 @@ import (
 //   - Splits each migration string by semicolons at the top level to allow
 //     multiple statements per migration string.
 //   - Wraps all statements in a single transaction. On error the transaction is
-//     rolled back and no version is recorded.
+//     rolled back and no version is recorded. WithMaxStatementsPerTx and
+//     WithConcurrentIndexes relax this, see their documentation.
 //
 // Dialect and table name can be customized via Option values, e.g.:
 //
@@ -41,45 +53,254 @@ import (
 //
 // The default dialect is SQLite and the default table name is "migrations".
 func Apply(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) error {
+	_, err := ApplyReport(ctx, db, migrations, userOptions...)
+	return err
+}
+
+// ApplyReport works like Apply and additionally returns a Report describing
+// what was done, e.g. for logging a one-line summary:
+//
+//	report, err := migrations.ApplyReport(ctx, db, migs)
+//	if err != nil { ... }
+//	log.Printf("migrations: %s", report)
+//
+// On error the returned Report is empty since the transaction was rolled back.
+func ApplyReport(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (Report, error) {
+	return applyReport(ctx, db, migrations, nil, userOptions)
+}
+
+// ApplyTo works like Apply but stops at version target, so a deployment can
+// hold the schema at an intermediate version on purpose, e.g. during a staged
+// rollout or a blue/green compatibility window. Migrations above target are
+// neither run nor checked; target len(migrations) is the same as Apply.
+//
+// ApplyTo never reverts migrations: when the database is already past target
+// it fails with ErrPastTarget (see RollbackTo), unless
+// WithAllowTruncatedHistory(true) is set, in which case it does nothing.
+func ApplyTo(ctx context.Context, db *sql.DB, migrations []string, target int, userOptions ...Option) error {
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("target version %d is out of range [0, %d]", target, len(migrations))
+	}
+	err := Apply(ctx, db, migrations[:target], userOptions...)
+	var truncated *TruncatedHistoryError
+	if errors.As(err, &truncated) && len(truncated.Missing) > 0 && slices.Max(truncated.Missing) <= len(migrations) {
+		return fmt.Errorf("%w: versions %v are applied beyond target version %d", ErrPastTarget, truncated.Missing, target)
+	}
+	return err
+}
+
+// ApplyOne works like Apply but applies only the next pending migration and
+// stops, for carefully staged production rollouts or bisecting a failing
+// migration. It returns the version it applied, or 0 when none was pending.
+// The next migration is determined under the migrations lock, so concurrent
+// calls apply one migration each.
+func ApplyOne(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) (int, error) {
+	one := func(opts *Options) error {
+		opts.limit = 1
+		return nil
+	}
+	report, err := ApplyReport(ctx, db, migrations, append(userOptions[:len(userOptions):len(userOptions)], one)...)
+	if err != nil || len(report.Applied) == 0 {
+		return 0, err
+	}
+	return report.Applied[0], nil
+}
+
+// applyReport implements ApplyReport. migs, when not nil, holds the Migration
+// each element of migrations came from, for its per-migration settings.
+func applyReport(ctx context.Context, db *sql.DB, migrations []string, migs []Migration, userOptions []Option) (Report, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return Report{}, err
+	}
+	if opts.RequireNonEmpty && len(migrations) == 0 {
+		return Report{}, ErrEmptyMigrations
+	}
+	if err := checkMigrationSizes(migrations, opts); err != nil {
+		return Report{}, err
+	}
+
+	if opts.LockTTL > 0 {
+		d := dialects[opts.Dialect]
+		leaseCtx, release, err := acquireLease(ctx, db, d, d.quoteIdent(opts.TableName+"_lock"), opts.LockTTL)
+		if err != nil {
+			return Report{}, err
+		}
+		defer release()
+		ctx = leaseCtx
+	}
+
+	var report Report
+	run := func() error {
+		var err error
+		report, err = apply(ctx, db, migrations, migs, opts)
+		return err
+	}
+	if opts.Compatibility == CompatYugabyte {
+		once := run
+		run = func() error { return retryTx(ctx, yugabyteMaxAttempts, isYugabyteRetryable, once) }
+	}
+	if opts.FailoverMaxWait > 0 {
+		once := run
+		run = func() error { return retryUntil(ctx, opts.FailoverMaxWait, isFailover, once) }
+	}
+	if opts.ReconnectAttempts > 0 {
+		// Every attempt re-reads the recorded state under the lock, so it
+		// resumes after what an earlier attempt committed.
+		once := run
+		run = func() error { return retryTx(ctx, opts.ReconnectAttempts+1, utils.IsConnectionLost, once) }
+	}
+	err = run()
+	if err != nil && errors.Is(context.Cause(ctx), ErrLockLost) {
+		err = fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	return report, err
+}
+
+// buildOptions applies the options set with SetDefaultOptions and then
+// userOptions on top of the defaults and validates the result.
+func buildOptions(userOptions []Option) (Options, error) {
 	opts := Options{
 		Dialect:   DialectSqlite,
 		TableName: "migrations",
+		TxRunner:  utils.InTx,
 	}
 
+	for i, modifyOptions := range currentDefaultOptions() {
+		if err := modifyOptions(&opts); err != nil {
+			return Options{}, fmt.Errorf("issue with default option #%d: %w", i+1, err)
+		}
+	}
 	for i, modifyOptions := range userOptions {
 		err := modifyOptions(&opts)
 		if err != nil {
-			return fmt.Errorf("issue with option #%d: %w", i+1, err)
+			return Options{}, fmt.Errorf("issue with option #%d: %w", i+1, err)
 		}
 	}
 
 	if err := validateOptions(opts); err != nil {
-		return fmt.Errorf("invalid options: %w", err)
+		return Options{}, fmt.Errorf("invalid options: %w", err)
 	}
+	return opts, nil
+}
 
-	switch opts.Dialect {
-	case DialectSqlite:
-		return applySqlite(ctx, db, migrations, opts)
-	case DialectMysql:
-		return applyMysql(ctx, db, migrations, opts)
-	case DialectPostgres:
-		return applyPostgres(ctx, db, migrations, opts)
-	default:
-		return fmt.Errorf("dialect %d is not supported (should never happen)", opts.Dialect)
-	}
+// ErrHistoryTruncated is returned by Apply when the bookkeeping table records
+// versions that are beyond the end of the supplied migrations, which usually
+// means migration files were deleted. The concrete error is a
+// *TruncatedHistoryError listing the missing versions.
+var ErrHistoryTruncated = errors.New("migration history truncated")
+
+// ErrEmptyMigrations is returned by Apply when WithRequireNonEmpty(true) is set
+// and no migrations were supplied.
+var ErrEmptyMigrations = errors.New("no migrations supplied")
+
+// ErrVerificationFailed is returned by Apply with WithPostApplyVerification(true)
+// when the version read back after commit differs from the one just applied.
+var ErrVerificationFailed = errors.New("post-apply verification failed")
+
+// ErrMigrationTooLarge is returned when a migration exceeds the size set with
+// WithMaxMigrationSize.
+var ErrMigrationTooLarge = errors.New("migration too large")
+
+// ErrPastTarget is returned by ApplyTo when the database already has
+// migrations above the target version applied.
+var ErrPastTarget = errors.New("database is past the target version")
+
+// ErrFleetSkew is returned by Apply with WithFleetGuard when migrating would
+// leave other instances of the fleet too far behind.
+var ErrFleetSkew = errors.New("fleet version skew")
+
+// TruncatedHistoryError reports applied versions that no longer have a
+// corresponding migration. It matches ErrHistoryTruncated with errors.Is.
+type TruncatedHistoryError struct {
+	Missing []int
+}
+
+func (e *TruncatedHistoryError) Error() string {
+	return fmt.Sprintf("%s: applied versions %v have no matching migration", ErrHistoryTruncated, e.Missing)
+}
+
+func (e *TruncatedHistoryError) Unwrap() error {
+	return ErrHistoryTruncated
 }
 
 // Options begin
 
 // Options controls how Apply behaves.
 //
-// Use Option helpers (WithDialect, WithTableName) to construct and pass
+// Use Option helpers (WithDialect, WithTableName, ...) to construct and pass
 // configuration to Apply.
 type Options struct {
 	Dialect   Dialect
 	TableName string
+	// AllowTruncatedHistory disables the ErrHistoryTruncated check.
+	AllowTruncatedHistory bool
+	// RequireNonEmpty makes Apply fail with ErrEmptyMigrations on an empty list.
+	RequireNonEmpty bool
+	// PostApplyVerification re-reads the head version after commit.
+	PostApplyVerification bool
+	// TxRunner runs fn in a transaction and commits or rolls it back.
+	TxRunner TxRunner
+	// OnFreshDatabase runs once when Apply created the bookkeeping table.
+	OnFreshDatabase func(ctx context.Context, tx *sql.Tx) error
+	// Compatibility selects a database that speaks Dialect with quirks.
+	Compatibility Compatibility
+	// DDLStrategy is the Vitess @@ddl_strategy used with CompatVitess.
+	DDLStrategy string
+	// MaxStatementsPerTx caps the statements run per transaction (0: no cap).
+	MaxStatementsPerTx int
+	// ServerSideCancel cancels the running statement on the server when ctx is done.
+	ServerSideCancel bool
+	// MaxMigrationSize is the largest accepted migration in bytes (0: no limit).
+	MaxMigrationSize int
+	// StatementTag is added as a comment in front of every migration statement.
+	StatementTag string
+	// FleetInstance registers this instance in the fleet table (empty: no fleet guard).
+	FleetInstance string
+	// FleetMaxAhead is how far past the fleet's lowest expected version Apply may go.
+	FleetMaxAhead int
+	// FleetTTL is how long a fleet registration counts (0: forever).
+	FleetTTL time.Duration
+	// ApprovalToken is passed to ApprovalVerifier.
+	ApprovalToken string
+	// ApprovalVerifier approves the plan before migrations run (nil: no approval).
+	ApprovalVerifier func(token string, plan Plan) error
+	// Environment names where Apply runs ("": production).
+	Environment string
+	// Maskers rewrite inserted values outside of production, keyed by lower-case
+	// "column" or "table.column".
+	Maskers map[string]func(value string) string
+	// Checksums records and verifies migration checksums in "<table>_meta".
+	Checksums bool
+	// ConcurrentIndexes builds CREATE INDEX statements concurrently after commit.
+	ConcurrentIndexes bool
+	// DialectCheck records and verifies the dialect of applied migrations in "<table>_dialect".
+	DialectCheck bool
+	// DataLossCheck makes DROP TABLE/COLUMN fail on tables and columns holding data.
+	DataLossCheck bool
+	// ConfirmDataLoss lets DROP statements run despite DataLossCheck.
+	ConfirmDataLoss bool
+	// Executor runs every migration statement (nil: tx.ExecContext).
+	Executor func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error)
+	// StatementInjector returns statements to run around every SQL migration (nil: none).
+	StatementInjector func(m Migration) (before, after []string)
+	// ReconnectAttempts is how often Apply starts over after losing its connection (0: never).
+	ReconnectAttempts int
+	// FailoverMaxWait is how long Apply keeps retrying during a failover (0: no retries).
+	FailoverMaxWait time.Duration
+	// LockTTL is how long the lock lease lasts without renewal (0: no lease).
+	LockTTL time.Duration
+	// EnvAllowlist lists the environment variables ${NAME} in migrations may refer to (nil: no interpolation).
+	EnvAllowlist []string
+	// limit caps the number of migrations one run applies (0: no limit); set by ApplyOne.
+	limit int
 }
 
+// TxRunner begins a transaction on db, runs fn in it, and commits when fn
+// succeeds or rolls back when it fails. A runner may call fn more than once,
+// e.g. to retry serialization failures.
+type TxRunner func(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error
+
 // Option mutates Options passed to Apply.
 //
 // Use WithDialect and WithTableName to construct Option values.
@@ -109,6 +330,266 @@ func WithTableName(table string) Option {
 	}
 }
 
+// WithAllowTruncatedHistory allows Apply to run when the bookkeeping table
+// records more versions than there are migrations (default: false).
+//
+// Enable it for intentional squashes where old migrations were removed on
+// purpose; otherwise Apply fails with ErrHistoryTruncated.
+func WithAllowTruncatedHistory(allow bool) Option {
+	return func(opts *Options) error {
+		opts.AllowTruncatedHistory = allow
+		return nil
+	}
+}
+
+// WithRequireNonEmpty makes Apply fail with ErrEmptyMigrations when the
+// migrations slice is empty (default: false).
+//
+// It guards against misconfigured builds that embed zero migration files and
+// would otherwise "succeed" leaving only the bookkeeping table behind.
+func WithRequireNonEmpty(require bool) Option {
+	return func(opts *Options) error {
+		opts.RequireNonEmpty = require
+		return nil
+	}
+}
+
+// WithPostApplyVerification makes Apply re-read MAX(version) after the
+// transaction committed, outside of it and on a dedicated connection, and fail
+// with ErrVerificationFailed when it does not match the version just applied
+// (default: false).
+//
+// This catches misbehaving proxies and poolers (e.g. PgBouncer in transaction
+// pooling mode) that silently lose or reorder commits. Note that database/sql
+// may hand out an idle pooled connection for the check.
+func WithPostApplyVerification(verify bool) Option {
+	return func(opts *Options) error {
+		opts.PostApplyVerification = verify
+		return nil
+	}
+}
+
+// WithTxRunner replaces the built-in transaction management (BeginTx, fn,
+// Commit/Rollback) with a custom runner, for proxies with non-standard
+// Begin/Commit semantics (Vitess, ProxySQL) or driver-specific retry wrappers:
+//
+//	migrations.WithTxRunner(func(ctx context.Context, db *sql.DB, fn func(context.Context, *sql.Tx) error) error {
+//		return crdb.ExecuteTx(ctx, db, nil, func(tx *sql.Tx) error { return fn(ctx, tx) })
+//	})
+//
+// The runner must run fn inside a transaction on db and commit only when fn
+// returns nil.
+func WithTxRunner(runner TxRunner) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.TxRunner = runner
+		return nil
+	}
+}
+
+// WithOnFreshDatabase registers a callback that runs when the bookkeeping
+// table did not exist before this Apply, i.e. on the very first run against a
+// database. Use it for one-time setup (default admin user, feature flags) that
+// should not be part of the versioned migrations.
+//
+// The callback runs inside the Apply transaction after all migrations, so the
+// schema they create is available; an error rolls everything back.
+func WithOnFreshDatabase(fn func(ctx context.Context, tx *sql.Tx) error) Option {
+	return func(opts *Options) error {
+		opts.OnFreshDatabase = fn
+		return nil
+	}
+}
+
+// WithCompatibility selects a compatibility profile for databases that speak
+// one of the supported dialects but behave differently (default: CompatNone).
+//
+// Each profile requires a specific dialect, e.g. CompatYugabyte requires
+// DialectPostgres.
+func WithCompatibility(compat Compatibility) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.Compatibility = compat
+		return nil
+	}
+}
+
+// WithDDLStrategy sets the Vitess @@ddl_strategy for the Apply session, e.g.
+// "vitess" for online DDL (default with CompatVitess: "direct").
+//
+// Only valid together with WithCompatibility(CompatVitess). Online strategies
+// schedule DDL asynchronously, so statements that depend on a schema change
+// made earlier in the same Apply run may fail.
+func WithDDLStrategy(strategy string) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.DDLStrategy = strategy
+		return nil
+	}
+}
+
+// WithMaxStatementsPerTx caps the number of migration statements executed in
+// one transaction (default: 0, no cap). Larger runs, and single migrations with
+// more statements than n, are split across several transactions, for engines
+// that struggle with very large transactions (MySQL redo log, SQLite memory).
+//
+// This gives up atomicity: a failure leaves every transaction committed before
+// it in place, possibly halfway through a migration. Progress inside a
+// migration is kept in a companion "<table>_progress" table and the next Apply
+// resumes after the last committed statement, so the migrations must not be
+// edited in between. WithOnFreshDatabase runs in the last transaction.
+//
+// Not supported with CompatVitess.
+func WithMaxStatementsPerTx(n int) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.MaxStatementsPerTx = n
+		return nil
+	}
+}
+
+// WithServerSideCancel makes Apply cancel the in-flight statement on the
+// server when ctx is done (default: false): pg_cancel_backend on Postgres,
+// KILL QUERY on MySQL, both sent over a separate connection. Without it, some
+// drivers merely abandon the connection and a long-running DDL statement keeps
+// running, and holding its locks, after Apply returned.
+//
+// It costs one extra query per transaction to learn the connection's backend
+// id, and the database user needs permission to cancel its own queries. It has
+// no effect on SQLite.
+func WithServerSideCancel(cancel bool) Option {
+	return func(opts *Options) error {
+		opts.ServerSideCancel = cancel
+		return nil
+	}
+}
+
+// WithMaxMigrationSize makes Apply and GenerateScript fail with
+// ErrMigrationTooLarge before touching the database when a migration is
+// larger than n bytes (default: 0, no limit). It guards against accidentally
+// embedding a data dump as a migration.
+func WithMaxMigrationSize(n int) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.MaxMigrationSize = n
+		return nil
+	}
+}
+
+// WithStatementTag prefixes every executed migration statement with a comment
+// carrying tag and the migration version, e.g. with tag "deploy=2024-06-01
+// sha=abc123":
+//
+//	/* migration 3: deploy=2024-06-01 sha=abc123 */ CREATE INDEX ...
+//
+// so DBAs watching pg_stat_activity, the processlist or slow query logs can
+// attribute load to a migration run. The tag must not contain "/*", "*/" or
+// line breaks.
+func WithStatementTag(tag string) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.StatementTag = tag
+		return nil
+	}
+}
+
+// WithFleetGuard protects a service fleet from migrations its older instances
+// cannot cope with, e.g. during a canary rollout. Every Apply records instance
+// together with the version it expects, len(migrations), in a companion
+// "<table>_fleet" table, then refuses with ErrFleetSkew, before running any
+// migration, when that version is more than maxAhead versions past the lowest
+// version expected by any registered instance.
+//
+// With maxAhead 0 an instance never migrates past the oldest instance; with 1,
+// the usual choice for backward compatible migrations, each rollout may add
+// one migration. Registrations older than ttl are ignored so instances that
+// were shut down stop holding the fleet back; 0 keeps them forever. Instances
+// refresh their registration on every Apply, so ttl must be longer than the
+// time between restarts of the slowest instance.
+func WithFleetGuard(instance string, maxAhead int, ttl time.Duration) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.FleetInstance = instance
+		opts.FleetMaxAhead = maxAhead
+		opts.FleetTTL = ttl
+		return nil
+	}
+}
+
+// WithApprovalToken makes Apply call verify with token and the Plan it is
+// about to execute, after taking the migrations lock and before running any
+// migration, and fail with ErrNotApproved when verify returns an error. It
+// implements a two-person rule for production: one person approves a plan
+// hash out-of-band (chatops, change ticket) and the deployment passes the
+// resulting token, which verify checks against plan.Hash, e.g. as an HMAC.
+//
+// verify is not called when there is nothing to apply. With
+// WithMaxStatementsPerTx the whole run is approved once, in the first
+// transaction.
+func WithApprovalToken(token string, verify func(token string, plan Plan) error) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.ApprovalToken = token
+		opts.ApprovalVerifier = verify
+		return nil
+	}
+}
+
+// WithEnvironment names the environment Apply runs in, e.g. "staging"
+// (default: "", meaning production). Maskers registered with WithMasker only
+// run outside of production, that is when env is set to anything other than
+// "production".
+func WithEnvironment(env string) Option {
+	return func(opts *Options) error {
+		opts.Environment = env
+		return nil
+	}
+}
+
+// WithMasker rewrites the string literals inserted into column by migrations,
+// so the same seed migrations serve production and anonymized non-production
+// databases (see WithEnvironment):
+//
+//	migrations.WithEnvironment("staging"),
+//	migrations.WithMasker("users.email", func(v string) string { return hash(v) + "@example.com" }),
+//	migrations.WithMasker("name", func(string) string { return "Jane Doe" }),
+//
+// column is either a bare column name, matching it in every table, or
+// "table.column"; the latter wins when both match. Names are matched
+// case-insensitively and without quotes.
+//
+// Only "INSERT INTO t (columns...) VALUES (...), ..." statements are
+// rewritten, and only values that are a single 'quoted' string literal; numbers,
+// expressions, INSERT ... SELECT and INSERTs without a column list are left
+// untouched.
+func WithMasker(column string, mask func(value string) string) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		if opts.Maskers == nil {
+			opts.Maskers = map[string]func(string) string{}
+		}
+		opts.Maskers[strings.ToLower(column)] = mask
+		return nil
+	}
+}
+
+// WithChecksums makes Apply record the SHA-256 of every migration it applies
+// and fail with ErrChecksumMismatch when an applied migration was edited
+// since (default: false). Checksums live in a sidecar "<table>_meta" table
+// created by Apply, so the bookkeeping table keeps its shape for other tools
+// reading it.
+//
+// Migrations applied before checksums were enabled get the checksum of their
+// current SQL on the next Apply. AppliedChecksums reads the recorded ones.
+// Migrations with Migration.Open are hashed while streaming from a second
+// Open call, so large ones are never held in memory either.
+func WithChecksums(enabled bool) Option {
+	return func(opts *Options) error {
+		opts.Checksums = enabled
+		return nil
+	}
+}
+
 // Dialect enumerates supported SQL dialects.
 type Dialect int32
 
@@ -122,20 +603,337 @@ const (
 	dialectEnd
 )
 
+// String returns the lower-case dialect name, e.g. "postgres".
+func (d Dialect) String() string {
+	switch d {
+	case DialectSqlite:
+		return "sqlite"
+	case DialectPostgres:
+		return "postgres"
+	case DialectMysql:
+		return "mysql"
+	default:
+		return fmt.Sprintf("Dialect(%d)", int32(d))
+	}
+}
+
 // IsValidDialect reports whether d is one of the supported Dialect constants.
 func IsValidDialect(d Dialect) bool {
 	return dialectBegin < d && d < dialectEnd
 }
 
+// Compatibility enumerates compatibility profiles layered on top of a Dialect.
+type Compatibility int32
+
+const (
+	// CompatNone uses the dialect as is.
+	CompatNone Compatibility = iota
+	// CompatYugabyte targets YugabyteDB (YSQL) via DialectPostgres. The whole
+	// Apply transaction is retried on serialization failures and deadlocks
+	// (SQLSTATE 40001, 40P01), which YugabyteDB reports for conflicting
+	// concurrent transactions, including on the sentinel lock row. Advisory
+	// locks are never used. Note that, depending on the YugabyteDB version, DDL
+	// may not be transactional, so a failed migration can leave schema changes
+	// behind.
+	CompatYugabyte
+	// CompatVitess targets Vitess and PlanetScale via DialectMysql. The session
+	// @@ddl_strategy is set before any migration runs (see WithDDLStrategy),
+	// defaulting to "direct" so DDL is applied synchronously. Vitess does not
+	// support foreign keys unless explicitly enabled for the keyspace, so keep
+	// them out of migrations targeting it.
+	CompatVitess
+
+	compatEnd
+)
+
+// WithDialectCheck makes Apply record the dialect every migration is applied
+// with and fail with ErrDialectMismatch, before running any migration, when
+// applied migrations were recorded with another dialect (default: false). It
+// catches services sharing a database, e.g. in staging, where one is
+// configured with the wrong driver or dialect. The dialects live in a sidecar
+// "<table>_dialect" table created by Apply; AppliedDialects reads them.
+//
+// Migrations applied before the check was enabled are recorded with the
+// current dialect on the next Apply.
+func WithDialectCheck(enabled bool) Option {
+	return func(opts *Options) error {
+		opts.DialectCheck = enabled
+		return nil
+	}
+}
+
+// WithDataLossCheck makes Apply inspect the data a migration is about to drop
+// (default: false). Before a DROP TABLE of a table that has rows, or an ALTER
+// TABLE ... DROP [COLUMN] of a column holding a non-NULL value, Apply fails
+// with ErrDataLoss unless WithConfirmDataLoss(true) is given too. It is a last
+// line of defense against a destructive migration hitting the wrong
+// environment. Only tables of the current schema are inspected;
+// schema-qualified names are not checked.
+func WithDataLossCheck(enabled bool) Option {
+	return func(opts *Options) error {
+		opts.DataLossCheck = enabled
+		return nil
+	}
+}
+
+// WithConfirmDataLoss confirms that the migrations may drop tables and columns
+// holding data, overriding WithDataLossCheck for this Apply.
+func WithConfirmDataLoss(confirm bool) Option {
+	return func(opts *Options) error {
+		opts.ConfirmDataLoss = confirm
+		return nil
+	}
+}
+
+// WithExecutor routes every migration statement through exec instead of
+// calling tx.ExecContext directly, e.g. to send DDL to an internal approval
+// proxy or to record each statement centrally:
+//
+//	migrations.WithExecutor(func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+//		audit.Record(ctx, stmt)
+//		return tx.ExecContext(ctx, stmt)
+//	})
+//
+// stmt is the statement as Apply would run it, including the WithStatementTag
+// comment. The bookkeeping statements and Go-function migrations do not go
+// through exec, nor do the index builds deferred by WithConcurrentIndexes,
+// which run outside of any transaction.
+func WithExecutor(exec func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error)) Option {
+	return func(opts *Options) error {
+		opts.Executor = exec
+		return nil
+	}
+}
+
+// WithConcurrentIndexes makes Apply build the indexes of Postgres migrations
+// without locking writes to their tables (default: false). Every top-level
+// "CREATE [UNIQUE] INDEX name ON ..." statement is taken out of the
+// transaction, rewritten to "CREATE [UNIQUE] INDEX CONCURRENTLY IF NOT EXISTS
+// name ON ..." and run after the transaction committed, in order; with
+// WithMaxStatementsPerTx, after the transaction of its chunk. Unnamed indexes
+// stay in the transaction.
+//
+// Since the index is built after its migration is recorded, a build that
+// fails (e.g. on duplicate values for a unique index) is not retried by the
+// next Apply: Postgres leaves an INVALID index behind that must be dropped
+// and recreated by hand. Statements of later migrations in the same run that
+// rely on the index run before it exists.
+func WithConcurrentIndexes(enabled bool) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.ConcurrentIndexes = enabled
+		return nil
+	}
+}
+
+// WithStatementInjector makes Apply run the statements returned by inject
+// before and after the statements of every migration it applies, in the same
+// transaction, so platform teams can enforce cross-cutting rules such as
+// enabling row-level security or adding audit triggers on every new table:
+//
+//	migrations.WithStatementInjector(func(m migrations.Migration) (before, after []string) {
+//		for _, table := range createdTables(m.UpSQL) {
+//			after = append(after, "ALTER TABLE "+table+" ENABLE ROW LEVEL SECURITY")
+//		}
+//		return nil, after
+//	})
+//
+// inject gets the migration with its Version set and UpSQL holding the SQL
+// Apply runs for the dialect; with plain []string migrations only those two
+// fields are set. It is not called for Func migrations, which can run such
+// statements themselves, nor for migrations skipped on the dialect. Injected
+// statements are tagged, masked and executed like the migration's own, but
+// are not part of its checksum or plan hash, so changing the injector does
+// not invalidate applied migrations. inject must return the same statements
+// for the same migration, since WithMaxStatementsPerTx resumes by position.
+func WithStatementInjector(inject func(m Migration) (before, after []string)) Option {
+	return func(opts *Options) error {
+		opts.StatementInjector = inject
+		return nil
+	}
+}
+
+// WithReconnect makes Apply start over, up to attempts more times, when its
+// connection to the database breaks, e.g. because the pool handed out a
+// connection that died in a failover (default: 0, fail the deploy); see
+// IsConnectionLost in pkg/utils for the errors that count. Before each attempt
+// Apply waits a little longer, then takes a fresh connection and the
+// migrations lock again and re-reads the recorded versions and
+// WithMaxStatementsPerTx progress. So it resumes after whatever an earlier
+// attempt committed, and a rolled back transaction is simply applied again.
+//
+// Statements that commit implicitly, such as DDL on MySQL, may have run before
+// the connection broke without being recorded; retrying them then fails like
+// a second Apply would.
+func WithReconnect(attempts int) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.ReconnectAttempts = attempts
+		return nil
+	}
+}
+
+// WithFailoverRetry makes Apply ride out a failover of a highly available
+// database for up to maxWait (default: 0, fail the deploy). While the old
+// primary is demoted it rejects writes as read-only (SQLSTATE 25006 on
+// Postgres, --read-only on MySQL) or drops its connections. Apply then rolls
+// back and starts over with growing delays until a writable primary accepts
+// the migrations or maxWait has passed. Like WithReconnect, every attempt
+// re-reads the recorded state, so it re-runs the interrupted migration and
+// nothing before it.
+//
+// Pooled connections to the demoted server stay read-only, so new
+// connections have to reach the new primary: use a DSN that targets it, e.g.
+// a multi-host Postgres DSN with target_session_attrs=read-write, or a
+// connection lifetime shorter than maxWait (sql.DB.SetConnMaxLifetime).
+func WithFailoverRetry(maxWait time.Duration) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.FailoverMaxWait = maxWait
+		return nil
+	}
+}
+
+// WithLockTTL makes Apply hold a lease in a companion "<table>_lock" table
+// while it runs, in addition to the row lock taken inside its transactions
+// (default: 0, no lease). The lease expires ttl after it was last renewed;
+// Apply renews it every ttl/3 and releases it when it returns, including when
+// ctx is canceled. Another Apply waits while the lease is held and takes it
+// over once it expired, so a migrator that hangs or dies while holding it
+// blocks the others for at most ttl.
+//
+// When Apply finds that its lease was taken over, e.g. because renewals
+// failed for longer than ttl, it cancels the running migration and fails with
+// ErrLockLost. Expiry is judged by the clocks of the migrating hosts, which
+// should therefore be roughly in sync.
+func WithLockTTL(ttl time.Duration) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.LockTTL = ttl
+		return nil
+	}
+}
+
+// WithEnvAllowlist makes Apply replace ${NAME} in migration statements with
+// the value of the environment variable NAME, for settings that differ per
+// environment such as a schema or tablespace (default: none, ${...} is left as
+// is):
+//
+//	CREATE TABLE ${SCHEMA}.events (id BIGINT) TABLESPACE ${TABLESPACE}
+//
+//	err := migrations.Apply(ctx, db, migs, migrations.WithEnvAllowlist("SCHEMA", "TABLESPACE"))
+//
+// Only the listed variables are read: a migration referring to any other one
+// fails with ErrEnvNotAllowed, and one referring to a listed variable that is
+// not set fails with ErrEnvUnset, before its statement runs. References are
+// replaced everywhere in a statement, including string literals, comments and
+// dollar-quoted bodies, so a location can be written as '${DIR}'. Write $${
+// for a literal ${, e.g. in data or PL/pgSQL bodies that must stay as they
+// are; a dollar quote directly followed by a reference, as in $$${NAME}, reads
+// as that escape, so separate the two with white space. Values are inserted
+// verbatim, without quoting, so they must come from a trusted environment.
+// Statements are interpolated after splitting, by Apply, RollbackTo and
+// DryRun; GenerateScript leaves them as is. Checksums are computed before
+// interpolation, so they match across environments.
+func WithEnvAllowlist(names ...string) Option {
+	// Validation is performed centrally by validateOptions during Apply.
+	return func(opts *Options) error {
+		opts.EnvAllowlist = names
+		return nil
+	}
+}
+
 // Options end
 
 // validateOptions performs centralized validation of Options.
 // - Dialect must be one of the supported constants.
+// - Compatibility must be known and match the dialect.
+// - DDLStrategy is only set with CompatVitess and contains no quotes.
+// - MaxMigrationSize is not negative.
+// - StatementTag cannot end its comment early.
+// - MaxStatementsPerTx is not negative and not used with CompatVitess.
+// - ApprovalToken is only set together with ApprovalVerifier.
+// - Maskers have a column name and a function.
+// - FleetInstance fits the fleet table; FleetMaxAhead and FleetTTL are not negative.
+// - ConcurrentIndexes is only used with the postgres dialect.
+// - LockTTL is not negative.
+// - ReconnectAttempts and FailoverMaxWait are not negative.
+// - EnvAllowlist holds valid variable names.
+// - TxRunner must not be nil.
 // - TableName must be non-empty and match [A-Za-z_][A-Za-z0-9_]*.
 func validateOptions(opts Options) error {
 	if !IsValidDialect(opts.Dialect) {
 		return fmt.Errorf("dialect %d is not supported", opts.Dialect)
 	}
+	if opts.Compatibility < CompatNone || opts.Compatibility >= compatEnd {
+		return fmt.Errorf("compatibility %d is not supported", opts.Compatibility)
+	}
+	if opts.Compatibility == CompatYugabyte && opts.Dialect != DialectPostgres {
+		return fmt.Errorf("yugabyte compatibility requires the postgres dialect")
+	}
+	if opts.Compatibility == CompatVitess && opts.Dialect != DialectMysql {
+		return fmt.Errorf("vitess compatibility requires the mysql dialect")
+	}
+	if opts.DDLStrategy != "" {
+		if opts.Compatibility != CompatVitess {
+			return fmt.Errorf("ddl strategy requires vitess compatibility")
+		}
+		if strings.ContainsAny(opts.DDLStrategy, "'\\\n") {
+			return fmt.Errorf("invalid ddl strategy %q", opts.DDLStrategy)
+		}
+	}
+	if strings.Contains(opts.StatementTag, "/*") || strings.Contains(opts.StatementTag, "*/") || strings.ContainsAny(opts.StatementTag, "\r\n") {
+		return fmt.Errorf("invalid statement tag %q", opts.StatementTag)
+	}
+	if opts.MaxMigrationSize < 0 {
+		return fmt.Errorf("max migration size cannot be negative")
+	}
+	if opts.MaxStatementsPerTx < 0 {
+		return fmt.Errorf("max statements per transaction cannot be negative")
+	}
+	if opts.MaxStatementsPerTx > 0 && opts.Compatibility == CompatVitess {
+		return fmt.Errorf("max statements per transaction is not supported with vitess compatibility")
+	}
+	if opts.ApprovalToken != "" && opts.ApprovalVerifier == nil {
+		return fmt.Errorf("approval token requires an approval verifier")
+	}
+	for column, mask := range opts.Maskers {
+		if column == "" || strings.HasPrefix(column, ".") || strings.HasSuffix(column, ".") {
+			return fmt.Errorf("invalid masker column %q", column)
+		}
+		if mask == nil {
+			return fmt.Errorf("masker for %q cannot be nil", column)
+		}
+	}
+	if len(opts.FleetInstance) > maxFleetInstanceLen {
+		return fmt.Errorf("fleet instance name is longer than %d bytes", maxFleetInstanceLen)
+	}
+	if opts.FleetMaxAhead < 0 {
+		return fmt.Errorf("fleet max ahead cannot be negative")
+	}
+	if opts.FleetTTL < 0 {
+		return fmt.Errorf("fleet ttl cannot be negative")
+	}
+	if opts.ConcurrentIndexes && !dialects[opts.Dialect].capabilities.ConcurrentIndex {
+		return fmt.Errorf("concurrent indexes require the postgres dialect")
+	}
+	if opts.LockTTL < 0 {
+		return fmt.Errorf("lock ttl cannot be negative")
+	}
+	if opts.ReconnectAttempts < 0 {
+		return fmt.Errorf("reconnect attempts cannot be negative")
+	}
+	if opts.FailoverMaxWait < 0 {
+		return fmt.Errorf("failover max wait cannot be negative")
+	}
+	for _, name := range opts.EnvAllowlist {
+		if !isEnvName(name) {
+			return fmt.Errorf("invalid environment variable name %q: only [A-Za-z_][A-Za-z0-9_]* allowed", name)
+		}
+	}
+	if opts.TxRunner == nil {
+		return fmt.Errorf("transaction runner cannot be nil")
+	}
 	if opts.TableName == "" {
 		return fmt.Errorf("table name cannot be empty")
 	}
@@ -148,154 +946,110 @@ func validateOptions(opts Options) error {
 	return nil
 }
 
-func applySqlite(ctx context.Context, db *sql.DB, migrations []string, opts Options) error {
-	err := utils.InTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
-		createStmt := fmt.Sprintf(
-			`CREATE TABLE IF NOT EXISTS "%s" (
-                version INTEGER PRIMARY KEY,
-                applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-            )`, opts.TableName,
-		)
-		if _, err := tx.ExecContext(ctx, createStmt); err != nil {
-			return fmt.Errorf("failed to create migrations table %q: %w", opts.TableName, err)
+// checkMigrationSizes enforces opts.MaxMigrationSize.
+func checkMigrationSizes(migrations []string, opts Options) error {
+	if opts.MaxMigrationSize == 0 {
+		return nil
+	}
+	for version, migration := range migrations {
+		if len(migration) > opts.MaxMigrationSize {
+			return fmt.Errorf("%w: migration #%d is %d bytes, limit is %d", ErrMigrationTooLarge, version+1, len(migration), opts.MaxMigrationSize)
 		}
+	}
+	return nil
+}
 
-		var lastAppliedVersion int
-		queryLast := fmt.Sprintf(`SELECT COALESCE(MAX(version), -1) FROM "%s"`, opts.TableName)
-		if err := tx.QueryRowContext(ctx, queryLast).Scan(&lastAppliedVersion); err != nil {
-			return fmt.Errorf("failed to read last applied migration version: %w", err)
-		}
+const yugabyteMaxAttempts = 5
 
-		for version, migration := range migrations {
-			version++ // so first version is 1 instead of 0
-			if version <= lastAppliedVersion {
-				continue
-			}
-			stmts := utils.SplitStatements(migration)
-			for i, stmt := range stmts {
-				if _, err := tx.ExecContext(ctx, stmt); err != nil {
-					return fmt.Errorf("failed to apply migration #%d (statement %d): %w", version, i+1, err)
-				}
-			}
-
-			insertStmt := fmt.Sprintf(`INSERT INTO "%s" (version) VALUES (?)`, opts.TableName)
-			if _, err := tx.ExecContext(ctx, insertStmt, version); err != nil {
-				return fmt.Errorf("failed to record migration #%d: %w", version, err)
-			}
-		}
+// retryBackoff is the base delay between retried transactions; the n-th retry
+// waits n*retryBackoff.
+var retryBackoff = 100 * time.Millisecond
 
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to apply migrations for sqlitedb: %w", err)
+func isYugabyteRetryable(err error) bool {
+	switch utils.SQLState(err) {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return true
 	}
-	return nil
+	return false
 }
 
-func applyMysql(ctx context.Context, db *sql.DB, migrations []string, opts Options) error {
-	err := utils.InTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
-		t := utils.QuoteIdentBacktick(opts.TableName)
-		createStmt := `CREATE TABLE IF NOT EXISTS ` + t + `(
-                version INT NOT NULL PRIMARY KEY,
-                applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-            )`
-		if _, err := tx.ExecContext(ctx, createStmt); err != nil {
-			return fmt.Errorf("failed to create migrations table %q: %w", opts.TableName, err)
+// retryTx runs fn until it succeeds, fails with a non-retryable error, or
+// maxAttempts is reached. fn must run its own transaction so that every attempt
+// starts from a clean state.
+func retryTx(ctx context.Context, maxAttempts int, retryable func(error) bool, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = fn()
+		if err == nil || !retryable(err) {
+			return err
 		}
-
-		// Ensure the sentinel lock row exists and lock it for the duration of the tx.
-		ensureSentinel := `INSERT IGNORE INTO ` + t + ` (version) VALUES (0)`
-		if _, err := tx.ExecContext(ctx, ensureSentinel); err != nil {
-			return fmt.Errorf("failed to ensure sentinel lock row: %w", err)
+		if attempt == maxAttempts {
+			break
 		}
-		// Lock the sentinel row (InnoDB row-level lock)
-		lockStmt := `SELECT version FROM ` + t + ` WHERE version = 0 FOR UPDATE`
-		if _, err := tx.ExecContext(ctx, lockStmt); err != nil {
-			return fmt.Errorf("failed to lock migrations table: %w", err)
-		}
-
-		var lastAppliedVersion int
-		queryLast := "SELECT COALESCE(MAX(version), -1) FROM " + t
-		if err := tx.QueryRowContext(ctx, queryLast).Scan(&lastAppliedVersion); err != nil {
-			return fmt.Errorf("failed to read last applied migration version: %w", err)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(time.Duration(attempt) * retryBackoff):
 		}
-
-		for version, migration := range migrations {
-			version++ // so first version is 1 instead of 0
-			if version <= lastAppliedVersion {
-				continue
-			}
-			stmts := utils.SplitStatements(migration)
-			for i, stmt := range stmts {
-				if _, err := tx.ExecContext(ctx, stmt); err != nil {
-					return fmt.Errorf("failed to apply migration #%d (statement %d): %w", version, i+1, err)
-				}
-			}
-
-			insertStmt := "INSERT INTO " + t + " (version) VALUES (?)"
-			if _, err := tx.ExecContext(ctx, insertStmt, version); err != nil {
-				return fmt.Errorf("failed to record migration #%d: %w", version, err)
-			}
-		}
-
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to apply migrations for mysql: %w", err)
 	}
-	return nil
+	return fmt.Errorf("giving up after %d attempts: %w", maxAttempts, err)
 }
 
-func applyPostgres(ctx context.Context, db *sql.DB, migrations []string, opts Options) error {
-	err := utils.InTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
-		createStmt := fmt.Sprintf(
-			`CREATE TABLE IF NOT EXISTS "%s" (
-                version INTEGER PRIMARY KEY,
-                applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-            )`, opts.TableName,
-		)
-		if _, err := tx.ExecContext(ctx, createStmt); err != nil {
-			return fmt.Errorf("failed to create migrations table %q: %w", opts.TableName, err)
-		}
+// maxFailoverBackoff caps the delay between retries of retryUntil.
+const maxFailoverBackoff = 2 * time.Second
 
-		// Ensure the sentinel lock row exists and lock it using row-level lock.
-		ensureSentinel := fmt.Sprintf(`INSERT INTO "%s" (version) VALUES (0) ON CONFLICT DO NOTHING`, opts.TableName)
-		if _, err := tx.ExecContext(ctx, ensureSentinel); err != nil {
-			return fmt.Errorf("failed to ensure sentinel lock row: %w", err)
-		}
-		lockStmt := fmt.Sprintf(`SELECT version FROM "%s" WHERE version = 0 FOR UPDATE`, opts.TableName)
-		if _, err := tx.ExecContext(ctx, lockStmt); err != nil {
-			return fmt.Errorf("failed to lock migrations table: %w", err)
-		}
+// isFailover reports whether err is expected while a database fails over.
+func isFailover(err error) bool {
+	return utils.IsReadOnly(err) || utils.IsConnectionLost(err)
+}
 
-		var lastAppliedVersion int
-		queryLast := fmt.Sprintf(`SELECT COALESCE(MAX(version), -1) FROM "%s"`, opts.TableName)
-		if err := tx.QueryRowContext(ctx, queryLast).Scan(&lastAppliedVersion); err != nil {
-			return fmt.Errorf("failed to read last applied migration version: %w", err)
+// retryUntil runs fn until it succeeds, fails with a non-retryable error, or
+// the next attempt would start more than maxWait after the first. fn must run
+// its own transaction so that every attempt starts from a clean state.
+func retryUntil(ctx context.Context, maxWait time.Duration, retryable func(error) bool, fn func() error) error {
+	deadline := time.Now().Add(maxWait)
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) {
+			return err
 		}
-
-		for version, migration := range migrations {
-			version++ // so first version is 1 instead of 0
-			if version <= lastAppliedVersion {
-				continue
-			}
-			stmts := utils.SplitStatements(migration)
-			for i, stmt := range stmts {
-				if _, err := tx.ExecContext(ctx, stmt); err != nil {
-					return fmt.Errorf("failed to apply migration #%d (statement %d): %w", version, i+1, err)
-				}
-			}
-
-			insertStmt := fmt.Sprintf(`INSERT INTO "%s" (version) VALUES ($1)`, opts.TableName)
-			if _, err := tx.ExecContext(ctx, insertStmt, version); err != nil {
-				return fmt.Errorf("failed to record migration #%d: %w", version, err)
-			}
+		wait := min(time.Duration(attempt)*retryBackoff, maxFailoverBackoff)
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("giving up after %d attempts in %s: %w", attempt, maxWait, err)
 		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
 
+// checkTruncatedHistory fails with a *TruncatedHistoryError when versions
+// above the last migration are recorded.
+func checkTruncatedHistory(ctx context.Context, q queryer, d dialect, lastAppliedVersion, migrationsCount int, opts Options) error {
+	if opts.AllowTruncatedHistory || lastAppliedVersion <= migrationsCount {
 		return nil
-	})
+	}
+
+	queryAbove := d.rebind("SELECT version FROM " + d.quoteIdent(opts.TableName) + " WHERE version > ? ORDER BY version")
+	rows, err := q.QueryContext(ctx, queryAbove, migrationsCount)
 	if err != nil {
-		return fmt.Errorf("failed to apply migrations for postgres: %w", err)
+		return fmt.Errorf("failed to read applied versions: %w", err)
 	}
-	return nil
+	defer rows.Close()
+
+	var missing []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return fmt.Errorf("failed to scan applied version: %w", err)
+		}
+		missing = append(missing, v)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read applied versions: %w", err)
+	}
+
+	return &TruncatedHistoryError{Missing: missing}
 }
@@ -0,0 +1,807 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sqlstate " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func countQuery(rec *migrationsmock.Recorder, query string) int {
+	n := 0
+	for _, q := range rec.Queries() {
+		if q == query {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCompatYugabyte(t *testing.T) {
+	defer func(old time.Duration) { retryBackoff = old }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	t.Run("retries serialization failures", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+		rec.Fail("FOR UPDATE", sqlStateError("40001"))
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"},
+			WithDialect(DialectPostgres), WithCompatibility(CompatYugabyte))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got := countQuery(rec, migrationsmock.Begin); got != yugabyteMaxAttempts {
+			t.Fatalf("got %d attempts, want %d", got, yugabyteMaxAttempts)
+		}
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+		rec.Fail("FOR UPDATE", sqlStateError("42P01"))
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"},
+			WithDialect(DialectPostgres), WithCompatibility(CompatYugabyte))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got := countQuery(rec, migrationsmock.Begin); got != 1 {
+			t.Fatalf("got %d attempts, want 1", got)
+		}
+	})
+
+	t.Run("requires postgres dialect", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+
+		err := Apply(context.Background(), db, nil, WithCompatibility(CompatYugabyte))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestCompatVitess(t *testing.T) {
+	t.Run("sets ddl strategy before migrations", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, []string{"ALTER TABLE t ADD COLUMN c INT"},
+			WithDialect(DialectMysql), WithCompatibility(CompatVitess), WithDDLStrategy("vitess --postpone-completion"))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+
+		setAt, alterAt := -1, -1
+		for i, q := range rec.Queries() {
+			switch q {
+			case "SET @@ddl_strategy = 'vitess --postpone-completion'":
+				setAt = i
+			case "ALTER TABLE t ADD COLUMN c INT":
+				alterAt = i
+			}
+		}
+		if setAt == -1 || alterAt == -1 || setAt > alterAt {
+			t.Fatalf("expected SET before ALTER, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("defaults to direct", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, nil, WithDialect(DialectMysql), WithCompatibility(CompatVitess))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, "SET @@ddl_strategy = 'direct'") != 1 {
+			t.Fatalf("expected direct strategy, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+
+		invalid := [][]Option{
+			{WithCompatibility(CompatVitess)},
+			{WithDialect(DialectMysql), WithDDLStrategy("vitess")},
+			{WithDialect(DialectMysql), WithCompatibility(CompatVitess), WithDDLStrategy("x'; DROP TABLE t; --")},
+		}
+		for i, opts := range invalid {
+			if err := Apply(context.Background(), db, nil, opts...); err == nil {
+				t.Fatalf("case %d: expected error", i)
+			}
+		}
+	})
+}
+
+func TestRecordAfterBatch(t *testing.T) {
+	d := dialects[DialectSqlite]
+	d.recordAfterBatch = true
+	migs := []string{"SELECT 1", "SELECT 2"}
+	insert := `INSERT INTO "migrations" (version) VALUES (?)`
+
+	run := func(t *testing.T, userOptions ...Option) []string {
+		t.Helper()
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		// The mock does not keep rows: once the first bookkeeping transaction
+		// committed, report version 1 as the head like a database would.
+		txs := 0
+		runner := func(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+			if txs++; txs > 10 {
+				return errors.New("too many transactions")
+			}
+			err := utils.InTx(ctx, db, fn)
+			if txs == 2 {
+				rec.Return("MAX(version)", []string{"max"}, []any{1})
+			}
+			return err
+		}
+		opts, err := buildOptions(append([]Option{WithTxRunner(runner)}, userOptions...))
+		if err != nil {
+			t.Fatalf("options: %v", err)
+		}
+		if _, err := applyDialect(context.Background(), db, d, migs, nil, opts); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		var got []string
+		for _, q := range rec.Queries() {
+			if q == migrationsmock.Begin || q == migrationsmock.Commit || strings.HasPrefix(q, "INSERT") || strings.HasPrefix(q, "SELECT ") && !strings.Contains(q, "FROM") {
+				got = append(got, q)
+			}
+		}
+		return got
+	}
+
+	t.Run("records after the migrations transaction", func(t *testing.T) {
+		got := run(t)
+		want := []string{
+			migrationsmock.Begin, "SELECT 1", "SELECT 2", migrationsmock.Commit,
+			migrationsmock.Begin, insert, insert, migrationsmock.Commit,
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("records every chunk before the next one", func(t *testing.T) {
+		got := run(t, WithMaxStatementsPerTx(1))
+		want := []string{
+			migrationsmock.Begin, "SELECT 1", migrationsmock.Commit,
+			migrationsmock.Begin, insert, migrationsmock.Commit,
+			migrationsmock.Begin, "SELECT 2", migrationsmock.Commit,
+			migrationsmock.Begin, insert, migrationsmock.Commit,
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRequireNonEmpty(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	err := Apply(context.Background(), db, nil, WithRequireNonEmpty(true))
+	if !errors.Is(err, ErrEmptyMigrations) {
+		t.Fatalf("got %v, want %v", err, ErrEmptyMigrations)
+	}
+	if len(rec.Queries()) != 0 {
+		t.Fatalf("expected no database access, got %q", rec.Queries())
+	}
+}
+
+func TestMaxMigrationSize(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	migs := []string{"SELECT 1", "SELECT 'this one is too large'"}
+	err := Apply(context.Background(), db, migs, WithMaxMigrationSize(10))
+	if !errors.Is(err, ErrMigrationTooLarge) || !strings.Contains(err.Error(), "migration #2") {
+		t.Fatalf("got %v, want %v for migration #2", err, ErrMigrationTooLarge)
+	}
+	if len(rec.Queries()) != 0 {
+		t.Fatalf("expected no database access, got %q", rec.Queries())
+	}
+
+	if _, err := GenerateScript(migs, 0, DialectSqlite, WithMaxMigrationSize(10)); !errors.Is(err, ErrMigrationTooLarge) {
+		t.Fatalf("generate: got %v, want %v", err, ErrMigrationTooLarge)
+	}
+	if err := Apply(context.Background(), db, migs, WithMaxMigrationSize(100)); err != nil {
+		t.Fatalf("apply within limit: %v", err)
+	}
+}
+
+func TestStatementTag(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	err := Apply(context.Background(), db, []string{"SELECT 1; SELECT 2"}, WithStatementTag("deploy=2024-06-01 sha=abc123"))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	for _, want := range []string{
+		"/* migration 1: deploy=2024-06-01 sha=abc123 */ SELECT 1",
+		"/* migration 1: deploy=2024-06-01 sha=abc123 */ SELECT 2",
+	} {
+		if countQuery(rec, want) != 1 {
+			t.Fatalf("expected %q, got %q", want, rec.Queries())
+		}
+	}
+
+	for _, tag := range []string{"a */ DROP TABLE users; /*", "a /* b", "a\nb"} {
+		if err := Apply(context.Background(), db, nil, WithStatementTag(tag)); err == nil {
+			t.Fatalf("expected error for tag %q", tag)
+		}
+	}
+}
+
+func TestExecutor(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	var routed []string
+	exec := func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+		routed = append(routed, stmt)
+		if strings.Contains(stmt, "DROP") {
+			return nil, errors.New("rejected by proxy")
+		}
+		return tx.ExecContext(ctx, stmt)
+	}
+	err := Apply(context.Background(), db, []string{"SELECT 1; SELECT 2"}, WithExecutor(exec), WithStatementTag("sha=abc"))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	want := []string{"/* migration 1: sha=abc */ SELECT 1", "/* migration 1: sha=abc */ SELECT 2"}
+	if !reflect.DeepEqual(routed, want) || countQuery(rec, want[1]) != 1 {
+		t.Fatalf("routed %q, executed %q", routed, rec.Queries())
+	}
+
+	rec.Return("MAX(version)", []string{"max"}, []any{int64(1)})
+	err = Apply(context.Background(), db, []string{"SELECT 1; SELECT 2", "DROP TABLE users"}, WithExecutor(exec))
+	if err == nil || !strings.Contains(err.Error(), "rejected by proxy") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestStatementInjector(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	var seen []int64
+	inject := WithStatementInjector(func(m Migration) (before, after []string) {
+		seen = append(seen, m.Version)
+		if strings.HasPrefix(m.UpSQL, "CREATE TABLE users") {
+			return []string{"SET ROLE owner"}, []string{"ALTER TABLE users ENABLE ROW LEVEL SECURITY"}
+		}
+		return nil, nil
+	})
+	var routed []string
+	exec := WithExecutor(func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+		routed = append(routed, stmt)
+		return tx.ExecContext(ctx, stmt)
+	})
+	migs := []Migration{
+		{UpSQL: "CREATE TABLE users (id INT); CREATE INDEX users_id ON users (id)"},
+		{Func: func(context.Context, *sql.Tx) error { return nil }},
+		{UpSQL: "CREATE EXTENSION x", Dialects: []Dialect{DialectPostgres}},
+		{Statements: []string{"SELECT 1"}},
+	}
+	if err := ApplyMigrations(context.Background(), db, migs, inject, exec); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	want := []string{
+		"SET ROLE owner",
+		"CREATE TABLE users (id INT)",
+		"CREATE INDEX users_id ON users (id)",
+		"ALTER TABLE users ENABLE ROW LEVEL SECURITY",
+		"SELECT 1",
+	}
+	if !reflect.DeepEqual(routed, want) {
+		t.Fatalf("got %q, want %q", routed, want)
+	}
+	if !reflect.DeepEqual(seen, []int64{1, 4}) {
+		t.Fatalf("injector saw versions %v, want [1 4]", seen)
+	}
+
+	rec.Reset()
+	rec.Fail("ENABLE ROW LEVEL SECURITY", errors.New("permission denied"))
+	err := Apply(context.Background(), db, []string{"CREATE TABLE users (id INT)"}, inject)
+	if err == nil || !strings.Contains(err.Error(), `migration #1 (injected statement "ALTER TABLE users ENABLE ROW LEVEL SECURITY")`) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2", "SELECT 3"}
+
+	t.Run("stops at the target", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+		if err := ApplyTo(context.Background(), db, migs, 2); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, "SELECT 2") != 1 || countQuery(rec, "SELECT 1") != 0 || countQuery(rec, "SELECT 3") != 0 {
+			t.Fatalf("expected only version 2 to run, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("refuses a database past the target", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{3})
+		rec.Return("WHERE version > ? ORDER BY version", []string{"version"}, []any{2}, []any{3})
+
+		err := ApplyTo(context.Background(), db, migs, 1)
+		if !errors.Is(err, ErrPastTarget) || !strings.Contains(err.Error(), "versions [2 3] are applied beyond target version 1") {
+			t.Fatalf("got %v, want %v", err, ErrPastTarget)
+		}
+		if err := ApplyTo(context.Background(), db, migs, 1, WithAllowTruncatedHistory(true)); err != nil {
+			t.Fatalf("apply with truncated history allowed: %v", err)
+		}
+	})
+
+	t.Run("keeps other truncation errors", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{4})
+		rec.Return("WHERE version > ? ORDER BY version", []string{"version"}, []any{3}, []any{4})
+
+		err := ApplyTo(context.Background(), db, migs, 2)
+		if !errors.Is(err, ErrHistoryTruncated) || errors.Is(err, ErrPastTarget) {
+			t.Fatalf("got %v, want %v", err, ErrHistoryTruncated)
+		}
+	})
+
+	t.Run("rejects a target out of range", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		if err := ApplyTo(context.Background(), db, migs, 4); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	defer func(old time.Duration) { retryBackoff = old }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	flaky := func(failures int, err error) Option {
+		return WithExecutor(func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+			if failures > 0 {
+				failures--
+				return nil, err
+			}
+			return tx.ExecContext(ctx, stmt)
+		})
+	}
+
+	t.Run("starts over after a lost connection", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"}, WithReconnect(2), flaky(2, driver.ErrBadConn))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if got := countQuery(rec, migrationsmock.Begin); got != 3 {
+			t.Fatalf("got %d attempts, want 3", got)
+		}
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"}, WithReconnect(1), flaky(2, sqlStateError("08006")))
+		if err == nil || !strings.Contains(err.Error(), "giving up after 2 attempts") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"}, WithReconnect(3), flaky(1, errors.New("syntax error")))
+		if err == nil || countQuery(rec, migrationsmock.Begin) != 1 {
+			t.Fatalf("got %v after %d attempts", err, countQuery(rec, migrationsmock.Begin))
+		}
+	})
+}
+
+func TestFailoverRetry(t *testing.T) {
+	defer func(old time.Duration) { retryBackoff = old }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	readOnly := func(failures int) Option {
+		return WithExecutor(func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+			if failures > 0 {
+				failures--
+				return nil, sqlStateError("25006")
+			}
+			return tx.ExecContext(ctx, stmt)
+		})
+	}
+
+	t.Run("waits for a writable primary", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"}, WithFailoverRetry(time.Second), readOnly(3))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if got := countQuery(rec, migrationsmock.Begin); got != 4 {
+			t.Fatalf("got %d attempts, want 4", got)
+		}
+	})
+
+	t.Run("gives up after max wait", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		err := Apply(context.Background(), db, []string{"SELECT 1"}, WithFailoverRetry(20*time.Millisecond), readOnly(1000))
+		if err == nil || !strings.Contains(err.Error(), "giving up after") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("is off by default", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		if err := Apply(context.Background(), db, []string{"SELECT 1"}, readOnly(1)); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestApplyOne(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2", "SELECT 3"}
+	for _, tc := range []struct {
+		name        string
+		lastApplied int
+		want        int
+	}{
+		{name: "applies the next migration", lastApplied: 1, want: 2},
+		{name: "nothing pending", lastApplied: 3, want: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("MAX(version)", []string{"max"}, []any{tc.lastApplied})
+
+			got, err := ApplyOne(context.Background(), db, migs)
+			if err != nil || got != tc.want {
+				t.Fatalf("got %d, %v; want %d", got, err, tc.want)
+			}
+			for version, migration := range migs {
+				want := 0
+				if version+1 == tc.want {
+					want = 1
+				}
+				if n := countQuery(rec, migration); n != want {
+					t.Fatalf("%q ran %d times, want %d", migration, n, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOnFreshDatabase(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		tables    int
+		lastApply int
+		wantCalls int
+	}{
+		{name: "table created by this run", tables: 0, lastApply: -1, wantCalls: 1},
+		{name: "table already existed", tables: 1, lastApply: -1, wantCalls: 0},
+		{name: "concurrent run applied migrations first", tables: 0, lastApply: 2, wantCalls: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("sqlite_master", []string{"count"}, []any{tc.tables})
+			rec.Return("MAX(version)", []string{"max"}, []any{tc.lastApply})
+
+			calls := 0
+			err := Apply(context.Background(), db, []string{"SELECT 1", "SELECT 2"}, WithOnFreshDatabase(func(ctx context.Context, tx *sql.Tx) error {
+				calls++
+				_, err := tx.ExecContext(ctx, "INSERT INTO users (name) VALUES ('admin')")
+				return err
+			}))
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if calls != tc.wantCalls {
+				t.Fatalf("got %d calls, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+
+	t.Run("error rolls back", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+
+		boom := errors.New("boom")
+		err := Apply(context.Background(), db, nil, WithOnFreshDatabase(func(context.Context, *sql.Tx) error {
+			return boom
+		}))
+		if !errors.Is(err, boom) {
+			t.Fatalf("got %v, want %v", err, boom)
+		}
+		if countQuery(rec, migrationsmock.Rollback) != 1 {
+			t.Fatalf("expected rollback, got %q", rec.Queries())
+		}
+	})
+}
+
+func TestPostApplyVerification(t *testing.T) {
+	t.Run("matching head", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		// The mock keeps answering 0, which is the head when nothing is pending.
+		err := Apply(context.Background(), db, nil, WithPostApplyVerification(true))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, `SELECT COALESCE(MAX(version), -1) FROM "migrations"`) != 2 {
+			t.Fatalf("expected head to be read twice, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		// The mock does not persist the recorded version, like a lost commit.
+		err := Apply(context.Background(), db, []string{"SELECT 1"}, WithPostApplyVerification(true))
+		if !errors.Is(err, ErrVerificationFailed) {
+			t.Fatalf("got %v, want %v", err, ErrVerificationFailed)
+		}
+	})
+}
+
+func TestTxRunner(t *testing.T) {
+	t.Run("custom runner may retry", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{0})
+
+		runs := 0
+		retrying := func(ctx context.Context, db *sql.DB, fn func(context.Context, *sql.Tx) error) error {
+			for range 2 {
+				runs++
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					return err
+				}
+				if err := fn(ctx, tx); err != nil {
+					_ = tx.Rollback()
+					return err
+				}
+				if runs == 1 {
+					_ = tx.Rollback() // pretend the commit hit a retryable error
+					continue
+				}
+				return tx.Commit()
+			}
+			return nil
+		}
+
+		report, err := ApplyReport(context.Background(), db, []string{"SELECT 1", "SELECT 2"}, WithTxRunner(retrying))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if runs != 2 {
+			t.Fatalf("got %d runs, want 2", runs)
+		}
+		if len(report.Applied) != 2 {
+			t.Fatalf("report leaked state between attempts: %+v", report)
+		}
+	})
+
+	t.Run("nil runner", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		if err := Apply(context.Background(), db, nil, WithTxRunner(nil)); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestAppliedAtUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		dialect    Dialect
+		columnType string
+		want       string
+	}{
+		{name: "postgres legacy", dialect: DialectPostgres, columnType: "timestamp without time zone", want: "ALTER COLUMN applied_at TYPE TIMESTAMPTZ"},
+		{name: "postgres current", dialect: DialectPostgres, columnType: "timestamp with time zone"},
+		{name: "mysql legacy", dialect: DialectMysql, columnType: "timestamp", want: "MODIFY applied_at DATETIME(6)"},
+		{name: "mysql current", dialect: DialectMysql, columnType: "datetime"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("information_schema.tables", []string{"count"}, []any{1})
+			rec.Return("information_schema.columns", []string{"column_name", "data_type"},
+				[]any{"version", "integer"}, []any{"applied_at", tc.columnType})
+
+			if err := Apply(context.Background(), db, nil, WithDialect(tc.dialect)); err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			var alters []string
+			for _, q := range rec.Queries() {
+				if strings.Contains(q, "ALTER TABLE") {
+					alters = append(alters, q)
+				}
+			}
+			if tc.want == "" {
+				if len(alters) != 0 {
+					t.Fatalf("unexpected upgrade: %q", alters)
+				}
+				return
+			}
+			if len(alters) != 1 || !strings.Contains(alters[0], tc.want) {
+				t.Fatalf("expected upgrade %q, got %q", tc.want, alters)
+			}
+		})
+	}
+}
+
+func TestMaxStatementsPerTx(t *testing.T) {
+	t.Run("resumes after committed statements", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MAX(statements)", []string{"statements"}, []any{2})
+
+		err := Apply(context.Background(), db, []string{"SELECT 1; SELECT 2; SELECT 3"}, WithMaxStatementsPerTx(10))
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, "SELECT 1") != 0 || countQuery(rec, "SELECT 3") != 1 {
+			t.Fatalf("expected only the remaining statement to run, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("negative is rejected", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		if err := Apply(context.Background(), db, nil, WithMaxStatementsPerTx(-1)); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestServerSideCancel(t *testing.T) {
+	for _, tc := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{DialectPostgres, "SELECT pg_cancel_backend(42)"},
+		{DialectMysql, "KILL QUERY 42"},
+	} {
+		t.Run(tc.dialect.String(), func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("pg_backend_pid()", []string{"pid"}, []any{42})
+			rec.Return("CONNECTION_ID()", []string{"id"}, []any{42})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// The callback stands in for a long-running statement: it is
+			// interrupted by ctx and waits until the cancellation was sent.
+			err := Apply(ctx, db, []string{"SELECT 1"}, WithDialect(tc.dialect), WithServerSideCancel(true),
+				WithOnFreshDatabase(func(ctx context.Context, tx *sql.Tx) error {
+					cancel()
+					deadline := time.Now().Add(5 * time.Second)
+					for countQuery(rec, tc.want) == 0 && time.Now().Before(deadline) {
+						time.Sleep(time.Millisecond)
+					}
+					return ctx.Err()
+				}))
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got %v, want context.Canceled", err)
+			}
+			if countQuery(rec, tc.want) != 1 {
+				t.Fatalf("expected %q, got %q", tc.want, rec.Queries())
+			}
+		})
+	}
+
+	t.Run("not requested", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		if err := Apply(context.Background(), db, []string{"SELECT 1"}, WithDialect(DialectPostgres)); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if countQuery(rec, "SELECT pg_backend_pid()") != 0 {
+			t.Fatalf("unexpected backend id query: %q", rec.Queries())
+		}
+	})
+}
+
+func TestFleetGuard(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2", "SELECT 3"}
+
+	t.Run("too far ahead", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MIN(expected_version)", []string{"min"}, []any{1})
+
+		err := Apply(context.Background(), db, migs, WithFleetGuard("canary-1", 1, time.Hour))
+		if !errors.Is(err, ErrFleetSkew) {
+			t.Fatalf("expected ErrFleetSkew, got %v", err)
+		}
+		if countQuery(rec, "SELECT 1") != 0 || countQuery(rec, migrationsmock.Commit) != 0 {
+			t.Fatalf("expected nothing to be applied, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("within policy", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("MIN(expected_version)", []string{"min"}, []any{1})
+
+		if err := Apply(context.Background(), db, migs, WithFleetGuard("canary-1", 2, 0)); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		var registered bool
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, `INSERT INTO "migrations_fleet"`) {
+				registered = len(s.Args) == 3 && s.Args[0] == "canary-1" && s.Args[1] == int64(3)
+			}
+		}
+		if !registered || countQuery(rec, "SELECT 3") != 1 {
+			t.Fatalf("expected registration and apply, got %q", rec.Queries())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		for _, opt := range []Option{
+			WithFleetGuard("a", -1, 0),
+			WithFleetGuard("a", 0, -time.Second),
+			WithFleetGuard(strings.Repeat("a", 256), 0, 0),
+		} {
+			if err := Apply(context.Background(), db, migs, opt); err == nil {
+				t.Fatal("expected error")
+			}
+		}
+	})
+}
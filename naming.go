@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FileOption configures how FromFS, LoadFS and the other file loaders read a
+// migrations directory.
+type FileOption func(opts *fileOptions)
+
+type fileOptions struct {
+	pattern      *regexp.Regexp
+	convention   NamingConvention
+	goMigrations []Migration
+}
+
+// WithFileNamePattern makes loading fail unless every .sql file name in the
+// directory matches re, e.g. regexp.MustCompile(`^\d{4}_[a-z0-9_]+\.sql$`).
+func WithFileNamePattern(re *regexp.Regexp) FileOption {
+	return func(opts *fileOptions) {
+		opts.pattern = re
+	}
+}
+
+// WithNamingConvention makes loading fail when a .sql file name in the
+// directory breaks c, so a large team keeps a tidy migrations directory
+// without a separate linter.
+func WithNamingConvention(c NamingConvention) FileOption {
+	return func(opts *fileOptions) {
+		opts.convention = c
+	}
+}
+
+// WithGoMigrations interleaves migs with the migration files, so code-driven
+// backfills (see Migration.Func) share the files' version numbering and
+// timeline. Every Migration must have its Version set and takes the place of a
+// file with that version:
+//
+//	migs, err := migrations.LoadFS(migrationFiles, "migrations", // 0001_init.sql, 0002_add_email.sql, 0004_email_index.sql
+//		migrations.WithGoMigrations(migrations.Migration{Version: 3, Name: "backfill_email", Func: backfillEmail}))
+//
+// Only LoadFS returns Go migrations; the loaders returning SQL strings fail
+// when one is given.
+func WithGoMigrations(migs ...Migration) FileOption {
+	return func(opts *fileOptions) {
+		opts.goMigrations = append(opts.goMigrations, migs...)
+	}
+}
+
+// NamingConvention describes the file names accepted by WithNamingConvention.
+// The zero value accepts every name the loaders understand.
+type NamingConvention struct {
+	// VersionWidth is the exact number of digits of the zero-padded version,
+	// e.g. 4 for "0001_init.sql" (0: any).
+	VersionWidth int
+	// SnakeCase requires the version to be followed by "_" ("__" for Flyway
+	// names) and a lower_snake_case description.
+	SnakeCase bool
+	// UpDownPairs requires every migration to be a golang-migrate
+	// .up.sql/.down.sql pair.
+	UpDownPairs bool
+}
+
+var snakeCaseRe = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+
+// checkFileName reports the first rule of opts that name breaks.
+func checkFileName(name string, version int, opts fileOptions) error {
+	if opts.pattern != nil && !opts.pattern.MatchString(name) {
+		return fmt.Errorf("migration file %q does not match the naming pattern %q", name, opts.pattern)
+	}
+
+	c := opts.convention
+	rest, flyway := flywayName(name)
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	if c.VersionWidth > 0 && digits != c.VersionWidth {
+		return fmt.Errorf("migration file %q: the version must be zero-padded to %d digits, e.g. %q", name, c.VersionWidth, fmt.Sprintf("%0*d", c.VersionWidth, version))
+	}
+	if c.SnakeCase {
+		sep := "_"
+		if flyway {
+			sep = "__"
+		}
+		if !strings.HasPrefix(rest[digits:], sep) || strings.HasPrefix(rest[digits+len(sep):], "_") {
+			return fmt.Errorf("migration file %q: the version must be followed by %q and a description", name, sep)
+		}
+		if desc := fileName(name); !snakeCaseRe.MatchString(desc) {
+			return fmt.Errorf("migration file %q: description %q is not snake_case", name, desc)
+		}
+	}
+	if base := trimGzip(name); c.UpDownPairs && !strings.HasSuffix(base, ".up.sql") && !strings.HasSuffix(base, ".down.sql") {
+		return fmt.Errorf("migration file %q: migrations must be .up.sql/.down.sql pairs", name)
+	}
+	return nil
+}
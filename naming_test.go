@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNamingConvention(t *testing.T) {
+	strict := WithNamingConvention(NamingConvention{VersionWidth: 4, SnakeCase: true})
+	tidy := fstest.MapFS{
+		"m/0001_create_users.sql": {},
+		"m/0002_add_index_2.sql":  {},
+	}
+	if _, err := LoadFS(tidy, "m", strict); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := LoadFS(fstest.MapFS{"m/V0001__create_users.sql": {}}, "m", strict); err != nil {
+		t.Fatalf("unexpected error for flyway name: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		file   string
+		option FileOption
+		want   string
+	}{
+		{"width", "m/1_init.sql", strict, `"1_init.sql": the version must be zero-padded to 4 digits, e.g. "0001"`},
+		{"separator", "m/0001-init.sql", strict, `"0001-init.sql": the version must be followed by "_" and a description`},
+		{"no description", "m/0001.sql", strict, `the version must be followed by "_"`},
+		{"camel case", "m/0001_AddUsers.sql", strict, `description "AddUsers" is not snake_case`},
+		{"double underscore", "m/0001__init.sql", strict, `the version must be followed by "_"`},
+		{"pairs", "m/0001_init.sql", WithNamingConvention(NamingConvention{UpDownPairs: true}), "must be .up.sql/.down.sql pairs"},
+		{"missing down", "m/0001_init.up.sql", WithNamingConvention(NamingConvention{UpDownPairs: true}), `"0001_init.up.sql" has no matching .down.sql file`},
+		{"pattern", "m/0001_init.sql", WithFileNamePattern(regexp.MustCompile(`^\d{4}_[a-z_]+\.up\.sql$`)), `"0001_init.sql" does not match the naming pattern`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FromFS(fstest.MapFS{tc.file: {}}, "m", tc.option)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
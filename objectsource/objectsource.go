@@ -0,0 +1,167 @@
+// Package objectsource loads migrations from an object storage bucket such
+// as Amazon S3 or Google Cloud Storage, so schema changes can be rolled out
+// without rebuilding the services that apply them.
+//
+// To keep the module free of cloud SDK dependencies, the bucket is accessed
+// through the small Bucket interface. An adapter for the AWS SDK v2 looks
+// like:
+//
+//	type s3Bucket struct {
+//		client *s3.Client
+//		name   string
+//	}
+//
+//	func (b s3Bucket) List(ctx context.Context, prefix string) ([]objectsource.Object, error) {
+//		var objects []objectsource.Object
+//		p := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{Bucket: &b.name, Prefix: &prefix})
+//		for p.HasMorePages() {
+//			page, err := p.NextPage(ctx)
+//			if err != nil {
+//				return nil, err
+//			}
+//			for _, o := range page.Contents {
+//				md5, _ := hex.DecodeString(strings.Trim(*o.ETag, `"`)) // not an MD5 for multipart uploads
+//				objects = append(objects, objectsource.Object{Key: *o.Key, MD5: md5})
+//			}
+//		}
+//		return objects, nil
+//	}
+//
+//	func (b s3Bucket) Read(ctx context.Context, key string) ([]byte, error) {
+//		out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.name, Key: &key})
+//		if err != nil {
+//			return nil, err
+//		}
+//		defer out.Body.Close()
+//		return io.ReadAll(out.Body)
+//	}
+//
+// and for Google Cloud Storage, List iterates bucket.Objects with
+// storage.Query{Prefix: prefix} and reports attrs.MD5.
+package objectsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pechorka/migrations"
+)
+
+// ErrChecksumMismatch is returned when a downloaded object does not match the
+// MD5 reported by the bucket listing.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// Object is an entry of a bucket listing.
+type Object struct {
+	Key string
+	// MD5 is the content hash reported by the storage service, verified after
+	// download. Leave it nil when the service does not provide one, e.g. for
+	// S3 multipart uploads.
+	MD5 []byte
+}
+
+// Bucket is the part of an object storage client Source needs.
+type Bucket interface {
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Read returns the content of the object stored under key.
+	Read(ctx context.Context, key string) ([]byte, error)
+}
+
+var _ migrations.Source = (*Source)(nil)
+
+// Source is a migrations.Source reading the .sql objects stored directly
+// under a bucket prefix. Object names follow the same rules as files for
+// migrations.LoadFS, e.g. "0001_create_users.sql".
+type Source struct {
+	bucket Bucket
+	prefix string
+}
+
+// New returns a Source reading the migrations under prefix, e.g.
+// "migrations/orders/", from bucket. A prefix not ending in "/" is treated
+// as a directory all the same.
+func New(bucket Bucket, prefix string) *Source {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Source{bucket: bucket, prefix: prefix}
+}
+
+// Migrations lists the prefix, downloads every .sql or .sql.gz object
+// directly under it and verifies its MD5.
+func (s *Source) Migrations(ctx context.Context) ([]migrations.Migration, error) {
+	objects, err := s.bucket.List(ctx, s.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations under %q: %w", s.prefix, err)
+	}
+
+	files := memFS{}
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, s.prefix)
+		if name == o.Key || name == "" || strings.Contains(name, "/") || !strings.HasSuffix(strings.TrimSuffix(name, ".gz"), ".sql") {
+			continue
+		}
+		content, err := s.bucket.Read(ctx, o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %q: %w", o.Key, err)
+		}
+		if o.MD5 != nil {
+			if got := md5.Sum(content); !bytes.Equal(got[:], o.MD5) {
+				return nil, fmt.Errorf("%w: %q has md5 %x, listing reports %x", ErrChecksumMismatch, o.Key, got, o.MD5)
+			}
+		}
+		files[name] = content
+	}
+	return migrations.LoadFS(files, ".")
+}
+
+// memFS is a flat, read-only fs.FS over downloaded objects, just enough for
+// migrations.LoadFS.
+type memFS map[string][]byte
+
+func (m memFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m memFS) ReadFile(name string) ([]byte, error) {
+	content, ok := m[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return content, nil
+}
+
+func (m memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(m))
+	for name, content := range m {
+		entries = append(entries, fileEntry{name: name, size: int64(len(content))})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+type fileEntry struct {
+	name string
+	size int64
+}
+
+func (e fileEntry) Name() string               { return e.name }
+func (e fileEntry) IsDir() bool                { return false }
+func (e fileEntry) Type() fs.FileMode          { return 0 }
+func (e fileEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e fileEntry) Size() int64                { return e.size }
+func (e fileEntry) Mode() fs.FileMode          { return 0o444 }
+func (e fileEntry) ModTime() time.Time         { return time.Time{} }
+func (e fileEntry) Sys() any                   { return nil }
@@ -0,0 +1,72 @@
+package objectsource
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pechorka/migrations"
+)
+
+type fakeBucket map[string]string
+
+func (b fakeBucket) List(_ context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for key, content := range b {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			sum := md5.Sum([]byte(content))
+			objects = append(objects, Object{Key: key, MD5: sum[:]})
+		}
+	}
+	return objects, nil
+}
+
+func (b fakeBucket) Read(_ context.Context, key string) ([]byte, error) {
+	content, ok := b[key]
+	if !ok {
+		return nil, fmt.Errorf("no such key %q", key)
+	}
+	return []byte(content), nil
+}
+
+func TestSource(t *testing.T) {
+	bucket := fakeBucket{
+		"orders/0002_index.up.sql":   "CREATE INDEX i ON orders (id)",
+		"orders/0001_init.sql":       "CREATE TABLE orders (id INT)",
+		"orders/0002_index.down.sql": "DROP INDEX i",
+		"orders/README.md":           "docs",
+		"orders/old/0001_x.sql":      "SELECT 0",
+		"orders-archive/0001_y.sql":  "SELECT 0",
+	}
+
+	got, err := New(bucket, "orders").Migrations(context.Background())
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	want := []migrations.Migration{
+		{Version: 1, Name: "init", UpSQL: "CREATE TABLE orders (id INT)"},
+		{Version: 2, Name: "index", UpSQL: "CREATE INDEX i ON orders (id)", DownSQL: "DROP INDEX i"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSourceChecksumMismatch(t *testing.T) {
+	bucket := corruptBucket{fakeBucket{"m/0001_init.sql": "CREATE TABLE t (id INT)"}}
+	_, err := New(bucket, "m/").Migrations(context.Background())
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+// corruptBucket serves different content than it lists.
+type corruptBucket struct{ fakeBucket }
+
+func (b corruptBucket) Read(ctx context.Context, key string) ([]byte, error) {
+	content, err := b.fakeBucket.Read(ctx, key)
+	return append(content, " -- truncated"...), err
+}
@@ -0,0 +1,276 @@
+// Package migrationsmock provides an in-memory database/sql backend for unit
+// testing code that calls the migrations package.
+//
+// DB returns a regular *sql.DB backed by a fake driver together with a
+// Recorder. The Recorder keeps every statement sent to the database (including
+// BEGIN/COMMIT/ROLLBACK markers) and can be scripted to return rows for
+// queries or to fail selected statements:
+//
+//	db, rec := migrationsmock.DB()
+//	rec.Return("MAX(version)", []string{"max"}, []any{-1})
+//	rec.Fail("INSERT INTO users", errors.New("boom"))
+//
+//	err := migrations.Apply(ctx, db, migs)
+//	// inspect err and rec.Statements()
+//
+// Nothing is executed for real: statements without a matching rule succeed and
+// queries without a matching rule return a single row with a single 0 value,
+// which the bookkeeping queries issued by Apply read as an empty database.
+package migrationsmock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Markers recorded for transaction boundaries. Rules can match them too, e.g.
+// rec.Fail(migrationsmock.Commit, err) makes every commit fail.
+const (
+	Begin    = "BEGIN"
+	Commit   = "COMMIT"
+	Rollback = "ROLLBACK"
+)
+
+// Statement is a single recorded call to the database.
+type Statement struct {
+	Query string
+	Args  []any
+}
+
+// Recorder records statements sent through a DB and holds the rules used to
+// answer them. It is safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	stmts []Statement
+	rules []rule
+}
+
+type rule struct {
+	contains string
+	err      error
+	columns  []string
+	rows     [][]driver.Value
+}
+
+// DB returns a *sql.DB backed by an in-memory fake driver and the Recorder
+// attached to it. Each call returns an independent pair.
+func DB() (*sql.DB, *Recorder) {
+	rec := &Recorder{}
+	return sql.OpenDB(connector{rec: rec}), rec
+}
+
+// Fail makes every statement containing the given substring return err.
+// Rules are checked in registration order; the first match wins.
+func (r *Recorder) Fail(contains string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule{contains: contains, err: err})
+}
+
+// Return makes every query containing the given substring return the provided
+// columns and rows (no rows at all when none are given). Values are converted
+// with driver.DefaultParameterConverter, so plain Go ints, strings, etc. can be
+// used.
+//
+// Return panics if a value cannot be converted; it is meant for test setup.
+func (r *Recorder) Return(contains string, columns []string, rows ...[]any) {
+	converted := make([][]driver.Value, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			panic(fmt.Sprintf("migrationsmock: row has %d values, want %d", len(row), len(columns)))
+		}
+		vals := make([]driver.Value, len(row))
+		for i, v := range row {
+			cv, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				panic(fmt.Sprintf("migrationsmock: column %q: %v", columns[i], err))
+			}
+			vals[i] = cv
+		}
+		converted = append(converted, vals)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule{contains: contains, columns: columns, rows: converted})
+}
+
+// Statements returns a copy of everything recorded so far, in order.
+func (r *Recorder) Statements() []Statement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Statement, len(r.stmts))
+	copy(out, r.stmts)
+	return out
+}
+
+// Queries returns only the query text of the recorded statements.
+func (r *Recorder) Queries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, len(r.stmts))
+	for i, s := range r.stmts {
+		out[i] = s.Query
+	}
+	return out
+}
+
+// Reset forgets recorded statements. Rules are kept.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stmts = nil
+}
+
+// record stores the statement and returns the first rule matching it.
+func (r *Recorder) record(query string, args []driver.NamedValue) (rule, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stmtArgs []any
+	for _, a := range args {
+		stmtArgs = append(stmtArgs, a.Value)
+	}
+	r.stmts = append(r.stmts, Statement{Query: query, Args: stmtArgs})
+
+	for _, rl := range r.rules {
+		if strings.Contains(query, rl.contains) {
+			return rl, true
+		}
+	}
+	return rule{}, false
+}
+
+func (r *Recorder) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	rl, ok := r.record(query, args)
+	if ok && rl.err != nil {
+		return nil, rl.err
+	}
+	return result{}, nil
+}
+
+func (r *Recorder) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	rl, ok := r.record(query, args)
+	if !ok {
+		return &rows{columns: []string{"value"}, values: [][]driver.Value{{int64(0)}}}, nil
+	}
+	if rl.err != nil {
+		return nil, rl.err
+	}
+	return &rows{columns: rl.columns, values: rl.rows}, nil
+}
+
+type connector struct {
+	rec *Recorder
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{rec: c.rec}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return drv{rec: c.rec}
+}
+
+type drv struct {
+	rec *Recorder
+}
+
+func (d drv) Open(string) (driver.Conn, error) {
+	return &conn{rec: d.rec}, nil
+}
+
+type conn struct {
+	rec *Recorder
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.rec.exec(Begin, nil); err != nil {
+		return nil, err
+	}
+	return tx{rec: c.rec}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.rec.exec(query, args)
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.rec.query(query, args)
+}
+
+type tx struct {
+	rec *Recorder
+}
+
+func (t tx) Commit() error {
+	_, err := t.rec.exec(Commit, nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.rec.exec(Rollback, nil)
+	return err
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.rec.exec(s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.rec.query(s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+type result struct{}
+
+func (result) LastInsertId() (int64, error) { return 0, nil }
+func (result) RowsAffected() (int64, error) { return 0, nil }
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	pos     int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
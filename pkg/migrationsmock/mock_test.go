@@ -0,0 +1,76 @@
+package migrationsmock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	migrations "github.com/pechorka/migrations"
+)
+
+func TestDB(t *testing.T) {
+	t.Run("records statements in order", func(t *testing.T) {
+		db, rec := DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{-1})
+
+		migs := []string{"CREATE TABLE a (id INT); CREATE TABLE b (id INT)", "CREATE TABLE c (id INT)"}
+		if err := migrations.Apply(context.Background(), db, migs); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+
+		var got []string
+		for _, q := range rec.Queries() {
+			if strings.HasPrefix(q, "CREATE TABLE ") && !strings.Contains(q, "IF NOT EXISTS") {
+				got = append(got, q)
+			}
+		}
+		want := []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)", "CREATE TABLE c (id INT)"}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+
+		queries := rec.Queries()
+		if queries[0] != Begin || queries[len(queries)-1] != Commit {
+			t.Fatalf("expected statements wrapped in a transaction, got %q", queries)
+		}
+	})
+
+	t.Run("error injection rolls back", func(t *testing.T) {
+		db, rec := DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{-1})
+		boom := errors.New("boom")
+		rec.Fail("CREATE TABLE b", boom)
+
+		err := migrations.Apply(context.Background(), db, []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"})
+		if !errors.Is(err, boom) {
+			t.Fatalf("got %v, want %v", err, boom)
+		}
+		queries := rec.Queries()
+		if queries[len(queries)-1] != Rollback {
+			t.Fatalf("expected rollback, got %q", queries)
+		}
+	})
+
+	t.Run("recorded args", func(t *testing.T) {
+		db, rec := DB()
+		defer db.Close()
+		rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+		err := migrations.Apply(context.Background(), db, []string{"SELECT 1", "SELECT 2"})
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, "INSERT INTO") {
+				if len(s.Args) != 1 || s.Args[0] != int64(2) {
+					t.Fatalf("unexpected args for %q: %v", s.Query, s.Args)
+				}
+				return
+			}
+		}
+		t.Fatalf("no bookkeeping insert recorded: %q", rec.Queries())
+	})
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// SQLState extracts the SQLSTATE code from a driver error, or returns "" when
+// the error does not carry one. Both lib/pq and pgx errors expose it via a
+// SQLState() method.
+func SQLState(err error) string {
+	var withState interface{ SQLState() string }
+	if errors.As(err, &withState) {
+		return withState.SQLState()
+	}
+	return ""
+}
+
+// IsConnectionLost reports whether err means the connection to the database
+// broke, e.g. after a failover: driver.ErrBadConn, sql.ErrConnDone, an
+// unexpected EOF or reset from the network, SQLSTATE class 08 (connection
+// exception) or 57P01-57P03 (server shutting down or starting up).
+func IsConnectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	state := SQLState(err)
+	if strings.HasPrefix(state, "08") || state == "57P01" || state == "57P02" || state == "57P03" {
+		return true
+	}
+	// go-sql-driver/mysql reports a connection that broke mid-command as
+	// mysql.ErrInvalidConn, which does not wrap driver.ErrBadConn.
+	return err.Error() == "invalid connection" || strings.HasSuffix(err.Error(), ": invalid connection")
+}
+
+// IsReadOnly reports whether err means the server refused a write because it
+// is read-only, as a Postgres primary briefly is while it is demoted during a
+// failover (SQLSTATE 25006) or a MySQL server started with --read-only or
+// --super-read-only.
+func IsReadOnly(err error) bool {
+	if err == nil {
+		return false
+	}
+	if SQLState(err) == "25006" {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "read-only transaction") || strings.Contains(msg, "read only transaction") ||
+		strings.Contains(msg, "--read-only option") || strings.Contains(msg, "--super-read-only option")
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+type stateError string
+
+func (e stateError) Error() string    { return "sqlstate " + string(e) }
+func (e stateError) SQLState() string { return string(e) }
+
+func TestIsConnectionLost(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("exec: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{stateError("08006"), true},
+		{stateError("57P01"), true},
+		{stateError("42P01"), false},
+		{errors.New("invalid connection"), true},
+		{errors.New("syntax error"), false},
+	} {
+		if got := IsConnectionLost(tc.err); got != tc.want {
+			t.Errorf("IsConnectionLost(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{stateError("25006"), true},
+		{errors.New("pq: cannot execute CREATE TABLE in a read-only transaction"), true},
+		{errors.New("Error 1290 (HY000): The MySQL server is running with the --super-read-only option so it cannot execute this statement"), true},
+		{errors.New("Error 1792 (25006): Cannot execute statement in a READ ONLY transaction."), true},
+		{stateError("42P01"), false},
+	} {
+		if got := IsReadOnly(tc.err); got != tc.want {
+			t.Errorf("IsReadOnly(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
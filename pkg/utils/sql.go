@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -29,30 +31,186 @@ func InTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.
 	return nil
 }
 
+// Flavor selects the SQL syntax understood by the splitter.
+type Flavor int
+
+const (
+	// FlavorGeneric splits on top-level semicolons and understands the union
+	// of quoting rules used by SQLite, Postgres and MySQL; backslashes escape
+	// in every '...' string, as on MySQL.
+	FlavorGeneric Flavor = iota
+	FlavorSqlite
+	// FlavorPostgres treats backslashes as escapes in E'...' strings only, as
+	// with standard_conforming_strings.
+	FlavorPostgres
+	// FlavorMysql keeps executable comments (/*! ... */, /*M! ... */) and
+	// optimizer hints (/*+ ... */) in the output instead of stripping them.
+	FlavorMysql
+	// FlavorTSQL splits SQL Server scripts into batches on line-anchored GO
+	// separators (optionally with a repeat count, e.g. "GO 3") instead of on
+	// semicolons, so BEGIN TRY ... END CATCH blocks stay intact. It understands
+	// [bracketed] identifiers, and backslashes are not escapes.
+	FlavorTSQL
+)
+
 // SplitStatements splits SQL text into individual statements by semicolons.
 // It avoids splitting inside quoted strings/identifiers, Postgres dollar-quoted
 // blocks, and SQL comments. Empty/whitespace-only statements are dropped.
 func SplitStatements(s string) []string {
-	var out []string
+	out, _ := splitStatements(s, FlavorGeneric)
+	return out
+}
+
+// SplitStatementsStrict works like SplitStatements but returns an error when
+// the input ends inside a quoted string/identifier, block comment, or
+// dollar-quoted block, since the remainder would otherwise silently end up in
+// the last statement.
+func SplitStatementsStrict(s string) ([]string, error) {
+	return splitStatements(s, FlavorGeneric)
+}
+
+// SplitStatementsFlavor works like SplitStatements using the rules of the
+// given flavor.
+func SplitStatementsFlavor(s string, flavor Flavor) []string {
+	out, _ := splitStatements(s, flavor)
+	return out
+}
+
+// SplitStatementsStrictFlavor works like SplitStatementsStrict using the rules
+// of the given flavor.
+func SplitStatementsStrictFlavor(s string, flavor Flavor) ([]string, error) {
+	return splitStatements(s, flavor)
+}
+
+// SplitError is returned by the strict splitters when the input ends inside a
+// quoted string/identifier, comment, dollar-quoted or BEGIN ... END block.
+type SplitError struct {
+	// Construct describes what is left open, e.g. "single-quoted string".
+	Construct string
+	// Statement is the 1-based number of the statement containing it.
+	Statement int
+	// Line is the 1-based line where it starts.
+	Line int
+}
+
+func (e *SplitError) Error() string {
+	return fmt.Sprintf("unterminated %s starting at line %d", e.Construct, e.Line)
+}
+
+// StatementReader splits SQL read from an io.Reader into statements like
+// SplitStatementsFlavor, holding only the statement being read in memory.
+type StatementReader struct {
+	r       *bufio.Reader
+	flavor  Flavor
+	buf     string   // text after the last complete statement
+	pending []string // complete statements not returned yet
+	eof     bool
+}
+
+// NewStatementReader returns a StatementReader reading from r.
+func NewStatementReader(r io.Reader, flavor Flavor) *StatementReader {
+	return &StatementReader{r: bufio.NewReader(r), flavor: flavor}
+}
+
+// Next returns the next statement, or io.EOF when all were returned.
+func (sr *StatementReader) Next() (string, error) {
+	for len(sr.pending) == 0 {
+		if sr.eof {
+			return "", io.EOF
+		}
+		line, err := sr.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		sr.buf += line
+		if err == io.EOF {
+			sr.eof = true
+			sr.pending, _ = splitStatements(sr.buf, sr.flavor)
+			sr.buf = ""
+			continue
+		}
+		// Only a line that may hold a separator can end a statement.
+		if sr.flavor == FlavorTSQL && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "GO") ||
+			sr.flavor != FlavorTSQL && strings.Contains(line, ";") {
+			out, complete, rest, _ := split(sr.buf, sr.flavor)
+			sr.pending, sr.buf = out[:complete], sr.buf[rest:]
+		}
+	}
+	stmt := sr.pending[0]
+	sr.pending = sr.pending[1:]
+	return stmt, nil
+}
+
+func splitStatements(s string, flavor Flavor) ([]string, error) {
+	out, _, _, err := split(s, flavor)
+	return out, err
+}
+
+// split splits s like splitStatements. It also returns how many statements of
+// out were ended by a separator, and the offset in s where the statement after
+// the last separator starts.
+func split(s string, flavor Flavor) (out []string, complete, rest int, err error) {
+	// Statements are slices of s. Only when a comment is dropped from the
+	// middle of a statement is the text before it copied into b; segStart is
+	// where the not yet copied part of the current statement begins.
 	var b strings.Builder
+	segStart := 0
+	stmtStart := 0 // where the current statement begins, comments included
+
+	tsql := flavor == FlavorTSQL
 
 	inS, inD, inB := false, false, false // single ', double ", backtick `
+	escapes := false                     // backslashes escape in the current '...' string
+	inSquare := false                    // [bracketed] identifier (T-SQL)
 	inLineComment := false               // -- ... \n
 	// nested block comments
-	inBlockComment := 0 // 0 == not in, >0 == nesting level
+	inBlockComment := 0  // 0 == not in, >0 == nesting level
+	keepComment := false // the block comment is a MySQL executable comment or hint
+	commentStart := 0    // offset of the comment being dropped
 
 	dollarTag := "" // when non-empty, we are inside $tag$...$tag$
 
+	openedAt := 0 // offset where the current quote/comment/dollar block started
+
+	// Trigger, procedure and BEGIN ATOMIC bodies contain semicolons inside
+	// BEGIN ... END. Blocks are only tracked in CREATE TRIGGER / PROCEDURE /
+	// FUNCTION / EVENT statements, so neither a "BEGIN;" transaction statement
+	// nor a column named begin or trigger is mistaken for one.
+	firstWord := true  // the next word starts the current statement
+	inCreate := false  // in "CREATE [OR REPLACE] [DEFINER=...] [TEMPORARY]", before the object type
+	inRoutine := false // the statement creates an object with a BEGIN ... END body
+	blockDepth := 0    // open BEGIN (and nested CASE) keywords
+	blockOpenedAt := 0 // offset of the outermost open BEGIN
+
 	hasPrefixAt := func(i int, p string) bool {
 		return i+len(p) <= len(s) && s[i:i+len(p)] == p
 	}
 
-	flush := func() {
-		stmt := strings.TrimSpace(b.String())
-		if stmt != "" {
+	// current returns the current statement up to offset end.
+	current := func(end int) string {
+		if b.Len() == 0 {
+			return strings.TrimSpace(s[segStart:end])
+		}
+		return strings.TrimSpace(b.String() + s[segStart:end])
+	}
+
+	// drop removes s[from:to] from the current statement.
+	drop := func(from, to int) {
+		if b.Len() > 0 || strings.TrimSpace(s[segStart:from]) != "" {
+			b.WriteString(s[segStart:from])
+		}
+		segStart = to
+	}
+
+	// flush ends the current statement at offset end; the next one starts at
+	// offset next.
+	flush := func(end, next int) {
+		if stmt := current(end); stmt != "" {
 			out = append(out, stmt)
 		}
 		b.Reset()
+		segStart, stmtStart = next, next
+		firstWord, inCreate, inRoutine, blockDepth = true, false, false, 0
 	}
 
 	for i := 0; i < len(s); i++ {
@@ -61,6 +219,7 @@ func SplitStatements(s string) []string {
 		if inLineComment {
 			if c == '\n' {
 				inLineComment = false
+				drop(commentStart, i+1)
 			}
 			continue
 		}
@@ -74,33 +233,28 @@ func SplitStatements(s string) []string {
 			if hasPrefixAt(i, "*/") {
 				inBlockComment--
 				i++
+				if inBlockComment == 0 && !keepComment {
+					drop(commentStart, i+1)
+				}
 			}
 			continue
 		}
 
 		if dollarTag != "" {
 			if hasPrefixAt(i, dollarTag) {
-				b.WriteString(dollarTag)
 				i += len(dollarTag) - 1
 				dollarTag = ""
-				continue
 			}
-			b.WriteByte(c)
 			continue
 		}
 
 		if inS {
-			b.WriteByte(c)
-			if c == '\\' { // backslash escape (MySQL)
-				if i+1 < len(s) {
-					b.WriteByte(s[i+1])
-					i++
-				}
+			if c == '\\' && escapes {
+				i++
 				continue
 			}
 			if c == '\'' {
 				if i+1 < len(s) && s[i+1] == '\'' { // doubled quote
-					b.WriteByte(s[i+1])
 					i++
 					continue
 				}
@@ -109,10 +263,8 @@ func SplitStatements(s string) []string {
 			continue
 		}
 		if inD {
-			b.WriteByte(c)
 			if c == '"' {
 				if i+1 < len(s) && s[i+1] == '"' {
-					b.WriteByte(s[i+1])
 					i++
 					continue
 				}
@@ -121,10 +273,8 @@ func SplitStatements(s string) []string {
 			continue
 		}
 		if inB {
-			b.WriteByte(c)
 			if c == '`' {
 				if i+1 < len(s) && s[i+1] == '`' {
-					b.WriteByte(s[i+1])
 					i++
 					continue
 				}
@@ -132,32 +282,66 @@ func SplitStatements(s string) []string {
 			}
 			continue
 		}
+		if inSquare {
+			if c == ']' {
+				if i+1 < len(s) && s[i+1] == ']' {
+					i++
+					continue
+				}
+				inSquare = false
+			}
+			continue
+		}
 
 		// Top-level
 		if hasPrefixAt(i, "--") {
 			inLineComment = true
+			commentStart = i
 			i++
 			continue
 		}
 		if hasPrefixAt(i, "/*") {
 			inBlockComment = 1
+			openedAt = i
+			commentStart = i
+			// MySQL runs the contents of /*! ... */ (and MariaDB /*M! ... */)
+			// executable comments and reads optimizer hints from /*+ ... */,
+			// so those are kept verbatim.
+			keepComment = flavor == FlavorMysql &&
+				(hasPrefixAt(i, "/*!") || hasPrefixAt(i, "/*M!") || hasPrefixAt(i, "/*+"))
 			i++
 			continue
 		}
 
-		if c == '$' {
-			j := i + 1
-			for j < len(s) {
-				cj := s[j]
-				if (cj >= 'a' && cj <= 'z') || (cj >= 'A' && cj <= 'Z') || (cj >= '0' && cj <= '9') || cj == '_' {
-					j++
-					continue
+		if tsql {
+			if end, count, ok := goSeparatorAt(s, i); ok {
+				stmt := current(i)
+				for n := 1; n < count && stmt != ""; n++ {
+					out = append(out, stmt)
 				}
-				break
+				flush(i, end)
+				i = end - 1
+				continue
+			}
+			if c == '[' {
+				inSquare = true
+				openedAt = i
+				continue
+			}
+		}
+
+		// A dollar quote opens with $$ or $tag$ where the tag follows identifier
+		// rules and cannot start with a digit: $1 is a positional parameter, and
+		// a $ right after an identifier or number character (a$b$, 1$x$)
+		// belongs to that token rather than opening a quote.
+		if c == '$' && !tsql && (i == 0 || !isDollarIdentByte(s[i-1])) {
+			j := i + 1
+			for j < len(s) && isDollarIdentByte(s[j]) && s[j] != '$' && (j > i+1 || s[j] < '0' || s[j] > '9') {
+				j++
 			}
 			if j < len(s) && s[j] == '$' { // $tag$ or $$
 				dollarTag = s[i : j+1]
-				b.WriteString(dollarTag)
+				openedAt = i
 				i = j
 				continue
 			}
@@ -165,28 +349,201 @@ func SplitStatements(s string) []string {
 
 		if c == '\'' {
 			inS = true
-			b.WriteByte(c)
+			escapes = backslashEscapes(s, i, flavor)
+			openedAt = i
 			continue
 		}
 		if c == '"' {
 			inD = true
-			b.WriteByte(c)
+			openedAt = i
 			continue
 		}
-		if c == '`' {
+		if c == '`' && !tsql {
 			inB = true
-			b.WriteByte(c)
+			openedAt = i
 			continue
 		}
 
-		if c == ';' {
-			flush()
+		if isWordByte(c) && !tsql && (i == 0 || !isDollarIdentByte(s[i-1])) {
+			j := i
+			for j < len(s) && isWordByte(s[j]) {
+				j++
+			}
+			word := s[i:j]
+			if i > 0 && s[i-1] == '.' {
+				word = "" // qualified name like NEW.end, never a keyword
+			}
+			switch {
+			case firstWord:
+				firstWord, inCreate = false, strings.EqualFold(word, "CREATE")
+			case inCreate:
+				if prev := prevByte(s, i); prev == '=' || prev == '@' || isCreateModifier(word) {
+					break // DEFINER = user@host, OR REPLACE, TEMPORARY
+				}
+				inCreate = false
+				inRoutine = strings.EqualFold(word, "TRIGGER") || strings.EqualFold(word, "PROCEDURE") ||
+					strings.EqualFold(word, "FUNCTION") || strings.EqualFold(word, "EVENT")
+			case !inRoutine:
+			case strings.EqualFold(word, "BEGIN"):
+				if blockDepth == 0 {
+					blockOpenedAt = i
+				}
+				blockDepth++
+			case strings.EqualFold(word, "CASE") && blockDepth > 0:
+				blockDepth++
+			case strings.EqualFold(word, "END") && blockDepth > 0:
+				// END IF / END LOOP / ... close MySQL compound statements whose
+				// openers are not tracked (IF is also a function). END CASE
+				// closes a tracked CASE; its CASE opens nothing.
+				switch next, end := nextWord(s, j); {
+				case strings.EqualFold(next, "IF"), strings.EqualFold(next, "LOOP"),
+					strings.EqualFold(next, "WHILE"), strings.EqualFold(next, "REPEAT"):
+					j = end
+				case strings.EqualFold(next, "CASE"):
+					blockDepth--
+					j = end
+				default:
+					blockDepth--
+				}
+			}
+			i = j - 1
 			continue
 		}
 
-		b.WriteByte(c)
+		if c == ';' && !tsql && blockDepth == 0 {
+			flush(i, i+1)
+		}
 	}
 
-	flush()
-	return out
+	if inLineComment || (inBlockComment > 0 && !keepComment) {
+		drop(commentStart, len(s))
+	}
+	unclosedBlock := blockDepth > 0
+	complete, rest = len(out), stmtStart
+	flush(len(s), len(s))
+
+	var unterminated string
+	switch {
+	case inS:
+		unterminated = "single-quoted string"
+	case inD:
+		unterminated = "double-quoted identifier"
+	case inB:
+		unterminated = "backtick-quoted identifier"
+	case inSquare:
+		unterminated = "bracketed identifier"
+	case inBlockComment > 0:
+		unterminated = "block comment"
+	case dollarTag != "":
+		unterminated = "dollar-quoted block " + dollarTag
+	case unclosedBlock:
+		unterminated = "BEGIN ... END block"
+		openedAt = blockOpenedAt
+	}
+	if unterminated != "" {
+		line := strings.Count(s[:openedAt], "\n") + 1
+		return out, complete, rest, &SplitError{Construct: unterminated, Statement: complete + 1, Line: line}
+	}
+	return out, complete, rest, nil
+}
+
+// goSeparatorAt reports whether a T-SQL batch separator line ("GO" or "GO n",
+// case-insensitive, alone on its line) starts at offset i. It returns the
+// offset just past the separator line and the repeat count.
+func goSeparatorAt(s string, i int) (end, count int, ok bool) {
+	// Only whitespace may precede GO on its line.
+	for j := i - 1; j >= 0 && s[j] != '\n'; j-- {
+		if s[j] != ' ' && s[j] != '\t' && s[j] != '\r' {
+			return 0, 0, false
+		}
+	}
+	if i+2 > len(s) || !strings.EqualFold(s[i:i+2], "GO") {
+		return 0, 0, false
+	}
+
+	j := i + 2
+	for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+		j++
+	}
+	count = 1
+	if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+		if j == i+2 { // "GO5" is an identifier, not a separator
+			return 0, 0, false
+		}
+		count = 0
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			count = count*10 + int(s[j]-'0')
+			j++
+		}
+		for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+			j++
+		}
+	}
+	if j < len(s) && s[j] == '\r' {
+		j++
+	}
+	if j < len(s) && s[j] != '\n' {
+		return 0, 0, false
+	}
+	return j, count, true
+}
+
+// isDollarIdentByte reports whether c can appear inside a Postgres identifier,
+// which may contain $ and non-ASCII letters.
+func isDollarIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// backslashEscapes reports whether backslashes escape the next byte in the
+// string literal opened by the quote at i: always on MySQL and with the
+// generic flavor, only in E'...' strings on Postgres, and never on SQLite or
+// SQL Server, where 'C:\' ends at its second quote.
+func backslashEscapes(s string, i int, flavor Flavor) bool {
+	switch flavor {
+	case FlavorMysql, FlavorGeneric:
+		return true
+	case FlavorPostgres:
+		return i > 0 && (s[i-1] == 'E' || s[i-1] == 'e') && (i == 1 || !isDollarIdentByte(s[i-2]))
+	default:
+		return false
+	}
+}
+
+// prevByte returns the last byte before offset i that is not whitespace, or 0.
+func prevByte(s string, i int) byte {
+	for i--; i >= 0; i-- {
+		if c := s[i]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c
+		}
+	}
+	return 0
+}
+
+// isCreateModifier reports whether word may come between CREATE and the type
+// of the created object.
+func isCreateModifier(word string) bool {
+	for _, m := range []string{"OR", "REPLACE", "DEFINER", "TEMP", "TEMPORARY"} {
+		if strings.EqualFold(word, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextWord returns the word following offset i after whitespace, or "" when
+// something else comes first, and the offset just past it.
+func nextWord(s string, i int) (word string, end int) {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\r' || s[i] == '\n') {
+		i++
+	}
+	j := i
+	for j < len(s) && isWordByte(s[j]) {
+		j++
+	}
+	return s[i:j], j
 }
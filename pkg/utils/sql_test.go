@@ -1,7 +1,9 @@
 package utils
 
 import (
+    "io"
     "reflect"
+    "strings"
     "testing"
 )
 
@@ -133,3 +135,427 @@ SELECT 1; /* block; comment */ SELECT 2; /* nested /* inner */ still */ SELECT 3
         }
     })
 }
+
+func TestSplitStatementsStrict(t *testing.T) {
+    t.Run("well formed", func(t *testing.T) {
+        in := "SELECT 'a;b'; /* c */ SELECT $$ d; $$;"
+        got, err := SplitStatementsStrict(in)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        want := SplitStatements(in)
+        if !reflect.DeepEqual(got, want) {
+            t.Fatalf("got %+v, want %+v", got, want)
+        }
+    })
+
+    t.Run("unterminated", func(t *testing.T) {
+        cases := []struct {
+            name string
+            in   string
+            want string
+        }{
+            {
+                name: "single quote",
+                in:   "SELECT 1;\nINSERT INTO t VALUES ('a);",
+                want: "unterminated single-quoted string starting at line 2",
+            },
+            {
+                name: "double quote",
+                in:   "CREATE TABLE \"t (id INT);",
+                want: "unterminated double-quoted identifier starting at line 1",
+            },
+            {
+                name: "backtick",
+                in:   "SELECT 1;\n\nCREATE TABLE `t (id INT);",
+                want: "unterminated backtick-quoted identifier starting at line 3",
+            },
+            {
+                name: "block comment",
+                in:   "SELECT 1; /* /* */ SELECT 2;",
+                want: "unterminated block comment starting at line 1",
+            },
+            {
+                name: "dollar quote",
+                in:   "DO $body$ BEGIN NULL; END $$;",
+                want: "unterminated dollar-quoted block $body$ starting at line 1",
+            },
+        }
+        for _, tc := range cases {
+            t.Run(tc.name, func(t *testing.T) {
+                _, err := SplitStatementsStrict(tc.in)
+                if err == nil || err.Error() != tc.want {
+                    t.Fatalf("%s: got error %v, want %q", tc.name, err, tc.want)
+                }
+            })
+        }
+    })
+}
+
+func TestSplitStatementsTSQL(t *testing.T) {
+    cases := []struct {
+        name string
+        in   string
+        want []string
+    }{
+        {
+            name: "go batches keep semicolons",
+            in:   "CREATE TABLE t (id INT);\nINSERT INTO t VALUES (1);\nGO\nSELECT 1;\ngo\n",
+            want: []string{
+                "CREATE TABLE t (id INT);\nINSERT INTO t VALUES (1);",
+                "SELECT 1;",
+            },
+        },
+        {
+            name: "begin try",
+            in:   "BEGIN TRY\n    INSERT INTO t VALUES (1);\nEND TRY\nBEGIN CATCH\n    THROW;\nEND CATCH\nGO",
+            want: []string{
+                "BEGIN TRY\n    INSERT INTO t VALUES (1);\nEND TRY\nBEGIN CATCH\n    THROW;\nEND CATCH",
+            },
+        },
+        {
+            name: "go must be alone on its line",
+            in:   "SELECT 'GO' AS GO\n  GO  \r\nSELECT 1 GO\nSELECT 2\nGOTO label\nGO",
+            want: []string{
+                "SELECT 'GO' AS GO",
+                "SELECT 1 GO\nSELECT 2\nGOTO label",
+            },
+        },
+        {
+            name: "go inside comments and strings",
+            in:   "SELECT 1 /*\nGO\n*/\nSELECT '\nGO\n'\n-- GO\nGO",
+            want: []string{
+                "SELECT 1 \nSELECT '\nGO\n'",
+            },
+        },
+        {
+            name: "go with count",
+            in:   "INSERT INTO t DEFAULT VALUES\nGO 3\n",
+            want: []string{
+                "INSERT INTO t DEFAULT VALUES",
+                "INSERT INTO t DEFAULT VALUES",
+                "INSERT INTO t DEFAULT VALUES",
+            },
+        },
+        {
+            name: "brackets and backslashes",
+            in:   "CREATE TABLE [a\nGO\n]] b] (s NVARCHAR(10) DEFAULT 'c:\\')\nGO",
+            want: []string{
+                "CREATE TABLE [a\nGO\n]] b] (s NVARCHAR(10) DEFAULT 'c:\\')",
+            },
+        },
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := SplitStatementsStrictFlavor(tc.in, FlavorTSQL)
+            if err != nil {
+                t.Fatalf("%s: unexpected error: %v", tc.name, err)
+            }
+            if !reflect.DeepEqual(got, tc.want) {
+                t.Fatalf("%s: got %#v, want %#v", tc.name, got, tc.want)
+            }
+        })
+    }
+
+    t.Run("unterminated bracket", func(t *testing.T) {
+        _, err := SplitStatementsStrictFlavor("SELECT [a", FlavorTSQL)
+        if err == nil || err.Error() != "unterminated bracketed identifier starting at line 1" {
+            t.Fatalf("got error %v", err)
+        }
+    })
+}
+
+func TestSplitStatementsDollarQuoteBoundaries(t *testing.T) {
+    cases := []struct {
+        name string
+        in   string
+        want []string
+    }{
+        {
+            name: "positional parameters",
+            in:   "UPDATE t SET a = $1 WHERE b = $2; SELECT $1, $$x;$$",
+            want: []string{"UPDATE t SET a = $1 WHERE b = $2", "SELECT $1, $$x;$$"},
+        },
+        {
+            name: "adjacent positional parameters",
+            in:   "SELECT $1$2; SELECT 2",
+            want: []string{"SELECT $1$2", "SELECT 2"},
+        },
+        {
+            name: "tag may contain digits but not start with one",
+            in:   "SELECT $a1$ ; $a1$; SELECT $1a$; SELECT 3",
+            want: []string{"SELECT $a1$ ; $a1$", "SELECT $1a$", "SELECT 3"},
+        },
+        {
+            name: "dollar inside identifier",
+            in:   "SELECT a$b$ FROM t; SELECT c$$ FROM u; SELECT 3",
+            want: []string{"SELECT a$b$ FROM t", "SELECT c$$ FROM u", "SELECT 3"},
+        },
+        {
+            name: "dollar after number",
+            in:   "SELECT 1$x$; SELECT 2",
+            want: []string{"SELECT 1$x$", "SELECT 2"},
+        },
+        {
+            name: "dollar quote after operator",
+            in:   "SELECT 1+$q$;$q$; SELECT 2",
+            want: []string{"SELECT 1+$q$;$q$", "SELECT 2"},
+        },
+        {
+            name: "parameterized data migration with function body",
+            in:   "CREATE FUNCTION f(int) RETURNS int AS $$ SELECT $1 + 1; $$ LANGUAGE sql; SELECT f($1)",
+            want: []string{"CREATE FUNCTION f(int) RETURNS int AS $$ SELECT $1 + 1; $$ LANGUAGE sql", "SELECT f($1)"},
+        },
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := SplitStatementsStrictFlavor(tc.in, FlavorPostgres)
+            if err != nil {
+                t.Fatalf("%s: unexpected error: %v", tc.name, err)
+            }
+            if !reflect.DeepEqual(got, tc.want) {
+                t.Fatalf("%s: got %#v, want %#v", tc.name, got, tc.want)
+            }
+        })
+    }
+}
+
+func FuzzSplitStatements(f *testing.F) {
+    for _, seed := range []string{
+        "SELECT $1; SELECT $$;$$",
+        "SELECT a$b$; $tag$ ; $tag$",
+        "SELECT 1$x$; SELECT $1$2",
+        "CREATE FUNCTION f() AS $f$ BEGIN; END $f$; SELECT '$$'",
+    } {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, in string) {
+        for _, stmt := range SplitStatementsFlavor(in, FlavorPostgres) {
+            if stmt == "" || stmt != strings.TrimSpace(stmt) {
+                t.Fatalf("statement %q is empty or not trimmed", stmt)
+            }
+        }
+    })
+}
+
+func TestSplitStatementsBackslashes(t *testing.T) {
+    literal := []string{"INSERT INTO t VALUES ('C:\\')", "SELECT 1"}
+    batch := []string{"INSERT INTO t VALUES ('C:\\'); SELECT 1;"}
+    cases := []struct {
+        name   string
+        flavor Flavor
+        in     string
+        want   []string
+    }{
+        {"generic", FlavorGeneric, "INSERT INTO t VALUES ('C:\\'); SELECT 1;'", []string{"INSERT INTO t VALUES ('C:\\'); SELECT 1;'"}},
+        {"mysql", FlavorMysql, "INSERT INTO t VALUES ('C:\\'); SELECT 1;'", []string{"INSERT INTO t VALUES ('C:\\'); SELECT 1;'"}},
+        {"sqlite", FlavorSqlite, "INSERT INTO t VALUES ('C:\\'); SELECT 1;", literal},
+        {"postgres", FlavorPostgres, "INSERT INTO t VALUES ('C:\\'); SELECT 1;", literal},
+        {"postgres escape string", FlavorPostgres, "INSERT INTO t VALUES (E'C:\\'); SELECT 1;'", []string{"INSERT INTO t VALUES (E'C:\\'); SELECT 1;'"}},
+        {"postgres identifier ending in e", FlavorPostgres, "INSERT INTO t VALUES (date'C:\\'); SELECT 1;", []string{"INSERT INTO t VALUES (date'C:\\')", "SELECT 1"}},
+        {"tsql", FlavorTSQL, "INSERT INTO t VALUES ('C:\\'); SELECT 1;", batch},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := SplitStatementsStrictFlavor(tc.in, tc.flavor)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !reflect.DeepEqual(got, tc.want) {
+                t.Fatalf("got %#v, want %#v", got, tc.want)
+            }
+        })
+    }
+}
+
+func TestSplitStatementsBeginEndBlocks(t *testing.T) {
+    cases := []struct {
+        name   string
+        flavor Flavor
+        in     string
+        want   []string
+    }{
+        {
+            name:   "sqlite trigger",
+            flavor: FlavorSqlite,
+            in:     "CREATE TABLE t (id INT);\nCREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  INSERT INTO log VALUES (NEW.id);\n  UPDATE c SET n = n + 1;\nEND;\nSELECT 1;",
+            want: []string{
+                "CREATE TABLE t (id INT)",
+                "CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  INSERT INTO log VALUES (NEW.id);\n  UPDATE c SET n = n + 1;\nEND",
+                "SELECT 1",
+            },
+        },
+        {
+            name:   "case expression inside trigger",
+            flavor: FlavorSqlite,
+            in:     "create trigger tr before update on t begin select case when new.end < 0 then raise(abort, 'neg') end; end; select 2",
+            want: []string{
+                "create trigger tr before update on t begin select case when new.end < 0 then raise(abort, 'neg') end; end",
+                "select 2",
+            },
+        },
+        {
+            name:   "mysql procedure with nested blocks",
+            flavor: FlavorMysql,
+            in:     "CREATE PROCEDURE p(IN x INT)\nBEGIN\n  IF x > 0 THEN\n    BEGIN\n      SELECT IF(x > 1, 'a', 'b');\n    END;\n  END IF;\n  WHILE x > 0 DO SET x = x - 1; END WHILE;\nEND;\nCALL p(1);",
+            want: []string{
+                "CREATE PROCEDURE p(IN x INT)\nBEGIN\n  IF x > 0 THEN\n    BEGIN\n      SELECT IF(x > 1, 'a', 'b');\n    END;\n  END IF;\n  WHILE x > 0 DO SET x = x - 1; END WHILE;\nEND",
+                "CALL p(1)",
+            },
+        },
+        {
+            name:   "mysql case statement inside procedure",
+            flavor: FlavorMysql,
+            in:     "CREATE PROCEDURE p() BEGIN CASE x WHEN 1 THEN SELECT 1; ELSE SELECT 2; END CASE; SELECT CASE WHEN x THEN 1 END; END; SELECT 3;",
+            want: []string{
+                "CREATE PROCEDURE p() BEGIN CASE x WHEN 1 THEN SELECT 1; ELSE SELECT 2; END CASE; SELECT CASE WHEN x THEN 1 END; END",
+                "SELECT 3",
+            },
+        },
+        {
+            name:   "postgres begin atomic",
+            flavor: FlavorPostgres,
+            in:     "CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT 2; END; SELECT f()",
+            want: []string{
+                "CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT 2; END",
+                "SELECT f()",
+            },
+        },
+        {
+            name:   "begin outside of routines",
+            flavor: FlavorSqlite,
+            in:     "BEGIN; CREATE TABLE t (begin INT, end INT); COMMIT",
+            want:   []string{"BEGIN", "CREATE TABLE t (begin INT, end INT)", "COMMIT"},
+        },
+        {
+            name:   "routine keywords as column names",
+            flavor: FlavorMysql,
+            in:     "CREATE TABLE events (trigger TEXT, begin TEXT); SELECT 1;",
+            want:   []string{"CREATE TABLE events (trigger TEXT, begin TEXT)", "SELECT 1"},
+        },
+        {
+            name:   "mysql definer and or replace",
+            flavor: FlavorMysql,
+            in:     "CREATE OR REPLACE DEFINER = `root`@`%` TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END; CREATE DEFINER=root@localhost PROCEDURE p() BEGIN SELECT 1; END; SELECT 2",
+            want: []string{
+                "CREATE OR REPLACE DEFINER = `root`@`%` TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END",
+                "CREATE DEFINER=root@localhost PROCEDURE p() BEGIN SELECT 1; END",
+                "SELECT 2",
+            },
+        },
+        {
+            name:   "sqlite temporary trigger",
+            flavor: FlavorSqlite,
+            in:     "CREATE TEMP TRIGGER tr AFTER INSERT ON t BEGIN DELETE FROM u; END; SELECT 1",
+            want:   []string{"CREATE TEMP TRIGGER tr AFTER INSERT ON t BEGIN DELETE FROM u; END", "SELECT 1"},
+        },
+        {
+            name:   "keywords in strings and comments",
+            flavor: FlavorSqlite,
+            in:     "CREATE TRIGGER tr AFTER DELETE ON t BEGIN SELECT 'end;'; -- end;\n /* end; */ DELETE FROM u; END; SELECT 3",
+            want: []string{
+                "CREATE TRIGGER tr AFTER DELETE ON t BEGIN SELECT 'end;';   DELETE FROM u; END",
+                "SELECT 3",
+            },
+        },
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := SplitStatementsStrictFlavor(tc.in, tc.flavor)
+            if err != nil {
+                t.Fatalf("%s: unexpected error: %v", tc.name, err)
+            }
+            if !reflect.DeepEqual(got, tc.want) {
+                t.Fatalf("%s: got %#v, want %#v", tc.name, got, tc.want)
+            }
+        })
+    }
+
+    t.Run("unterminated block", func(t *testing.T) {
+        _, err := SplitStatementsStrictFlavor("SELECT 1;\nCREATE TRIGGER tr AFTER INSERT ON t BEGIN SELECT 1;", FlavorSqlite)
+        if err == nil || err.Error() != "unterminated BEGIN ... END block starting at line 2" {
+            t.Fatalf("got error %v", err)
+        }
+    })
+}
+
+func TestSplitStatementsMysqlExecutableComments(t *testing.T) {
+    in := "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+        "/* plain; comment */ CREATE TABLE t (id INT) /*!50100 ENGINE=InnoDB */;\n" +
+        "SELECT /*+ MAX_EXECUTION_TIME(1000) */ 1;\n" +
+        "/*M!100100 SET sql_mode='a;b' */;"
+
+    got, err := SplitStatementsStrictFlavor(in, FlavorMysql)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    want := []string{
+        "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */",
+        "CREATE TABLE t (id INT) /*!50100 ENGINE=InnoDB */",
+        "SELECT /*+ MAX_EXECUTION_TIME(1000) */ 1",
+        "/*M!100100 SET sql_mode='a;b' */",
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("got %#v, want %#v", got, want)
+    }
+
+    // Other flavors treat them as regular comments.
+    got = SplitStatementsFlavor(in, FlavorPostgres)
+    want = []string{"CREATE TABLE t (id INT)", "SELECT  1"}
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("postgres: got %#v, want %#v", got, want)
+    }
+}
+
+func TestStatementReader(t *testing.T) {
+    cases := []struct {
+        in     string
+        flavor Flavor
+    }{
+        {"CREATE TABLE a (id INT);\nINSERT INTO a VALUES (1); INSERT INTO a VALUES (2);\nSELECT 1", FlavorGeneric},
+        {"INSERT INTO t VALUES ('multi;\nline;'); -- trailing; comment\n/* block;\n comment */ SELECT 2;\n", FlavorGeneric},
+        {"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\nSELECT f();", FlavorPostgres},
+        {"CREATE TRIGGER tr AFTER INSERT ON a\nBEGIN\n  UPDATE a SET id = id;\nEND;\nSELECT 3;", FlavorSqlite},
+        {"/*!40101 SET NAMES utf8 */;\nSELECT 4;", FlavorMysql},
+        {"SELECT 1\nGO 2\nSELECT 2\ngo\n", FlavorTSQL},
+        {"SELECT 'unterminated;\n", FlavorGeneric},
+        {"", FlavorGeneric},
+    }
+    for _, tc := range cases {
+        sr := NewStatementReader(strings.NewReader(tc.in), tc.flavor)
+        var got []string
+        for {
+            stmt, err := sr.Next()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                t.Fatalf("%q: unexpected error: %v", tc.in, err)
+            }
+            got = append(got, stmt)
+        }
+        if want := SplitStatementsFlavor(tc.in, tc.flavor); !reflect.DeepEqual(got, want) {
+            t.Fatalf("%q: got %#v, want %#v", tc.in, got, want)
+        }
+    }
+}
+
+func BenchmarkSplitStatements(b *testing.B) {
+    plain := strings.Repeat("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'x;y');\nINSERT INTO items (name) VALUES ('a'), ('b');\n", 50)
+    commented := strings.Repeat("-- create items\nCREATE TABLE items (id INTEGER /* pk */ PRIMARY KEY, name TEXT NOT NULL DEFAULT 'x;y');\n", 50)
+    for _, bc := range []struct {
+        name string
+        in   string
+    }{
+        {"plain", plain},
+        {"comments", commented},
+    } {
+        b.Run(bc.name, func(b *testing.B) {
+            b.ReportAllocs()
+            b.SetBytes(int64(len(bc.in)))
+            for i := 0; i < b.N; i++ {
+                SplitStatementsFlavor(bc.in, FlavorPostgres)
+            }
+        })
+    }
+}
@@ -7,3 +7,7 @@ import (
 func QuoteIdentBacktick(s string) string {
 	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
 }
+
+func QuoteIdentDouble(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,191 @@
+package migrations
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PlaceholderStyle enumerates bind parameter syntaxes.
+type PlaceholderStyle int32
+
+const (
+	// PlaceholderQuestion is "?" (SQLite, MySQL).
+	PlaceholderQuestion PlaceholderStyle = iota
+	// PlaceholderDollar is "$1", "$2", ... (Postgres).
+	PlaceholderDollar
+	// PlaceholderAtP is "@p1", "@p2", ... (SQL Server).
+	PlaceholderAtP
+	// PlaceholderColon is ":1", ":2", ... (Oracle).
+	PlaceholderColon
+)
+
+// RewritePlaceholders converts bind parameters in query from one style to
+// another, leaving quoted strings/identifiers, comments and Postgres
+// dollar-quoted blocks untouched. It lets dialect-portable SQL be written once
+// with "?" placeholders:
+//
+//	RewritePlaceholders("UPDATE t SET a = ? WHERE id = ?", PlaceholderQuestion, PlaceholderDollar)
+//	// UPDATE t SET a = $1 WHERE id = $2
+//
+// Numbered placeholders are renumbered by position when converting to another
+// numbered style; converting to "?" drops the numbers, so arguments must then
+// appear in positional order. Postgres JSON operators (?, ?|, ?&) are
+// indistinguishable from "?" placeholders and must not be used with
+// PlaceholderQuestion as the source style. String literals follow standard
+// SQL: backslashes only escape in Postgres E'...' strings, so MySQL literals
+// with backslash-escaped quotes are not supported.
+func RewritePlaceholders(query string, from, to PlaceholderStyle) string {
+	return rewritePlaceholders(query, from, to, false)
+}
+
+// rewritePlaceholders works like RewritePlaceholders; mysql makes backslashes
+// escape in every '...' string.
+func rewritePlaceholders(query string, from, to PlaceholderStyle, mysql bool) string {
+	if from == to {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0 // placeholders seen so far
+
+	for i := 0; i < len(query); {
+		if end := skipQuotedOrComment(query, i, mysql); end > i {
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+		if end := placeholderEnd(query, i, from); end > i {
+			n++
+			b.WriteString(formatPlaceholder(to, n))
+			i = end
+			continue
+		}
+		b.WriteByte(query[i])
+		i++
+	}
+	return b.String()
+}
+
+func formatPlaceholder(style PlaceholderStyle, n int) string {
+	switch style {
+	case PlaceholderDollar:
+		return "$" + strconv.Itoa(n)
+	case PlaceholderAtP:
+		return "@p" + strconv.Itoa(n)
+	case PlaceholderColon:
+		return ":" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+// placeholderEnd returns the offset just past a placeholder of the given style
+// starting at i, or i when there is none.
+func placeholderEnd(s string, i int, style PlaceholderStyle) int {
+	digitsFrom := func(j int) int {
+		k := j
+		for k < len(s) && s[k] >= '0' && s[k] <= '9' {
+			k++
+		}
+		if k == j {
+			return i
+		}
+		return k
+	}
+	if i > 0 && isIdentByte(s[i-1]) && style != PlaceholderQuestion {
+		return i // e.g. a$1 is an identifier
+	}
+
+	switch style {
+	case PlaceholderQuestion:
+		if s[i] == '?' {
+			return i + 1
+		}
+	case PlaceholderDollar:
+		if s[i] == '$' {
+			if end := digitsFrom(i + 1); end > i && (end == len(s) || s[end] != '$') {
+				return end
+			}
+		}
+	case PlaceholderAtP:
+		if s[i] == '@' && i+1 < len(s) && (s[i+1] == 'p' || s[i+1] == 'P') {
+			return digitsFrom(i + 2)
+		}
+	case PlaceholderColon:
+		if s[i] == ':' && (i == 0 || s[i-1] != ':') {
+			return digitsFrom(i + 1)
+		}
+	}
+	return i
+}
+
+// skipQuotedOrComment returns the offset just past the quoted string,
+// identifier, comment or dollar-quoted block starting at i, or i when there is
+// none. Unterminated constructs extend to the end of s. Backslashes escape the
+// next byte in MySQL '...' strings and in Postgres E'...' strings only; other
+// databases treat them as ordinary characters, so 'C:\' ends at its second
+// quote.
+func skipQuotedOrComment(s string, i int, mysql bool) int {
+	switch c := s[i]; {
+	case c == '\'' || c == '"' || c == '`':
+		escapes := c == '\'' && (mysql || escapeString(s, i))
+		for j := i + 1; j < len(s); j++ {
+			if escapes && s[j] == '\\' {
+				j++
+				continue
+			}
+			if s[j] == c {
+				if j+1 < len(s) && s[j+1] == c { // doubled quote
+					j++
+					continue
+				}
+				return j + 1
+			}
+		}
+		return len(s)
+	case strings.HasPrefix(s[i:], "--"):
+		if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+			return i + end + 1
+		}
+		return len(s)
+	case strings.HasPrefix(s[i:], "/*"):
+		depth := 0
+		for j := i; j < len(s); j++ {
+			switch {
+			case strings.HasPrefix(s[j:], "/*"):
+				depth++
+				j++
+			case strings.HasPrefix(s[j:], "*/"):
+				depth--
+				j++
+				if depth == 0 {
+					return j + 1
+				}
+			}
+		}
+		return len(s)
+	case c == '$':
+		j := i + 1
+		for j < len(s) && isIdentByte(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '$' && (j == i+1 || s[i+1] < '0' || s[i+1] > '9') {
+			tag := s[i : j+1]
+			if end := strings.Index(s[j+1:], tag); end >= 0 {
+				return j + 1 + end + len(tag)
+			}
+			return len(s)
+		}
+	}
+	return i
+}
+
+// escapeString reports whether the quote at i opens a Postgres E'...' string.
+func escapeString(s string, i int) bool {
+	return i > 0 && (s[i-1] == 'E' || s[i-1] == 'e') && (i == 1 || !isIdentByte(s[i-2]))
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
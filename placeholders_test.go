@@ -0,0 +1,76 @@
+package migrations
+
+import "testing"
+
+func TestRewritePlaceholders(t *testing.T) {
+	cases := []struct {
+		name     string
+		in       string
+		from, to PlaceholderStyle
+		want     string
+	}{
+		{
+			name: "question to dollar",
+			in:   "UPDATE t SET a = ? WHERE id = ?",
+			from: PlaceholderQuestion, to: PlaceholderDollar,
+			want: "UPDATE t SET a = $1 WHERE id = $2",
+		},
+		{
+			name: "skips quotes and comments",
+			in:   "SELECT '?', \"?\", `?` -- ?\n, /* ? /* ? */ */ ?",
+			from: PlaceholderQuestion, to: PlaceholderDollar,
+			want: "SELECT '?', \"?\", `?` -- ?\n, /* ? /* ? */ */ $1",
+		},
+		{
+			name: "skips escaped and doubled quotes",
+			in:   "SELECT 'it''s $1', E'a\\' $1', $1",
+			from: PlaceholderDollar, to: PlaceholderQuestion,
+			want: "SELECT 'it''s $1', E'a\\' $1', ?",
+		},
+		{
+			name: "backslashes are ordinary characters when converting to question",
+			in:   "SELECT 'C:\\', $1",
+			from: PlaceholderDollar, to: PlaceholderQuestion,
+			want: "SELECT 'C:\\', ?",
+		},
+		{
+			name: "postgres backslashes only escape in E strings",
+			in:   "SELECT 'C:\\', ?, E'a\\' ?', ?",
+			from: PlaceholderQuestion, to: PlaceholderDollar,
+			want: "SELECT 'C:\\', $1, E'a\\' ?', $2",
+		},
+		{
+			name: "dollar to question",
+			in:   "INSERT INTO t VALUES ($1, $2, $body$ $3 $body$, a$4)",
+			from: PlaceholderDollar, to: PlaceholderQuestion,
+			want: "INSERT INTO t VALUES (?, ?, $body$ $3 $body$, a$4)",
+		},
+		{
+			name: "colon ignores casts",
+			in:   "SELECT :1::int, :2",
+			from: PlaceholderColon, to: PlaceholderDollar,
+			want: "SELECT $1::int, $2",
+		},
+		{
+			name: "same style is a no-op",
+			in:   "SELECT ?",
+			from: PlaceholderQuestion, to: PlaceholderQuestion,
+			want: "SELECT ?",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := RewritePlaceholders(tc.in, tc.from, tc.to)
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRewritePlaceholdersMysql(t *testing.T) {
+	got := rewritePlaceholders("SELECT 'a\\' $1', $1", PlaceholderDollar, PlaceholderQuestion, true)
+	if want := "SELECT 'a\\' $1', ?"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BuildPreview creates a new, uniquely named database, applies all migrations
+// to it and returns a DSN for connecting to it together with a cleanup function
+// that drops it again. It powers PR preview environments and schema-review
+// bots.
+//
+// For Postgres and MySQL, adminDSN must point at an existing database on the
+// target server with permission to CREATE and DROP databases; the returned DSN
+// is adminDSN with the database name replaced. Both URL
+// ("postgres://user@host/db?sslmode=disable") and key/value ("host=... dbname=db")
+// Postgres DSNs are supported. For SQLite, adminDSN is the directory to create
+// the database file in ("" means the OS temp directory) and the returned DSN is
+// the file path.
+//
+// The dialect and table name come from userOptions, as for Apply. Close every
+// connection to the preview database before calling cleanup.
+func BuildPreview(ctx context.Context, driverName, adminDSN string, migrations []string, userOptions ...Option) (dsn string, cleanup func() error, err error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, err := previewName()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var drop func(ctx context.Context) error
+	switch opts.Dialect {
+	case DialectSqlite:
+		dir := adminDSN
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		dsn = filepath.Join(dir, name+".db")
+		drop = func(context.Context) error {
+			var errs []error
+			for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+				if err := os.Remove(dsn + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		}
+	case DialectPostgres, DialectMysql:
+		dsn, err = replaceDatabaseInDSN(opts.Dialect, adminDSN, name)
+		if err != nil {
+			return "", nil, err
+		}
+		quoted := dialects[opts.Dialect].quoteIdent(name)
+		if err := execAdmin(ctx, driverName, adminDSN, "CREATE DATABASE "+quoted); err != nil {
+			return "", nil, fmt.Errorf("failed to create preview database %q: %w", name, err)
+		}
+		drop = func(ctx context.Context) error {
+			return execAdmin(ctx, driverName, adminDSN, "DROP DATABASE IF EXISTS "+quoted)
+		}
+	}
+
+	cleanup = func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop preview database %q: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := applyPreview(ctx, driverName, dsn, migrations, userOptions); err != nil {
+		if cerr := cleanup(); cerr != nil {
+			err = errors.Join(err, cerr)
+		}
+		return "", nil, err
+	}
+	return dsn, cleanup, nil
+}
+
+func applyPreview(ctx context.Context, driverName, dsn string, migrations []string, userOptions []Option) error {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open preview database: %w", err)
+	}
+	defer db.Close()
+
+	if err := Apply(ctx, db, migrations, userOptions...); err != nil {
+		return fmt.Errorf("failed to apply migrations to preview database: %w", err)
+	}
+	return nil
+}
+
+func execAdmin(ctx context.Context, driverName, adminDSN, stmt string) error {
+	db, err := sql.Open(driverName, adminDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, stmt)
+	return err
+}
+
+// previewName returns a unique, identifier-safe database name.
+func previewName() (string, error) {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", fmt.Errorf("failed to generate preview database name: %w", err)
+	}
+	return "preview_" + time.Now().UTC().Format("20060102150405") + "_" + hex.EncodeToString(suffix[:]), nil
+}
+
+// replaceDatabaseInDSN returns dsn pointing at database name instead.
+func replaceDatabaseInDSN(dialect Dialect, dsn, name string) (string, error) {
+	if dialect == DialectMysql {
+		// [user[:password]@][net[(addr)]]/dbname[?param1=value1&paramN=valueN]
+		base, params, hasParams := strings.Cut(dsn, "?")
+		slash := strings.LastIndex(base, "/")
+		if slash < 0 {
+			return "", fmt.Errorf("mysql dsn has no database part")
+		}
+		out := base[:slash+1] + name
+		if hasParams {
+			out += "?" + params
+		}
+		return out, nil
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid postgres dsn: %w", err)
+		}
+		u.Path = "/" + name
+		u.RawPath = ""
+		return u.String(), nil
+	}
+
+	// key=value form
+	if loc := pgDBNameRe.FindStringSubmatchIndex(dsn); loc != nil {
+		return dsn[:loc[2]] + "dbname=" + name + dsn[loc[1]:], nil
+	}
+	return strings.TrimSpace(dsn + " dbname=" + name), nil
+}
+
+// pgDBNameRe matches the dbname setting of a key=value Postgres DSN, whose value
+// may be single-quoted.
+var pgDBNameRe = regexp.MustCompile(`(?:^|\s)(dbname\s*=\s*(?:'(?:[^'\\]|\\.)*'|\S*))`)
@@ -0,0 +1,32 @@
+package migrations
+
+import "testing"
+
+func TestReplaceDatabaseInDSN(t *testing.T) {
+	cases := []struct {
+		dialect Dialect
+		in      string
+		want    string
+	}{
+		{DialectPostgres, "postgres://u:p@localhost:5432/app?sslmode=disable", "postgres://u:p@localhost:5432/preview?sslmode=disable"},
+		{DialectPostgres, "postgresql://localhost", "postgresql://localhost/preview"},
+		{DialectPostgres, "host=localhost dbname=app sslmode=disable", "host=localhost dbname=preview sslmode=disable"},
+		{DialectPostgres, "dbname='my app' host=localhost", "dbname=preview host=localhost"},
+		{DialectPostgres, "host=localhost", "host=localhost dbname=preview"},
+		{DialectMysql, "root:root@tcp(localhost:3306)/app?parseTime=true", "root:root@tcp(localhost:3306)/preview?parseTime=true"},
+		{DialectMysql, "root@/app", "root@/preview"},
+	}
+	for _, tc := range cases {
+		got, err := replaceDatabaseInDSN(tc.dialect, tc.in, "preview")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	if _, err := replaceDatabaseInDSN(DialectMysql, "root@tcp(localhost)", "preview"); err == nil {
+		t.Fatal("expected error for mysql dsn without database")
+	}
+}
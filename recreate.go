@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// Recreate replays the history of src into dst, a new, empty database: it
+// verifies that src has every migration applied, applies all of them to dst
+// and gives every bookkeeping row in dst the applied_at time recorded in src.
+// It makes spinning up a new region or environment with the same schema
+// history a single call.
+//
+// Both databases use the same options. dst must not have a bookkeeping table
+// yet. The timestamps are copied in the final Apply transaction, from a
+// WithOnFreshDatabase callback that runs after the one given in userOptions.
+func Recreate(ctx context.Context, src, dst *sql.DB, migrations []string, userOptions ...Option) error {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return err
+	}
+	d := dialects[opts.Dialect]
+
+	if err := Verify(ctx, src, migrations, userOptions...); err != nil {
+		return fmt.Errorf("source database is not up to date: %w", err)
+	}
+
+	var existingTables int
+	if err := dst.QueryRowContext(ctx, d.rebind(d.tableExists), opts.TableName).Scan(&existingTables); err != nil {
+		return fmt.Errorf("failed to check if migrations table %q exists: %w", opts.TableName, err)
+	}
+	if existingTables > 0 {
+		return fmt.Errorf("destination database already has a migrations table %q", opts.TableName)
+	}
+
+	history, err := readHistory(ctx, src, d, opts.TableName, len(migrations))
+	if err != nil {
+		return err
+	}
+	copyHistory := func(opts *Options) error {
+		prev := opts.OnFreshDatabase
+		opts.OnFreshDatabase = func(ctx context.Context, tx *sql.Tx) error {
+			if prev != nil {
+				if err := prev(ctx, tx); err != nil {
+					return err
+				}
+			}
+			update := d.rebind("UPDATE " + d.quoteIdent(opts.TableName) + " SET applied_at = ? WHERE version = ?")
+			for _, h := range history {
+				if _, err := tx.ExecContext(ctx, update, h.appliedAt, h.version); err != nil {
+					return fmt.Errorf("failed to copy applied_at of migration #%d: %w", h.version, err)
+				}
+			}
+			return nil
+		}
+		return nil
+	}
+	return Apply(ctx, dst, migrations, append(userOptions[:len(userOptions):len(userOptions)], copyHistory)...)
+}
+
+type historyRow struct {
+	version   int
+	appliedAt any // passed back to the driver as read
+}
+
+// readHistory returns the bookkeeping rows of versions 1 to last.
+func readHistory(ctx context.Context, db *sql.DB, d dialect, table string, last int) ([]historyRow, error) {
+	query := d.rebind("SELECT version, applied_at FROM " + d.quoteIdent(table) + " WHERE version BETWEEN 1 AND ? ORDER BY version")
+	rows, err := db.QueryContext(ctx, query, last)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var history []historyRow
+	for rows.Next() {
+		var h historyRow
+		if err := rows.Scan(&h.version, &h.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		if t, ok := h.appliedAt.(time.Time); ok && d.flavor == utils.FlavorSqlite {
+			// Some SQLite drivers would write the time back in a format the
+			// SQLite date functions cannot read; use CURRENT_TIMESTAMP's.
+			h.appliedAt = t.UTC().Format("2006-01-02 15:04:05.999999")
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	return history, nil
+}
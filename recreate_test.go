@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestRecreate(t *testing.T) {
+	src, srcRec := migrationsmock.DB()
+	defer src.Close()
+	appliedAt := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	srcRec.Return("information_schema.tables", []string{"count"}, []any{1})
+	srcRec.Return("MAX(version)", []string{"max"}, []any{1})
+	srcRec.Return("SELECT version, applied_at", []string{"version", "applied_at"}, []any{1, appliedAt})
+
+	dst, dstRec := migrationsmock.DB()
+	defer dst.Close()
+
+	if err := Recreate(context.Background(), src, dst, []string{"SELECT 1"}, WithDialect(DialectPostgres)); err != nil {
+		t.Fatalf("recreate: %v", err)
+	}
+
+	stmts := dstRec.Statements()
+	update := -1
+	for i, s := range stmts {
+		if s.Query == `UPDATE "migrations" SET applied_at = $1 WHERE version = $2` {
+			update = i
+			if len(s.Args) != 2 || s.Args[0] != appliedAt || s.Args[1] != int64(1) {
+				t.Fatalf("unexpected arguments %v", s.Args)
+			}
+		}
+	}
+	if update < 0 || stmts[len(stmts)-1].Query != migrationsmock.Commit || countQuery(dstRec, migrationsmock.Commit) != 1 {
+		t.Fatalf("expected the update inside the apply transaction, got %q", dstRec.Queries())
+	}
+}
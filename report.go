@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report summarizes a successful Apply run.
+type Report struct {
+	// Applied lists the versions executed by this run, in order.
+	Applied []int
+	// Skipped is the number of migrations that were already applied.
+	Skipped int
+	// Duration is the wall-clock time of the whole run, including locking and
+	// bookkeeping.
+	Duration time.Duration
+	// Timings holds the execution time of every applied migration, in order.
+	Timings []MigrationTiming
+}
+
+// MigrationTiming is the execution time of a single applied migration.
+type MigrationTiming struct {
+	Version  int
+	Duration time.Duration
+}
+
+// String returns a one-line summary suitable for logs, e.g.
+// "applied 2 migrations (3, 4), skipped 2, took 15ms".
+func (r Report) String() string {
+	if len(r.Applied) == 0 {
+		return fmt.Sprintf("no pending migrations, skipped %d, took %s", r.Skipped, r.Duration)
+	}
+
+	versions := make([]string, len(r.Applied))
+	for i, v := range r.Applied {
+		versions[i] = strconv.Itoa(v)
+	}
+	noun := "migrations"
+	if len(r.Applied) == 1 {
+		noun = "migration"
+	}
+	return fmt.Sprintf("applied %d %s (%s), skipped %d, took %s",
+		len(r.Applied), noun, strings.Join(versions, ", "), r.Skipped, r.Duration)
+}
+
+// reportJSON is the stable wire format of Report. Durations are integer
+// nanoseconds. proto/migrations/v1/report.proto mirrors it field by field.
+type reportJSON struct {
+	Applied    []int        `json:"applied"`
+	Skipped    int          `json:"skipped"`
+	DurationNs int64        `json:"duration_ns"`
+	Timings    []timingJSON `json:"timings"`
+}
+
+type timingJSON struct {
+	Version    int   `json:"version"`
+	DurationNs int64 `json:"duration_ns"`
+}
+
+// MarshalJSON encodes the report with stable snake_case field names, e.g.
+//
+//	{"applied":[3,4],"skipped":2,"duration_ns":15000000,"timings":[{"version":3,"duration_ns":9000000},...]}
+//
+// Empty lists are encoded as [] rather than null.
+func (r Report) MarshalJSON() ([]byte, error) {
+	out := reportJSON{
+		Applied:    r.Applied,
+		Skipped:    r.Skipped,
+		DurationNs: int64(r.Duration),
+		Timings:    make([]timingJSON, len(r.Timings)),
+	}
+	if out.Applied == nil {
+		out.Applied = []int{}
+	}
+	for i, t := range r.Timings {
+		out.Timings[i] = timingJSON{Version: t.Version, DurationNs: int64(t.Duration)}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes the format produced by MarshalJSON.
+func (r *Report) UnmarshalJSON(data []byte) error {
+	var in reportJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*r = Report{
+		Applied:  in.Applied,
+		Skipped:  in.Skipped,
+		Duration: time.Duration(in.DurationNs),
+	}
+	for _, t := range in.Timings {
+		r.Timings = append(r.Timings, MigrationTiming{Version: t.Version, Duration: time.Duration(t.DurationNs)})
+	}
+	return nil
+}
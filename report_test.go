@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestApplyReport(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+	rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+	report, err := ApplyReport(context.Background(), db, []string{"SELECT 1", "SELECT 2", "SELECT 3"})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !reflect.DeepEqual(report.Applied, []int{2, 3}) {
+		t.Fatalf("got applied %v, want [2 3]", report.Applied)
+	}
+	if report.Skipped != 1 {
+		t.Fatalf("got skipped %d, want 1", report.Skipped)
+	}
+	if len(report.Timings) != 2 || report.Timings[0].Version != 2 || report.Timings[1].Version != 3 {
+		t.Fatalf("unexpected timings %+v", report.Timings)
+	}
+	if report.Duration <= 0 {
+		t.Fatalf("expected positive duration, got %s", report.Duration)
+	}
+}
+
+func TestReportString(t *testing.T) {
+	cases := []struct {
+		report Report
+		want   string
+	}{
+		{Report{Skipped: 3, Duration: time.Millisecond}, "no pending migrations, skipped 3, took 1ms"},
+		{Report{Applied: []int{4}, Skipped: 3, Duration: time.Second}, "applied 1 migration (4), skipped 3, took 1s"},
+		{Report{Applied: []int{1, 2}, Duration: 2 * time.Second}, "applied 2 migrations (1, 2), skipped 0, took 2s"},
+	}
+	for _, tc := range cases {
+		if got := tc.report.String(); got != tc.want {
+			t.Fatalf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	report := Report{
+		Applied:  []int{3, 4},
+		Skipped:  2,
+		Duration: 15 * time.Millisecond,
+		Timings:  []MigrationTiming{{Version: 3, Duration: time.Millisecond}, {Version: 4, Duration: 2 * time.Millisecond}},
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{"applied":[3,4],"skipped":2,"duration_ns":15000000,"timings":[{"version":3,"duration_ns":1000000},{"version":4,"duration_ns":2000000}]}`
+	if string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, report) {
+		t.Fatalf("round trip: got %+v, want %+v", decoded, report)
+	}
+
+	data, err = json.Marshal(Report{})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if want := `{"applied":[],"skipped":0,"duration_ns":0,"timings":[]}`; string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,187 @@
+// Package rls provides Postgres row-level security templates for multi-tenant
+// schemas, so the usual policy does not have to be hand-rolled in every
+// migration. A TenantPolicy can be written out as explicit migrations:
+//
+//	policy := rls.TenantPolicy{Column: "tenant_id", Setting: "app.tenant_id", Type: "uuid"}
+//	m, err := policy.Migration("orders", "invoices")
+//
+// or attached to every table created with its column via the statement
+// injector, so no new table is left unprotected:
+//
+//	inject, err := policy.Injector()
+//	if err != nil { ... }
+//	err = migrations.Apply(ctx, db, migs, migrations.WithDialect(migrations.DialectPostgres),
+//		migrations.WithStatementInjector(inject))
+//
+// Sessions select their tenant with SET app.tenant_id = '...' (or set_config)
+// before querying. Superusers and roles with BYPASSRLS are not restricted by
+// any policy; table owners only with Force.
+package rls
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pechorka/migrations"
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// DefaultPolicyName is the name of a TenantPolicy without one.
+const DefaultPolicyName = "tenant_isolation"
+
+var (
+	settingPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)+$`)
+	typePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ .]*$`)
+	ident          = `(?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)`
+	createTable    = regexp.MustCompile(`(?is)^(?:\s+|--[^\n]*\n|/\*.*?\*/)*CREATE\s+(?:UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(` + ident + `(?:\s*\.\s*` + ident + `)?)\s*\((.*)\)`)
+	identPart      = regexp.MustCompile(ident)
+)
+
+// Enable returns the statement enabling row-level security on table, and with
+// force the one subjecting the table owner to it as well. A "schema.table"
+// name is quoted part by part.
+func Enable(table string, force bool) []string {
+	return enable(quoteTable(table), force)
+}
+
+func enable(quoted string, force bool) []string {
+	stmts := []string{"ALTER TABLE " + quoted + " ENABLE ROW LEVEL SECURITY"}
+	if force {
+		stmts = append(stmts, "ALTER TABLE "+quoted+" FORCE ROW LEVEL SECURITY")
+	}
+	return stmts
+}
+
+// TenantPolicy restricts the rows of a table to those whose tenant column
+// matches a session setting, both for reading and writing.
+type TenantPolicy struct {
+	// Name is the policy name (default DefaultPolicyName).
+	Name string
+	// Column holds the tenant of a row, e.g. "tenant_id".
+	Column string
+	// Setting is the custom configuration parameter holding the tenant of the
+	// session, e.g. "app.tenant_id". It must contain a dot.
+	Setting string
+	// Type is the SQL type the setting is cast to, matching Column's type,
+	// e.g. "uuid" or "bigint" (default "text").
+	Type string
+	// Force subjects the table owner to the policy too.
+	Force bool
+}
+
+// Statements returns the statements enabling row-level security on table and
+// creating the policy. A "schema.table" name is quoted part by part.
+func (p TenantPolicy) Statements(table string) ([]string, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p.statements(quoteTable(table)), nil
+}
+
+// Migration returns a migration applying the policy to tables, with a
+// DownSQL removing it again.
+func (p TenantPolicy) Migration(tables ...string) (migrations.Migration, error) {
+	if err := p.validate(); err != nil {
+		return migrations.Migration{}, err
+	}
+	if len(tables) == 0 {
+		return migrations.Migration{}, fmt.Errorf("rls: tenant policy migration needs at least one table")
+	}
+	var up, down []string
+	for _, table := range tables {
+		quoted := quoteTable(table)
+		up = append(up, p.statements(quoted)...)
+		down = append(p.dropStatements(quoted), down...)
+	}
+	return migrations.Migration{
+		Name:    "tenant_policy",
+		UpSQL:   strings.Join(up, ";\n"),
+		DownSQL: strings.Join(down, ";\n"),
+	}, nil
+}
+
+// Injector returns a function for migrations.WithStatementInjector that
+// applies the policy to every table created by a migration whose definition
+// has Column. Temporary tables are left alone.
+func (p TenantPolicy) Injector() (func(m migrations.Migration) (before, after []string), error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	column := regexp.MustCompile(`(?i)(?:^|,)\s*(?:` + regexp.QuoteMeta(p.Column) + `|` + regexp.QuoteMeta(utils.QuoteIdentDouble(p.Column)) + `)\s`)
+	return func(m migrations.Migration) (before, after []string) {
+		stmts := m.Statements
+		if len(stmts) == 0 {
+			stmts = utils.SplitStatementsFlavor(m.UpSQL, utils.FlavorPostgres)
+		}
+		for _, stmt := range stmts {
+			match := createTable.FindStringSubmatch(stmt)
+			if match == nil || !column.MatchString(match[2]) {
+				continue
+			}
+			after = append(after, p.statements(requoteTable(match[1]))...)
+		}
+		return nil, after
+	}, nil
+}
+
+func (p TenantPolicy) validate() error {
+	if p.Column == "" {
+		return fmt.Errorf("rls: tenant policy needs a column")
+	}
+	if !settingPattern.MatchString(p.Setting) {
+		return fmt.Errorf("rls: invalid setting %q: want a dotted name like \"app.tenant_id\"", p.Setting)
+	}
+	if p.Type != "" && !typePattern.MatchString(p.Type) {
+		return fmt.Errorf("rls: invalid type %q", p.Type)
+	}
+	return nil
+}
+
+func (p TenantPolicy) statements(quoted string) []string {
+	typ := p.Type
+	if typ == "" {
+		typ = "text"
+	}
+	check := utils.QuoteIdentDouble(p.Column) + " = current_setting('" + p.Setting + "')::" + typ
+	policy := "CREATE POLICY " + utils.QuoteIdentDouble(p.name()) + " ON " + quoted +
+		" USING (" + check + ") WITH CHECK (" + check + ")"
+	return append(enable(quoted, p.Force), policy)
+}
+
+func (p TenantPolicy) dropStatements(quoted string) []string {
+	stmts := []string{"DROP POLICY IF EXISTS " + utils.QuoteIdentDouble(p.name()) + " ON " + quoted}
+	if p.Force {
+		stmts = append(stmts, "ALTER TABLE "+quoted+" NO FORCE ROW LEVEL SECURITY")
+	}
+	return append(stmts, "ALTER TABLE "+quoted+" DISABLE ROW LEVEL SECURITY")
+}
+
+func (p TenantPolicy) name() string {
+	if p.Name == "" {
+		return DefaultPolicyName
+	}
+	return p.Name
+}
+
+// quoteTable quotes a "schema.table" name part by part.
+func quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = utils.QuoteIdentDouble(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// requoteTable quotes a table name as written in a CREATE TABLE statement,
+// folding unquoted parts to lower case like Postgres does.
+func requoteTable(name string) string {
+	parts := identPart.FindAllString(name, -1)
+	for i, part := range parts {
+		if strings.HasPrefix(part, `"`) {
+			continue
+		}
+		parts[i] = utils.QuoteIdentDouble(strings.ToLower(part))
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,103 @@
+package rls
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations"
+)
+
+func TestEnable(t *testing.T) {
+	got := Enable("app.orders", true)
+	want := []string{
+		`ALTER TABLE "app"."orders" ENABLE ROW LEVEL SECURITY`,
+		`ALTER TABLE "app"."orders" FORCE ROW LEVEL SECURITY`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestTenantPolicy(t *testing.T) {
+	policy := TenantPolicy{Column: "tenant_id", Setting: "app.tenant_id", Type: "uuid"}
+
+	t.Run("statements", func(t *testing.T) {
+		got, err := policy.Statements("orders")
+		if err != nil {
+			t.Fatalf("statements: %v", err)
+		}
+		want := []string{
+			`ALTER TABLE "orders" ENABLE ROW LEVEL SECURITY`,
+			`CREATE POLICY "tenant_isolation" ON "orders" USING ("tenant_id" = current_setting('app.tenant_id')::uuid) WITH CHECK ("tenant_id" = current_setting('app.tenant_id')::uuid)`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("migration", func(t *testing.T) {
+		forced := policy
+		forced.Name, forced.Force = "by_tenant", true
+		m, err := forced.Migration("orders", "invoices")
+		if err != nil {
+			t.Fatalf("migration: %v", err)
+		}
+		if n := strings.Count(m.UpSQL, `CREATE POLICY "by_tenant"`); n != 2 {
+			t.Fatalf("expected 2 policies, got %d in %q", n, m.UpSQL)
+		}
+		wantDown := strings.Join([]string{
+			`DROP POLICY IF EXISTS "by_tenant" ON "invoices"`,
+			`ALTER TABLE "invoices" NO FORCE ROW LEVEL SECURITY`,
+			`ALTER TABLE "invoices" DISABLE ROW LEVEL SECURITY`,
+			`DROP POLICY IF EXISTS "by_tenant" ON "orders"`,
+			`ALTER TABLE "orders" NO FORCE ROW LEVEL SECURITY`,
+			`ALTER TABLE "orders" DISABLE ROW LEVEL SECURITY`,
+		}, ";\n")
+		if m.DownSQL != wantDown {
+			t.Fatalf("got down %q, want %q", m.DownSQL, wantDown)
+		}
+		if err := migrations.Validate([]string{m.UpSQL, m.DownSQL}, migrations.DialectPostgres); err != nil {
+			t.Fatalf("validate: %v", err)
+		}
+	})
+
+	t.Run("injector", func(t *testing.T) {
+		inject, err := policy.Injector()
+		if err != nil {
+			t.Fatalf("injector: %v", err)
+		}
+		before, after := inject(migrations.Migration{Version: 1, UpSQL: `
+			-- tenants own orders
+			CREATE TABLE IF NOT EXISTS Sales.Orders (id BIGINT PRIMARY KEY, tenant_id UUID NOT NULL);
+			CREATE TABLE "Tenants" (id UUID PRIMARY KEY, name TEXT);
+			CREATE TEMP TABLE scratch (tenant_id UUID);
+			CREATE TABLE "Items" (id BIGINT, price NUMERIC(10, 2), "tenant_id" UUID);
+			CREATE INDEX orders_tenant ON sales.orders (tenant_id)`})
+		if before != nil {
+			t.Fatalf("expected nothing before, got %q", before)
+		}
+		var tables []string
+		for _, stmt := range after {
+			if table, ok := strings.CutPrefix(stmt, "ALTER TABLE "); ok {
+				tables = append(tables, strings.TrimSuffix(table, " ENABLE ROW LEVEL SECURITY"))
+			}
+		}
+		if want := []string{`"sales"."orders"`, `"Items"`}; !reflect.DeepEqual(tables, want) {
+			t.Fatalf("got tables %q, want %q", tables, want)
+		}
+	})
+
+	t.Run("rejects invalid policies", func(t *testing.T) {
+		for _, p := range []TenantPolicy{
+			{Setting: "app.tenant_id"},
+			{Column: "tenant_id", Setting: "tenant_id"},
+			{Column: "tenant_id", Setting: "app.tenant_id'); DROP TABLE x; --"},
+			{Column: "tenant_id", Setting: "app.tenant_id", Type: "uuid; DROP TABLE x"},
+		} {
+			if _, err := p.Statements("orders"); err == nil {
+				t.Fatalf("expected an error for %+v", p)
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// ErrMissingDownSQL is returned by RollbackTo when a migration it may have to
+// revert has no DownSQL.
+var ErrMissingDownSQL = errors.New("missing down migration")
+
+// RollbackTo reverts every applied migration above version, newest first, by
+// running its DownSQL and deleting its bookkeeping row, all in one
+// transaction; version 0 reverts everything. Checksums, dialects and progress
+// recorded for the reverted versions are removed too, so a later Apply runs
+// them again.
+//
+// Before touching the database, RollbackTo checks that every migration above
+// version has a DownSQL that splits cleanly and fails with ErrMissingDownSQL
+// listing the versions without one. Migrations limited to other dialects (see
+// Migration.Dialects) need none: they are only unrecorded. It fails with
+// ErrHistoryTruncated when the database records versions beyond the end of
+// migrations, whose down scripts are unknown.
+//
+// The options are those of Apply; TableName, Dialect, TxRunner, StatementTag
+// and Executor apply to the rollback.
+func RollbackTo(ctx context.Context, db *sql.DB, migrations []Migration, version int, userOptions ...Option) error {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return err
+	}
+	if _, err := upSQL(migrations, opts.Dialect); err != nil {
+		return err
+	}
+	if version < 0 || version > len(migrations) {
+		return fmt.Errorf("target version %d is out of range [0, %d]", version, len(migrations))
+	}
+
+	d := dialects[opts.Dialect]
+	downs := make([][]string, len(migrations))
+	var missing []string
+	for v := version + 1; v <= len(migrations); v++ {
+		m := migrations[v-1]
+		if len(m.Dialects) > 0 && !slices.Contains(m.Dialects, opts.Dialect) {
+			continue
+		}
+		if strings.TrimSpace(m.DownSQL) == "" {
+			missing = append(missing, strconv.Itoa(v))
+			continue
+		}
+		stmts, err := utils.SplitStatementsStrictFlavor(m.DownSQL, d.flavor)
+		if err != nil {
+			return fmt.Errorf("failed to split down migration #%d: %w", v, err)
+		}
+		downs[v-1] = stmts
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: versions %s", ErrMissingDownSQL, strings.Join(missing, ", "))
+	}
+
+	exec := opts.Executor
+	if exec == nil {
+		exec = func(ctx context.Context, tx *sql.Tx, stmt string) (sql.Result, error) {
+			return tx.ExecContext(ctx, stmt)
+		}
+	}
+	t := d.quoteIdent(opts.TableName)
+	return opts.TxRunner(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		var existingTables int
+		if err := tx.QueryRowContext(ctx, d.rebind(d.tableExists), opts.TableName).Scan(&existingTables); err != nil {
+			return fmt.Errorf("failed to check if migrations table %q exists: %w", opts.TableName, err)
+		}
+		if existingTables == 0 {
+			return nil // nothing was ever applied
+		}
+		if err := lockTable(ctx, tx, d, t); err != nil {
+			return err
+		}
+
+		var lastAppliedVersion int
+		queryLast := "SELECT COALESCE(MAX(version), -1) FROM " + t
+		if err := tx.QueryRowContext(ctx, queryLast).Scan(&lastAppliedVersion); err != nil {
+			return fmt.Errorf("failed to read last applied migration version: %w", err)
+		}
+		strict := opts
+		strict.AllowTruncatedHistory = false
+		if err := checkTruncatedHistory(ctx, tx, d, lastAppliedVersion, len(migrations), strict); err != nil {
+			return err
+		}
+
+		deleteStmt := d.rebind("DELETE FROM " + t + " WHERE version = ?")
+		for v := lastAppliedVersion; v > version; v-- {
+			tag := ""
+			if opts.StatementTag != "" {
+				tag = "/* migration " + strconv.Itoa(v) + ": " + opts.StatementTag + " */ "
+			}
+			for i, stmt := range downs[v-1] {
+				if opts.EnvAllowlist != nil {
+					interpolated, err := interpolateEnv(stmt, opts.EnvAllowlist)
+					if err != nil {
+						return fmt.Errorf("failed to execute down migration #%d, statement #%d: %w", v, i+1, err)
+					}
+					stmt = interpolated
+				}
+				if _, err := exec(ctx, tx, tag+stmt); err != nil {
+					return fmt.Errorf("failed to execute down migration #%d, statement #%d: %w", v, i+1, err)
+				}
+			}
+			if _, err := tx.ExecContext(ctx, deleteStmt, v); err != nil {
+				return fmt.Errorf("failed to unrecord migration #%d: %w", v, err)
+			}
+		}
+
+		for _, suffix := range []string{"_meta", "_dialect", "_progress"} {
+			if err := forgetVersions(ctx, tx, d, opts.TableName+suffix, version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forgetVersions deletes the rows of the companion table named table that
+// belong to versions above version, if the table exists.
+func forgetVersions(ctx context.Context, tx *sql.Tx, d dialect, table string, version int) error {
+	var existing int
+	if err := tx.QueryRowContext(ctx, d.rebind(d.tableExists), table).Scan(&existing); err != nil {
+		return fmt.Errorf("failed to check if table %q exists: %w", table, err)
+	}
+	if existing == 0 {
+		return nil
+	}
+	query := d.rebind("DELETE FROM " + d.quoteIdent(table) + " WHERE version > ?")
+	if _, err := tx.ExecContext(ctx, query, version); err != nil {
+		return fmt.Errorf("failed to clear table %q: %w", table, err)
+	}
+	return nil
+}
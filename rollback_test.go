@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestRollbackTo(t *testing.T) {
+	migs := []Migration{
+		{UpSQL: "CREATE TABLE a (id INT)", DownSQL: "DROP TABLE a"},
+		{UpSQL: "CREATE TABLE b (id INT)", DownSQL: "DROP TABLE b"},
+		{UpSQL: "CREATE TABLE c (id INT); CREATE TABLE d (id INT)", DownSQL: "DROP TABLE d; DROP TABLE c"},
+		{UpSQL: "CREATE EXTENSION x", Dialects: []Dialect{DialectPostgres}},
+	}
+
+	t.Run("reverts newest first", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("sqlite_master", []string{"count"}, []any{1})
+		rec.Return("MAX(version)", []string{"max"}, []any{4})
+
+		if err := RollbackTo(context.Background(), db, migs, 1); err != nil {
+			t.Fatalf("rollback: %v", err)
+		}
+		var got []string
+		for _, s := range rec.Statements() {
+			if strings.HasPrefix(s.Query, "DROP") || strings.HasPrefix(s.Query, "DELETE") {
+				got = append(got, s.Query)
+			}
+		}
+		want := []string{
+			`DELETE FROM "migrations" WHERE version = ?`,
+			"DROP TABLE d",
+			"DROP TABLE c",
+			`DELETE FROM "migrations" WHERE version = ?`,
+			"DROP TABLE b",
+			`DELETE FROM "migrations" WHERE version = ?`,
+			`DELETE FROM "migrations_meta" WHERE version > ?`,
+			`DELETE FROM "migrations_dialect" WHERE version > ?`,
+			`DELETE FROM "migrations_progress" WHERE version > ?`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("requires every down script up front", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		noDown := append(migs[:len(migs):len(migs)], Migration{UpSQL: "SELECT 1"}, Migration{UpSQL: "SELECT 2", DownSQL: "  "})
+
+		err := RollbackTo(context.Background(), db, noDown, 0)
+		if !errors.Is(err, ErrMissingDownSQL) || !strings.Contains(err.Error(), "versions 5, 6") {
+			t.Fatalf("expected ErrMissingDownSQL for versions 5 and 6, got %v", err)
+		}
+		if len(rec.Queries()) != 0 {
+			t.Fatalf("expected no queries, got %v", rec.Queries())
+		}
+	})
+
+	t.Run("refuses unknown applied versions", func(t *testing.T) {
+		db, rec := migrationsmock.DB()
+		defer db.Close()
+		rec.Return("sqlite_master", []string{"count"}, []any{1})
+		rec.Return("MAX(version)", []string{"max"}, []any{6})
+		rec.Return("WHERE version > ? ORDER BY version", []string{"version"}, []any{5}, []any{6})
+
+		err := RollbackTo(context.Background(), db, migs, 0, WithAllowTruncatedHistory(true))
+		if !errors.Is(err, ErrHistoryTruncated) {
+			t.Fatalf("expected ErrHistoryTruncated, got %v", err)
+		}
+	})
+
+	t.Run("rejects a target out of range", func(t *testing.T) {
+		db, _ := migrationsmock.DB()
+		defer db.Close()
+		if err := RollbackTo(context.Background(), db, migs, 5); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
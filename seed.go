@@ -0,0 +1,71 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InsertOrIgnore returns an INSERT statement for dialect that adds rows to
+// table and silently skips the ones conflicting with an existing primary key
+// or unique constraint, together with its arguments. It is meant for
+// reference data in Go-function migrations (see Migration.Func), which then
+// stay correct when re-run and need no per-dialect variants:
+//
+//	query, args, err := migrations.InsertOrIgnore(migrations.DialectPostgres, "countries",
+//		[]string{"code", "name"}, [][]any{{"de", "Germany"}, {"fr", "France"}})
+//	if err != nil {
+//		return err
+//	}
+//	_, err = tx.ExecContext(ctx, query, args...)
+//
+// SQLite gets INSERT OR IGNORE, Postgres ON CONFLICT DO NOTHING and MySQL
+// INSERT IGNORE. Identifiers are quoted for the dialect; a "schema.table"
+// name is quoted part by part.
+func InsertOrIgnore(dialect Dialect, table string, cols []string, rows [][]any) (string, []any, error) {
+	if !IsValidDialect(dialect) {
+		return "", nil, fmt.Errorf("invalid dialect %d", dialect)
+	}
+	if table == "" || len(cols) == 0 {
+		return "", nil, fmt.Errorf("insert needs a table and at least one column")
+	}
+	if len(rows) == 0 {
+		return "", nil, fmt.Errorf("insert into %q needs at least one row", table)
+	}
+	d := dialects[dialect]
+
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = d.quoteIdent(part)
+	}
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = d.quoteIdent(col)
+	}
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") + ")"
+
+	var b strings.Builder
+	switch dialect {
+	case DialectSqlite:
+		b.WriteString("INSERT OR IGNORE INTO ")
+	case DialectMysql:
+		b.WriteString("INSERT IGNORE INTO ")
+	default:
+		b.WriteString("INSERT INTO ")
+	}
+	b.WriteString(strings.Join(parts, ".") + " (" + strings.Join(quoted, ", ") + ") VALUES ")
+	args := make([]any, 0, len(rows)*len(cols))
+	for i, r := range rows {
+		if len(r) != len(cols) {
+			return "", nil, fmt.Errorf("insert into %q: row %d has %d values, want %d", table, i, len(r), len(cols))
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(row)
+		args = append(args, r...)
+	}
+	if dialect == DialectPostgres {
+		b.WriteString(" ON CONFLICT DO NOTHING")
+	}
+	return d.rebind(b.String()), args, nil
+}
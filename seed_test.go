@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInsertOrIgnore(t *testing.T) {
+	cols := []string{"code", "name"}
+	rows := [][]any{{"de", "Germany"}, {"fr", "France"}}
+	for _, tc := range []struct {
+		dialect Dialect
+		table   string
+		want    string
+	}{
+		{DialectSqlite, "countries", `INSERT OR IGNORE INTO "countries" ("code", "name") VALUES (?, ?), (?, ?)`},
+		{DialectPostgres, "ref.countries", `INSERT INTO "ref"."countries" ("code", "name") VALUES ($1, $2), ($3, $4) ON CONFLICT DO NOTHING`},
+		{DialectMysql, "countries", "INSERT IGNORE INTO `countries` (`code`, `name`) VALUES (?, ?), (?, ?)"},
+	} {
+		t.Run(tc.dialect.String(), func(t *testing.T) {
+			query, args, err := InsertOrIgnore(tc.dialect, tc.table, cols, rows)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if query != tc.want {
+				t.Fatalf("got %s, want %s", query, tc.want)
+			}
+			if want := []any{"de", "Germany", "fr", "France"}; !reflect.DeepEqual(args, want) {
+				t.Fatalf("got args %v, want %v", args, want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cols    []string
+		rows    [][]any
+		wantErr string
+	}{
+		{"no columns", nil, rows, "at least one column"},
+		{"no rows", cols, nil, "needs at least one row"},
+		{"short row", cols, [][]any{{"de"}}, "row 0 has 1 values, want 2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := InsertOrIgnore(DialectSqlite, "countries", tc.cols, tc.rows)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+)
+
+// Source supplies migrations on demand, e.g. from a database, a config
+// service or a code generator, for ApplySource.
+type Source interface {
+	Migrations(ctx context.Context) ([]Migration, error)
+}
+
+// SourceFunc adapts an ordinary function to the Source interface.
+type SourceFunc func(ctx context.Context) ([]Migration, error)
+
+// Migrations calls f(ctx).
+func (f SourceFunc) Migrations(ctx context.Context) ([]Migration, error) {
+	return f(ctx)
+}
+
+// FSSource returns a Source reading migration files from dir of fsys with
+// LoadFS every time it is asked for migrations.
+func FSSource(fsys fs.FS, dir string, options ...FileOption) Source {
+	return SourceFunc(func(context.Context) ([]Migration, error) {
+		return LoadFS(fsys, dir, options...)
+	})
+}
+
+// ApplySource loads the migrations from src and applies them like
+// ApplyMigrations. Loading happens before any connection to db is made.
+func ApplySource(ctx context.Context, db *sql.DB, src Source, userOptions ...Option) error {
+	migrations, err := src.Migrations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return ApplyMigrations(ctx, db, migrations, userOptions...)
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestApplySource(t *testing.T) {
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+
+	src := FSSource(fstest.MapFS{
+		"m/0001_init.sql": {Data: []byte("-- +goose Up\nCREATE TABLE t (id INT);\n-- +goose Down\nDROP TABLE t;\n")},
+	}, "m")
+	if err := ApplySource(context.Background(), db, src); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if countQuery(rec, "CREATE TABLE t (id INT)") != 1 || countQuery(rec, "DROP TABLE t") != 0 {
+		t.Fatalf("unexpected statements %q", rec.Queries())
+	}
+
+	rec.Reset()
+	boom := errors.New("config service unavailable")
+	err := ApplySource(context.Background(), db, SourceFunc(func(context.Context) ([]Migration, error) {
+		return nil, boom
+	}))
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected source error, got %v", err)
+	}
+	if len(rec.Queries()) != 0 {
+		t.Fatalf("expected no statements, got %q", rec.Queries())
+	}
+}
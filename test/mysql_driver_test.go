@@ -1,11 +1,14 @@
 package test
 
 import (
+	"context"
 	"database/sql"
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
 	migrations "github.com/pechorka/migrations"
+	"github.com/pechorka/migrations/conformance"
 	"github.com/pechorka/migrations/pkg/utils"
 	"github.com/stretchr/testify/require"
 )
@@ -120,6 +123,346 @@ func TestMySQL(t *testing.T) {
 		require.Error(t, err)
 	})
 
+	t.Run("truncated history is rejected", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		migs := []string{`SELECT 1`, `SELECT 2`, `SELECT 3`}
+		err := migrations.Apply(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+
+		err = migrations.Apply(t.Context(), db, migs[:1], opts...)
+		require.ErrorIs(t, err, migrations.ErrHistoryTruncated)
+		var truncated *migrations.TruncatedHistoryError
+		require.ErrorAs(t, err, &truncated)
+		require.Equal(t, []int{2, 3}, truncated.Missing)
+
+		allow := append(opts[:len(opts):len(opts)], migrations.WithAllowTruncatedHistory(true))
+		err = migrations.Apply(t.Context(), db, migs[:1], allow...)
+		require.NoError(t, err)
+	})
+
+	t.Run("fresh database callback runs once", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		calls := 0
+		onFresh := migrations.WithOnFreshDatabase(func(ctx context.Context, tx *sql.Tx) error {
+			calls++
+			return nil
+		})
+		withHook := append(opts[:len(opts):len(opts)], onFresh)
+		migs := []string{`SELECT 1`}
+
+		err := migrations.Apply(t.Context(), db, migs, withHook...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, withHook...)
+		require.NoError(t, err)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("bookkeeping table conforms", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		err := migrations.Apply(t.Context(), db, []string{}, opts...)
+		require.NoError(t, err)
+		err = migrations.CheckTableConformance(t.Context(), db, opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("legacy applied_at column is upgraded", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		_, err := db.Exec("CREATE TABLE `mysql_driver_test` (version INT NOT NULL PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO `mysql_driver_test` (version) VALUES (0), (1)")
+		require.NoError(t, err)
+
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 1`, `SELECT 2`}, opts...)
+		require.NoError(t, err)
+		err = migrations.CheckTableConformance(t.Context(), db, opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("statement cap splits transactions", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		capped := append(opts[:len(opts):len(opts)], migrations.WithMaxStatementsPerTx(2))
+		migs := []string{
+			`CREATE TABLE cap_items (id INT PRIMARY KEY)`,
+			`INSERT INTO cap_items (id) VALUES (1); INSERT INTO cap_items (id) VALUES (2); INSERT INTO cap_items (id) VALUES (3)`,
+		}
+
+		report, err := migrations.ApplyReport(t.Context(), db, migs, capped...)
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 2}, report.Applied)
+
+		var n int
+		err = db.QueryRow(`SELECT COUNT(*) FROM cap_items`).Scan(&n)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+	})
+
+	t.Run("verify reports pending migrations", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		migs := []string{`SELECT 1`, `SELECT 2`}
+
+		_, err := migrations.CurrentVersion(t.Context(), db, opts...)
+		require.ErrorIs(t, err, migrations.ErrNoMigrations)
+		err = migrations.Apply(t.Context(), db, migs[:1], opts...)
+		require.NoError(t, err)
+		version, err := migrations.CurrentVersion(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), version)
+		var pending *migrations.PendingMigrationsError
+		err = migrations.Verify(t.Context(), db, migs, opts...)
+		require.ErrorAs(t, err, &pending)
+		require.Equal(t, []int{2}, pending.Pending)
+
+		err = migrations.Apply(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+		err = migrations.Verify(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("executable comments are kept", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		migs := []string{"/*!40101 SET @ec_marker = 1 */; CREATE TABLE `ec_items` (id INT) /*!50100 COMMENT='from executable comment' */"}
+
+		err := migrations.Apply(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+
+		var comment string
+		err = db.QueryRow(`SELECT table_comment FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'ec_items'`).Scan(&comment)
+		require.NoError(t, err)
+		require.Equal(t, "from executable comment", comment)
+	})
+
+	t.Run("server-side cancel stops the running statement", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		withCancel := append(opts[:len(opts):len(opts)], migrations.WithServerSideCancel(true))
+		ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+		defer cancel()
+
+		err := migrations.Apply(ctx, db, []string{`SELECT SLEEP(30)`}, withCancel...)
+		require.Error(t, err)
+
+		require.Eventually(t, func() bool {
+			var running int
+			err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.processlist WHERE info = 'SELECT SLEEP(30)'`).Scan(&running)
+			return err == nil && running == 0
+		}, 5*time.Second, 100*time.Millisecond)
+	})
+
+	t.Run("conformance", func(t *testing.T) {
+		conformance.Run(t, "mysql", dsn, migrations.DialectMysql)
+	})
+
+	t.Run("fleet guard holds back a canary", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		migs := []string{`SELECT 1`, `SELECT 2`, `SELECT 3`}
+		stable := append(opts[:len(opts):len(opts)], migrations.WithFleetGuard("stable-1", 1, time.Hour))
+		canary := append(opts[:len(opts):len(opts)], migrations.WithFleetGuard("canary-1", 1, time.Hour))
+
+		err := migrations.Apply(t.Context(), db, migs[:1], stable...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, canary...)
+		require.ErrorIs(t, err, migrations.ErrFleetSkew)
+		err = migrations.Apply(t.Context(), db, migs[:2], canary...)
+		require.NoError(t, err)
+		err = migrations.Verify(t.Context(), db, migs[:2], opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("checksums detect edited migrations", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithChecksums(true))
+
+		err := migrations.Apply(t.Context(), db, []string{`SELECT 1`}, opts...)
+		require.NoError(t, err)
+		recorded, err := migrations.AppliedChecksums(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Empty(t, recorded)
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 1`, `SELECT 2`}, checked...)
+		require.NoError(t, err)
+		recorded, err = migrations.AppliedChecksums(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Equal(t, migrations.Checksums([]string{`SELECT 1`, `SELECT 2`}), recorded)
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 1`, `SELECT 2`}, checked...)
+		require.NoError(t, err)
+
+		var mismatch *migrations.ChecksumMismatchError
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 10`, `SELECT 2`}, checked...)
+		require.ErrorAs(t, err, &mismatch)
+		require.Equal(t, []int{1}, mismatch.Versions)
+	})
+
+	t.Run("insert or ignore seeds reference data once", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		seed := func(ctx context.Context, tx *sql.Tx) error {
+			query, args, err := migrations.InsertOrIgnore(migrations.DialectMysql, "countries",
+				[]string{"code", "name"}, [][]any{{"de", "Germany"}, {"fr", "France"}})
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, query, args...)
+			return err
+		}
+		migs := []migrations.Migration{
+			{UpSQL: `CREATE TABLE countries (code VARCHAR(2) PRIMARY KEY, name VARCHAR(64) NOT NULL);
+				INSERT INTO countries (code, name) VALUES ('de', 'Deutschland')`},
+			{Func: seed},
+			{Func: seed},
+		}
+
+		err := migrations.ApplyMigrations(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+
+		var n int
+		err = db.QueryRow(`SELECT COUNT(*) FROM countries`).Scan(&n)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		var name string
+		err = db.QueryRow(`SELECT name FROM countries WHERE code = 'de'`).Scan(&name)
+		require.NoError(t, err)
+		require.Equal(t, "Deutschland", name)
+	})
+
+	t.Run("dialect check detects another dialect", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithDialectCheck(true))
+		migs := []string{`SELECT 1`, `SELECT 2`}
+
+		err := migrations.Apply(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+		recorded, err := migrations.AppliedDialects(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Equal(t, map[int]migrations.Dialect{1: migrations.DialectMysql, 2: migrations.DialectMysql}, recorded)
+
+		// Pretend version 2 was applied by a service configured with another dialect.
+		_, err = db.Exec(`UPDATE mysql_driver_test_dialect SET dialect = 'sqlite' WHERE version = 2`)
+		require.NoError(t, err)
+		var mismatch *migrations.DialectMismatchError
+		err = migrations.Apply(t.Context(), db, migs, checked...)
+		require.ErrorAs(t, err, &mismatch)
+		require.Equal(t, migrations.DialectSqlite, mismatch.Recorded)
+		require.Equal(t, []int{2}, mismatch.Versions)
+	})
+
+	t.Run("data loss check guards drops", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithDataLossCheck(true))
+		migs := []string{
+			`CREATE TABLE accounts (id INT PRIMARY KEY, note VARCHAR(64), email VARCHAR(64));
+			CREATE TABLE scratch (id INT);
+			INSERT INTO accounts (id, email) VALUES (1, 'a@example.com')`,
+			`ALTER TABLE accounts DROP COLUMN note;
+			DROP TABLE scratch`,
+		}
+		err := migrations.Apply(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+
+		migs = append(migs, `ALTER TABLE accounts DROP COLUMN email`)
+		err = migrations.Apply(t.Context(), db, migs, checked...)
+		require.ErrorIs(t, err, migrations.ErrDataLoss)
+
+		migs = append(migs, `DROP TABLE IF EXISTS missing; DROP TABLE accounts`)
+		err = migrations.Apply(t.Context(), db, migs, append(checked, migrations.WithConfirmDataLoss(true))...)
+		require.NoError(t, err)
+	})
+
+	t.Run("lock ttl takes over an expired lease", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		leased := append(opts[:len(opts):len(opts)], migrations.WithLockTTL(time.Minute))
+		migs := []string{`SELECT 1`}
+
+		// Pretend another migrator holds the lease until 2100.
+		_, err := db.Exec(`CREATE TABLE mysql_driver_test_lock (id INTEGER PRIMARY KEY, holder VARCHAR(64) NOT NULL, expires_at BIGINT NOT NULL)`)
+		require.NoError(t, err)
+		_, err = db.Exec(`INSERT INTO mysql_driver_test_lock (id, holder, expires_at) VALUES (1, 'other', 4102444800000)`)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+		defer cancel()
+		err = migrations.Apply(ctx, db, migs, leased...)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// An expired lease is taken over and released when Apply returns.
+		_, err = db.Exec(`UPDATE mysql_driver_test_lock SET expires_at = 0`)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, leased...)
+		require.NoError(t, err)
+		var held int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM mysql_driver_test_lock`).Scan(&held))
+		require.Zero(t, held)
+	})
+
+	t.Run("rollback to a target version", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithChecksums(true))
+		migs := []migrations.Migration{
+			{UpSQL: `CREATE TABLE rb_a (id INT)`, DownSQL: `DROP TABLE rb_a`},
+			{UpSQL: `CREATE TABLE rb_b (id INT)`, DownSQL: `DROP TABLE rb_b`},
+			{UpSQL: `CREATE TABLE rb_c (id INT); INSERT INTO rb_c (id) VALUES (1)`, DownSQL: `DROP TABLE rb_c`},
+		}
+		err := migrations.ApplyMigrations(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+
+		err = migrations.RollbackTo(t.Context(), db, migs, 1, checked...)
+		require.NoError(t, err)
+		var head int
+		require.NoError(t, db.QueryRow(`SELECT MAX(version) FROM mysql_driver_test`).Scan(&head))
+		require.Equal(t, 1, head)
+		_, err = db.Exec(`SELECT COUNT(*) FROM rb_b`)
+		require.Error(t, err)
+
+		// The reverted versions run again, checksums included.
+		err = migrations.ApplyMigrations(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+		var rows int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM rb_c`).Scan(&rows))
+		require.Equal(t, 1, rows)
+	})
+
+	t.Run("apply one migration at a time", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		migs := []string{`CREATE TABLE step_a (id INT)`, `CREATE TABLE step_b (id INT)`}
+
+		for want := 1; want <= len(migs); want++ {
+			applied, err := migrations.ApplyOne(t.Context(), db, migs, opts...)
+			require.NoError(t, err)
+			require.Equal(t, want, applied)
+			version, err := migrations.CurrentVersion(t.Context(), db, opts...)
+			require.NoError(t, err)
+			require.Equal(t, int64(want), version)
+		}
+		applied, err := migrations.ApplyOne(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+		require.Zero(t, applied)
+	})
+
+	t.Run("environment variables are interpolated", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		t.Setenv("MIGRATIONS_ENV_TABLE", "env_items")
+		interpolated := append(opts[:len(opts):len(opts)], migrations.WithEnvAllowlist("MIGRATIONS_ENV_TABLE"))
+		migs := []string{`CREATE TABLE ${MIGRATIONS_ENV_TABLE} (id INT)`, `INSERT INTO ${MIGRATIONS_ENV_TABLE} (id) VALUES (1)`}
+
+		err := migrations.Apply(t.Context(), db, migs, opts...)
+		require.Error(t, err)
+		err = migrations.Apply(t.Context(), db, []string{`SELECT '${HOME}'`}, interpolated...)
+		require.ErrorIs(t, err, migrations.ErrEnvNotAllowed)
+		err = migrations.Apply(t.Context(), db, migs, interpolated...)
+		require.NoError(t, err)
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM env_items`).Scan(&n))
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("post-apply verification", func(t *testing.T) {
+		db := openDB(t, "mysql", dsn, resetMySQL)
+		verify := append(opts[:len(opts):len(opts)], migrations.WithPostApplyVerification(true))
+		migs := []string{`SELECT 1`, `SELECT 2`}
+
+		err := migrations.Apply(t.Context(), db, migs[:1], verify...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, verify...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, verify...)
+		require.NoError(t, err)
+	})
+
 	t.Run("connection is invalid", func(t *testing.T) {
 		badDSN := "root:root@tcp(127.0.0.1:1)/testdb?parseTime=true&multiStatements=true&timeout=1s&readTimeout=1s&writeTimeout=1s"
 		badDB, err := sql.Open("mysql", badDSN)
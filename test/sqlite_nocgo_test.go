@@ -4,10 +4,16 @@
 package test
 
 import (
+	"context"
 	"database/sql"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	migrations "github.com/pechorka/migrations"
+	"github.com/pechorka/migrations/conformance"
 	"github.com/stretchr/testify/require"
 	_ "modernc.org/sqlite" // pure Go (no CGO) SQLite driver
 )
@@ -115,6 +121,383 @@ func TestSQLite_NoCGO(t *testing.T) {
 		require.Error(t, err)
 	})
 
+	t.Run("truncated history is rejected", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		migs := []string{`SELECT 1`, `SELECT 2`, `SELECT 3`}
+		err := migrations.Apply(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+
+		err = migrations.Apply(t.Context(), db, migs[:1], opts...)
+		require.ErrorIs(t, err, migrations.ErrHistoryTruncated)
+		var truncated *migrations.TruncatedHistoryError
+		require.ErrorAs(t, err, &truncated)
+		require.Equal(t, []int{2, 3}, truncated.Missing)
+
+		allow := append(opts[:len(opts):len(opts)], migrations.WithAllowTruncatedHistory(true))
+		err = migrations.Apply(t.Context(), db, migs[:1], allow...)
+		require.NoError(t, err)
+	})
+
+	t.Run("apply report", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		migs := []string{`SELECT 1`, `SELECT 2`, `SELECT 3`}
+		err := migrations.Apply(t.Context(), db, migs[:1], opts...)
+		require.NoError(t, err)
+
+		report, err := migrations.ApplyReport(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+		require.Equal(t, []int{2, 3}, report.Applied)
+		require.Equal(t, 1, report.Skipped)
+		require.Len(t, report.Timings, 2)
+	})
+
+	t.Run("fresh database callback runs once", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		calls := 0
+		onFresh := migrations.WithOnFreshDatabase(func(ctx context.Context, tx *sql.Tx) error {
+			calls++
+			return nil
+		})
+		withHook := append(opts[:len(opts):len(opts)], onFresh)
+		migs := []string{`SELECT 1`}
+
+		err := migrations.Apply(t.Context(), db, migs, withHook...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, withHook...)
+		require.NoError(t, err)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("bookkeeping table conforms", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		err := migrations.Apply(t.Context(), db, []string{}, opts...)
+		require.NoError(t, err)
+		err = migrations.CheckTableConformance(t.Context(), db, opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("statement cap splits transactions", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		capped := append(opts[:len(opts):len(opts)], migrations.WithMaxStatementsPerTx(2))
+		migs := []string{
+			`CREATE TABLE cap_items (id INT PRIMARY KEY)`,
+			`INSERT INTO cap_items (id) VALUES (1); INSERT INTO cap_items (id) VALUES (2); INSERT INTO cap_items (id) VALUES (3)`,
+		}
+
+		report, err := migrations.ApplyReport(t.Context(), db, migs, capped...)
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 2}, report.Applied)
+
+		var n int
+		err = db.QueryRow(`SELECT COUNT(*) FROM cap_items`).Scan(&n)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+	})
+
+	t.Run("verify reports pending migrations", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		migs := []string{`SELECT 1`, `SELECT 2`}
+
+		_, err := migrations.CurrentVersion(t.Context(), db, opts...)
+		require.ErrorIs(t, err, migrations.ErrNoMigrations)
+		err = migrations.Apply(t.Context(), db, migs[:1], opts...)
+		require.NoError(t, err)
+		version, err := migrations.CurrentVersion(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), version)
+		var pending *migrations.PendingMigrationsError
+		err = migrations.Verify(t.Context(), db, migs, opts...)
+		require.ErrorAs(t, err, &pending)
+		require.Equal(t, []int{2}, pending.Pending)
+
+		err = migrations.Apply(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+		err = migrations.Verify(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("trigger with multi-statement body", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		migs := []string{`
+CREATE TABLE trg_items (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE trg_log (item_id INTEGER NOT NULL, note TEXT NOT NULL);
+CREATE TRIGGER trg_items_insert AFTER INSERT ON trg_items
+BEGIN
+    INSERT INTO trg_log (item_id, note) VALUES (NEW.id, 'created; first');
+    INSERT INTO trg_log (item_id, note) VALUES (NEW.id, CASE WHEN NEW.name = '' THEN 'empty' ELSE 'named' END);
+END;
+INSERT INTO trg_items (name) VALUES ('a');`}
+
+		err := migrations.Apply(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+
+		var n int
+		err = db.QueryRow(`SELECT COUNT(*) FROM trg_log`).Scan(&n)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+	})
+
+	t.Run("conformance", func(t *testing.T) {
+		conformance.Run(t, "sqlite", filepath.Join(t.TempDir(), "conformance.db"), migrations.DialectSqlite)
+	})
+
+	t.Run("fleet guard holds back a canary", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		migs := []string{`SELECT 1`, `SELECT 2`, `SELECT 3`}
+		stable := append(opts[:len(opts):len(opts)], migrations.WithFleetGuard("stable-1", 1, time.Hour))
+		canary := append(opts[:len(opts):len(opts)], migrations.WithFleetGuard("canary-1", 1, time.Hour))
+
+		err := migrations.Apply(t.Context(), db, migs[:1], stable...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, canary...)
+		require.ErrorIs(t, err, migrations.ErrFleetSkew)
+		err = migrations.Apply(t.Context(), db, migs[:2], canary...)
+		require.NoError(t, err)
+		err = migrations.Verify(t.Context(), db, migs[:2], opts...)
+		require.NoError(t, err)
+	})
+
+	t.Run("recreate copies history into a new database", func(t *testing.T) {
+		src := openDB(t, "sqlite", dsn, resetSQLite)
+		dst := openDB(t, "sqlite", filepath.Join(t.TempDir(), "recreated.db"), resetSQLite)
+		migs := []string{`CREATE TABLE recreated (id INTEGER PRIMARY KEY)`, `SELECT 2`}
+
+		err := migrations.Apply(t.Context(), src, migs, opts...)
+		require.NoError(t, err)
+		_, err = src.ExecContext(t.Context(), `UPDATE modernc_sqlite_test SET applied_at = '2001-02-03 04:05:06'`)
+		require.NoError(t, err)
+
+		err = migrations.Recreate(t.Context(), src, dst, migs, opts...)
+		require.NoError(t, err)
+		var year string
+		err = dst.QueryRowContext(t.Context(), `SELECT strftime('%Y', applied_at) FROM modernc_sqlite_test WHERE version = 2`).Scan(&year)
+		require.NoError(t, err)
+		require.Equal(t, "2001", year)
+		_, err = dst.ExecContext(t.Context(), `INSERT INTO recreated (id) VALUES (1)`)
+		require.NoError(t, err)
+
+		err = migrations.Recreate(t.Context(), src, dst, migs, opts...)
+		require.ErrorContains(t, err, "already has a migrations table")
+		err = migrations.Recreate(t.Context(), src, dst, append(migs, `SELECT 3`), opts...)
+		require.ErrorIs(t, err, migrations.ErrPendingMigrations)
+	})
+
+	t.Run("checksums detect edited migrations", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithChecksums(true))
+
+		err := migrations.Apply(t.Context(), db, []string{`SELECT 1`}, opts...)
+		require.NoError(t, err)
+		recorded, err := migrations.AppliedChecksums(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Empty(t, recorded)
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 1`, `SELECT 2`}, checked...)
+		require.NoError(t, err)
+		recorded, err = migrations.AppliedChecksums(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Equal(t, migrations.Checksums([]string{`SELECT 1`, `SELECT 2`}), recorded)
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 1`, `SELECT 2`}, checked...)
+		require.NoError(t, err)
+
+		var mismatch *migrations.ChecksumMismatchError
+		err = migrations.Apply(t.Context(), db, []string{`SELECT 10`, `SELECT 2`}, checked...)
+		require.ErrorAs(t, err, &mismatch)
+		require.Equal(t, []int{1}, mismatch.Versions)
+	})
+
+	t.Run("insert or ignore seeds reference data once", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		seed := func(ctx context.Context, tx *sql.Tx) error {
+			query, args, err := migrations.InsertOrIgnore(migrations.DialectSqlite, "countries",
+				[]string{"code", "name"}, [][]any{{"de", "Germany"}, {"fr", "France"}})
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, query, args...)
+			return err
+		}
+		migs := []migrations.Migration{
+			{UpSQL: `CREATE TABLE countries (code VARCHAR(2) PRIMARY KEY, name VARCHAR(64) NOT NULL);
+				INSERT INTO countries (code, name) VALUES ('de', 'Deutschland')`},
+			{Func: seed},
+			{Func: seed},
+		}
+
+		err := migrations.ApplyMigrations(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+
+		var n int
+		err = db.QueryRow(`SELECT COUNT(*) FROM countries`).Scan(&n)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		var name string
+		err = db.QueryRow(`SELECT name FROM countries WHERE code = 'de'`).Scan(&name)
+		require.NoError(t, err)
+		require.Equal(t, "Deutschland", name)
+	})
+
+	t.Run("dialect check detects another dialect", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithDialectCheck(true))
+		migs := []string{`SELECT 1`, `SELECT 2`}
+
+		err := migrations.Apply(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+		recorded, err := migrations.AppliedDialects(t.Context(), db, opts...)
+		require.NoError(t, err)
+		require.Equal(t, map[int]migrations.Dialect{1: migrations.DialectSqlite, 2: migrations.DialectSqlite}, recorded)
+
+		// Pretend version 2 was applied by a service configured with another dialect.
+		_, err = db.Exec(`UPDATE modernc_sqlite_test_dialect SET dialect = 'mysql' WHERE version = 2`)
+		require.NoError(t, err)
+		var mismatch *migrations.DialectMismatchError
+		err = migrations.Apply(t.Context(), db, migs, checked...)
+		require.ErrorAs(t, err, &mismatch)
+		require.Equal(t, migrations.DialectMysql, mismatch.Recorded)
+		require.Equal(t, []int{2}, mismatch.Versions)
+	})
+
+	t.Run("data loss check guards drops", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithDataLossCheck(true))
+		migs := []string{
+			`CREATE TABLE accounts (id INT PRIMARY KEY, note VARCHAR(64), email VARCHAR(64));
+			CREATE TABLE scratch (id INT);
+			INSERT INTO accounts (id, email) VALUES (1, 'a@example.com')`,
+			`ALTER TABLE accounts DROP COLUMN note;
+			DROP TABLE scratch`,
+		}
+		err := migrations.Apply(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+
+		migs = append(migs, `ALTER TABLE accounts DROP COLUMN email`)
+		err = migrations.Apply(t.Context(), db, migs, checked...)
+		require.ErrorIs(t, err, migrations.ErrDataLoss)
+
+		migs = append(migs, `DROP TABLE IF EXISTS missing; DROP TABLE accounts`)
+		err = migrations.Apply(t.Context(), db, migs, append(checked, migrations.WithConfirmDataLoss(true))...)
+		require.NoError(t, err)
+	})
+
+	t.Run("lock ttl takes over an expired lease", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		leased := append(opts[:len(opts):len(opts)], migrations.WithLockTTL(time.Minute))
+		migs := []string{`SELECT 1`}
+
+		// Pretend another migrator holds the lease until 2100.
+		_, err := db.Exec(`CREATE TABLE modernc_sqlite_test_lock (id INTEGER PRIMARY KEY, holder VARCHAR(64) NOT NULL, expires_at BIGINT NOT NULL)`)
+		require.NoError(t, err)
+		_, err = db.Exec(`INSERT INTO modernc_sqlite_test_lock (id, holder, expires_at) VALUES (1, 'other', 4102444800000)`)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+		defer cancel()
+		err = migrations.Apply(ctx, db, migs, leased...)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// An expired lease is taken over and released when Apply returns.
+		_, err = db.Exec(`UPDATE modernc_sqlite_test_lock SET expires_at = 0`)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, leased...)
+		require.NoError(t, err)
+		var held int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM modernc_sqlite_test_lock`).Scan(&held))
+		require.Zero(t, held)
+	})
+
+	t.Run("rollback to a target version", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		checked := append(opts[:len(opts):len(opts)], migrations.WithChecksums(true))
+		migs := []migrations.Migration{
+			{UpSQL: `CREATE TABLE rb_a (id INT)`, DownSQL: `DROP TABLE rb_a`},
+			{UpSQL: `CREATE TABLE rb_b (id INT)`, DownSQL: `DROP TABLE rb_b`},
+			{UpSQL: `CREATE TABLE rb_c (id INT); INSERT INTO rb_c (id) VALUES (1)`, DownSQL: `DROP TABLE rb_c`},
+		}
+		err := migrations.ApplyMigrations(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+
+		err = migrations.RollbackTo(t.Context(), db, migs, 1, checked...)
+		require.NoError(t, err)
+		var head int
+		require.NoError(t, db.QueryRow(`SELECT MAX(version) FROM modernc_sqlite_test`).Scan(&head))
+		require.Equal(t, 1, head)
+		_, err = db.Exec(`SELECT COUNT(*) FROM rb_b`)
+		require.Error(t, err)
+
+		// The reverted versions run again, checksums included.
+		err = migrations.ApplyMigrations(t.Context(), db, migs, checked...)
+		require.NoError(t, err)
+		var rows int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM rb_c`).Scan(&rows))
+		require.Equal(t, 1, rows)
+	})
+
+	t.Run("apply one migration at a time", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		migs := []string{`CREATE TABLE step_a (id INT)`, `CREATE TABLE step_b (id INT)`}
+
+		for want := 1; want <= len(migs); want++ {
+			applied, err := migrations.ApplyOne(t.Context(), db, migs, opts...)
+			require.NoError(t, err)
+			require.Equal(t, want, applied)
+			version, err := migrations.CurrentVersion(t.Context(), db, opts...)
+			require.NoError(t, err)
+			require.Equal(t, int64(want), version)
+		}
+		applied, err := migrations.ApplyOne(t.Context(), db, migs, opts...)
+		require.NoError(t, err)
+		require.Zero(t, applied)
+	})
+
+	t.Run("environment variables are interpolated", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		t.Setenv("MIGRATIONS_ENV_TABLE", "env_items")
+		interpolated := append(opts[:len(opts):len(opts)], migrations.WithEnvAllowlist("MIGRATIONS_ENV_TABLE"))
+		migs := []string{`CREATE TABLE ${MIGRATIONS_ENV_TABLE} (id INT)`, `INSERT INTO ${MIGRATIONS_ENV_TABLE} (id) VALUES (1)`}
+
+		err := migrations.Apply(t.Context(), db, migs, opts...)
+		require.Error(t, err)
+		err = migrations.Apply(t.Context(), db, []string{`SELECT '${HOME}'`}, interpolated...)
+		require.ErrorIs(t, err, migrations.ErrEnvNotAllowed)
+		err = migrations.Apply(t.Context(), db, migs, interpolated...)
+		require.NoError(t, err)
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM env_items`).Scan(&n))
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("post-apply verification", func(t *testing.T) {
+		db := openDB(t, "sqlite", dsn, resetSQLite)
+		verify := append(opts[:len(opts):len(opts)], migrations.WithPostApplyVerification(true))
+		migs := []string{`SELECT 1`, `SELECT 2`}
+
+		err := migrations.Apply(t.Context(), db, migs[:1], verify...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, verify...)
+		require.NoError(t, err)
+		err = migrations.Apply(t.Context(), db, migs, verify...)
+		require.NoError(t, err)
+	})
+
+	t.Run("build preview database", func(t *testing.T) {
+		dir := t.TempDir()
+		migs := []string{`CREATE TABLE preview_items (id INTEGER PRIMARY KEY)`}
+
+		dsn, cleanup, err := migrations.BuildPreview(t.Context(), "sqlite", dir, migs, opts...)
+		require.NoError(t, err)
+
+		db, err := sql.Open("sqlite", dsn)
+		require.NoError(t, err)
+		var n int
+		err = db.QueryRow(`SELECT COUNT(*) FROM preview_items`).Scan(&n)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		require.NoError(t, cleanup())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
 	t.Run("connection is invalid", func(t *testing.T) {
 		badDB, err := sql.Open("sqlite", ":memory:")
 		require.NoError(t, err)
@@ -124,3 +507,28 @@ func TestSQLite_NoCGO(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func BenchmarkApply_NoCGO(b *testing.B) {
+	for _, n := range []int{1000, 5000} {
+		migs := make([]string, n)
+		for i := range migs {
+			migs[i] = `SELECT 1`
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for range b.N {
+				b.StopTimer()
+				db, err := sql.Open("sqlite", ":memory:")
+				require.NoError(b, err)
+				db.SetMaxOpenConns(1)
+				b.StartTimer()
+
+				err = migrations.Apply(context.Background(), db, migs)
+				require.NoError(b, err)
+
+				b.StopTimer()
+				require.NoError(b, db.Close())
+				b.StartTimer()
+			}
+		})
+	}
+}
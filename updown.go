@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ParseMigration splits the content of a migration file written for goose or
+// sql-migrate into its Up and Down sections; Version and Name are left empty:
+//
+//	-- +goose Up
+//	CREATE TABLE users (id INT);
+//
+//	-- +goose Down
+//	DROP TABLE users;
+//
+// Both "-- +goose Up/Down" and "-- +migrate Up/Down" annotations are
+// understood. Content without any annotation is returned as UpSQL unchanged.
+// StatementBegin/StatementEnd annotations are dropped: the statement splitter
+// already keeps dollar-quoted and BEGIN...END bodies together. Annotations
+// that ask for a migration to run outside of a transaction are rejected, since
+// Apply runs every migration in one.
+func ParseMigration(content string) (Migration, error) {
+	lines := strings.SplitAfter(content, "\n")
+	if !slices.ContainsFunc(lines, isMigrationAnnotation) {
+		return Migration{UpSQL: content}, nil
+	}
+
+	var up, down strings.Builder
+	var section *strings.Builder
+	seen := map[string]bool{}
+	for i, line := range lines {
+		tool, args, ok := migrationAnnotation(line)
+		if !ok {
+			if section != nil {
+				section.WriteString(line)
+			} else if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return Migration{}, fmt.Errorf("line %d: statement outside of an Up or Down section", i+1)
+			}
+			continue
+		}
+
+		annotation := "-- +" + tool + " " + strings.Join(args, " ")
+		switch directive := strings.ToLower(strings.Join(args, " ")); directive {
+		case "up", "down":
+			if seen[directive] {
+				return Migration{}, fmt.Errorf("line %d: duplicate %s section", i+1, annotation)
+			}
+			seen[directive] = true
+			section = &up
+			if directive == "down" {
+				section = &down
+			}
+		case "statementbegin", "statementend":
+			// The splitter keeps function and trigger bodies together on its own.
+		case "no transaction", "up notransaction", "down notransaction":
+			return Migration{}, fmt.Errorf("line %d: %s is not supported: migrations always run in a transaction", i+1, annotation)
+		default:
+			return Migration{}, fmt.Errorf("line %d: unknown annotation %s", i+1, annotation)
+		}
+	}
+	return Migration{UpSQL: up.String(), DownSQL: down.String()}, nil
+}
+
+// migrationAnnotation reports whether line is a "-- +goose ..." or
+// "-- +migrate ..." annotation and returns the tool name and its arguments.
+func migrationAnnotation(line string) (tool string, args []string, ok bool) {
+	rest, found := strings.CutPrefix(strings.TrimSpace(line), "--")
+	if !found {
+		return "", nil, false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) < 2 || (fields[0] != "+goose" && fields[0] != "+migrate") {
+		return "", nil, false
+	}
+	return fields[0][1:], fields[1:], true
+}
+
+func isMigrationAnnotation(line string) bool {
+	_, _, ok := migrationAnnotation(line)
+	return ok
+}
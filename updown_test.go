@@ -0,0 +1,109 @@
+package migrations
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseMigration(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    Migration
+	}{
+		{
+			name:    "plain file",
+			content: "CREATE TABLE t (id INT);\n",
+			want:    Migration{UpSQL: "CREATE TABLE t (id INT);\n"},
+		},
+		{
+			name: "goose",
+			content: "-- leading comment\n" +
+				"-- +goose Up\n" +
+				"CREATE TABLE t (id INT);\n" +
+				"\n" +
+				"-- +goose Down\n" +
+				"DROP TABLE t;\n",
+			want: Migration{UpSQL: "CREATE TABLE t (id INT);\n\n", DownSQL: "DROP TABLE t;\n"},
+		},
+		{
+			name: "sql-migrate down first",
+			content: "-- +migrate Down\n" +
+				"DROP TABLE t;\n" +
+				"-- +migrate Up\n" +
+				"CREATE TABLE t (id INT);",
+			want: Migration{UpSQL: "CREATE TABLE t (id INT);", DownSQL: "DROP TABLE t;\n"},
+		},
+		{
+			name: "statement blocks",
+			content: "-- +goose Up\n" +
+				"-- +goose StatementBegin\n" +
+				"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;\n" +
+				"-- +goose StatementEnd\n",
+			want: Migration{UpSQL: "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseMigration(tc.content)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"statement before section", "SELECT 1;\n-- +goose Up\nSELECT 2;\n", "line 1: statement outside of an Up or Down section"},
+		{"duplicate section", "-- +goose Up\nSELECT 1;\n-- +goose Up\n", "line 3: duplicate -- +goose Up section"},
+		{"no transaction", "-- +goose NO TRANSACTION\n-- +goose Up\n", "-- +goose NO TRANSACTION is not supported"},
+		{"notransaction", "-- +migrate Up notransaction\n", "-- +migrate Up notransaction is not supported"},
+		{"unknown", "-- +goose Up\n-- +goose ENVSUB ON\n", "line 2: unknown annotation -- +goose ENVSUB ON"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseMigration(tc.content)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/00001_users.sql": {Data: []byte("-- +goose Up\nCREATE TABLE users (id INT);\n-- +goose Down\nDROP TABLE users;\n")},
+		"m/00002_index.sql": {Data: []byte("CREATE INDEX i ON users (id);\n")},
+	}
+
+	got, err := LoadFS(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "users", UpSQL: "CREATE TABLE users (id INT);\n", DownSQL: "DROP TABLE users;\n"},
+		{Version: 2, Name: "index", UpSQL: "CREATE INDEX i ON users (id);\n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	up, err := FromFS(fsys, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(up, []string{want[0].UpSQL, want[1].UpSQL}) {
+		t.Fatalf("FromFS returned %q", up)
+	}
+
+	fsys["m/00003_bad.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\n-- +goose Up\n")}
+	if _, err := FromFS(fsys, "m"); err == nil || !strings.Contains(err.Error(), `migration file "00003_bad.sql": line 2`) {
+		t.Fatalf("got error %v", err)
+	}
+}
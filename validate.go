@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pechorka/migrations/pkg/utils"
+)
+
+// MigrationError reports a problem Validate found in one migration.
+type MigrationError struct {
+	// Version is the version of the migration.
+	Version int
+	// Statement is the 1-based number of the statement within the migration
+	// (0: unknown).
+	Statement int
+	// Line is the 1-based line within the migration (0: unknown).
+	Line int
+	// Err describes the problem.
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration #%d: %v", e.Version, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks migrations without touching a database. It fails when the
+// dialect is unsupported or when a migration cannot be split cleanly, e.g. it
+// ends inside an unterminated string, comment, or dollar-quoted block.
+//
+// Validate checks every migration instead of stopping at the first problem,
+// which speeds up cleaning up an imported legacy directory. The returned
+// error joins one *MigrationError per problem (see errors.Join); its message
+// lists them one per line.
+func Validate(migrations []string, dialect Dialect) error {
+	if !IsValidDialect(dialect) {
+		return fmt.Errorf("dialect %d is not supported", dialect)
+	}
+	var problems []error
+	for version, migration := range migrations {
+		version++ // so first version is 1 instead of 0
+		if _, err := utils.SplitStatementsStrictFlavor(migration, dialects[dialect].flavor); err != nil {
+			problem := &MigrationError{Version: version, Err: err}
+			var split *utils.SplitError
+			if errors.As(err, &split) {
+				problem.Statement, problem.Line = split.Statement, split.Line
+			}
+			problems = append(problems, problem)
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// MustValidate is like Validate but panics on failure. It is meant for
+// package-level variables or init functions so that broken embedded migrations
+// fail unit tests instead of production startup:
+//
+//	var migs = migrations.MustValidate([]string{...}, migrations.DialectPostgres)
+//
+// It returns migrations unchanged for convenience.
+func MustValidate(migrations []string, dialect Dialect) []string {
+	if err := Validate(migrations, dialect); err != nil {
+		panic("migrations: invalid migrations: " + err.Error())
+	}
+	return migrations
+}
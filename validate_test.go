@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		migs := []string{"CREATE TABLE t (s TEXT); INSERT INTO t VALUES ('a;b')"}
+		if err := Validate(migs, DialectSqlite); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("reports migration and line", func(t *testing.T) {
+		migs := []string{"SELECT 1", "SELECT 1;\nINSERT INTO t VALUES ('a)"}
+		err := Validate(migs, DialectPostgres)
+		want := "migration #2: unterminated single-quoted string starting at line 2"
+		if err == nil || err.Error() != want {
+			t.Fatalf("got %v, want %q", err, want)
+		}
+	})
+
+	t.Run("invalid dialect", func(t *testing.T) {
+		if err := Validate(nil, Dialect(42)); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestMustValidate(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "migration #1") {
+			t.Fatalf("unexpected panic value: %v", r)
+		}
+	}()
+	MustValidate([]string{"SELECT /* oops"}, DialectSqlite)
+}
+
+func TestValidateCollectsAllProblems(t *testing.T) {
+	migs := []string{
+		"SELECT 'a",
+		"SELECT 1",
+		"SELECT 1;\nSELECT 2;\n/* never closed",
+	}
+	err := Validate(migs, DialectSqlite)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var got []MigrationError
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var problem *MigrationError
+		if !errors.As(e, &problem) {
+			t.Fatalf("unexpected error %T: %v", e, e)
+		}
+		got = append(got, MigrationError{Version: problem.Version, Statement: problem.Statement, Line: problem.Line})
+	}
+	want := []MigrationError{{Version: 1, Statement: 1, Line: 1}, {Version: 3, Statement: 3, Line: 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	wantMsg := "migration #1: unterminated single-quoted string starting at line 1\nmigration #3: unterminated block comment starting at line 3"
+	if err.Error() != wantMsg {
+		t.Fatalf("got %q, want %q", err, wantMsg)
+	}
+}
@@ -0,0 +1,182 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrPendingMigrations is returned by Verify when some migrations have not
+// been applied yet. The concrete error is a *PendingMigrationsError listing
+// their versions.
+var ErrPendingMigrations = errors.New("pending migrations")
+
+// ErrNoMigrations is returned by CurrentVersion when no migration has been
+// applied, including when the bookkeeping table does not exist.
+var ErrNoMigrations = errors.New("no migrations applied")
+
+// PendingMigrationsError reports migrations that are not applied yet. It
+// matches ErrPendingMigrations with errors.Is.
+type PendingMigrationsError struct {
+	Pending []int
+	// Names holds the name of every pending version, "" when it has none; nil
+	// when the names are unknown (Verify).
+	Names []string
+}
+
+func (e *PendingMigrationsError) Error() string {
+	if !slices.ContainsFunc(e.Names, func(name string) bool { return name != "" }) {
+		return fmt.Sprintf("%s: versions %v are not applied", ErrPendingMigrations, e.Pending)
+	}
+	versions := make([]string, len(e.Pending))
+	for i, version := range e.Pending {
+		versions[i] = strconv.Itoa(version)
+		if i < len(e.Names) && e.Names[i] != "" {
+			versions[i] += " (" + e.Names[i] + ")"
+		}
+	}
+	return fmt.Sprintf("%s: versions %s are not applied", ErrPendingMigrations, strings.Join(versions, ", "))
+}
+
+func (e *PendingMigrationsError) Unwrap() error {
+	return ErrPendingMigrations
+}
+
+// Verify checks that every migration has been applied without changing the
+// database: it neither creates the bookkeeping table nor takes any lock. It
+// returns a *PendingMigrationsError when migrations are missing, e.g. to fail
+// a readiness probe or a CI step against a deployed database:
+//
+//	var pending *migrations.PendingMigrationsError
+//	if errors.As(migrations.Verify(ctx, db, migs), &pending) {
+//		log.Printf("not applied: %v", pending.Pending)
+//	}
+func Verify(ctx context.Context, db *sql.DB, migrations []string, userOptions ...Option) error {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return err
+	}
+	return verify(ctx, db, len(migrations), nil, opts)
+}
+
+// VerifyMigrations works like Verify for Migration values, e.g. from LoadFS,
+// and also reports the names of the pending migrations:
+//
+//	pending migrations: versions 4 (add_email), 5 (backfill) are not applied
+func VerifyMigrations(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) error {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return err
+	}
+	if _, err := upSQL(migrations, opts.Dialect); err != nil {
+		return err
+	}
+	names := make([]string, len(migrations))
+	for i, m := range migrations {
+		names[i] = m.Name
+	}
+	return verify(ctx, db, len(migrations), names, opts)
+}
+
+// verify checks that all n migrations are applied; names, when not nil, holds
+// the name of every migration.
+func verify(ctx context.Context, db *sql.DB, n int, names []string, opts Options) error {
+	lastAppliedVersion, err := readHead(ctx, db, dialects[opts.Dialect], opts.TableName)
+	if err != nil {
+		return err
+	}
+
+	if lastAppliedVersion >= n {
+		return nil
+	}
+	pending := &PendingMigrationsError{}
+	for version := lastAppliedVersion + 1; version <= n; version++ {
+		pending.Pending = append(pending.Pending, version)
+	}
+	if names != nil {
+		pending.Names = names[lastAppliedVersion:]
+	}
+	return pending
+}
+
+// Pending returns the migrations Apply would run, in order and with their
+// Version set, without changing the database, e.g. for ops tooling deciding
+// whether a deploy needs a maintenance window:
+//
+//	pending, err := migrations.Pending(ctx, db, migs)
+//	if err != nil { ... }
+//	for _, m := range pending {
+//		log.Printf("pending: #%d %s", m.Version, m.Name)
+//	}
+//
+// Like Verify, it neither creates the bookkeeping table nor takes any lock, so
+// another process may apply some of them in the meantime.
+func Pending(ctx context.Context, db *sql.DB, migrations []Migration, userOptions ...Option) ([]Migration, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := upSQL(migrations, opts.Dialect); err != nil {
+		return nil, err
+	}
+	lastAppliedVersion, err := readHead(ctx, db, dialects[opts.Dialect], opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for version := lastAppliedVersion + 1; version <= len(migrations); version++ {
+		m := migrations[version-1]
+		m.Version = int64(version)
+		pending = append(pending, m)
+	}
+	return pending, nil
+}
+
+// CurrentVersion returns the last applied version without changing the
+// database, so health checks and startup guards need not know the table
+// layout:
+//
+//	version, err := migrations.CurrentVersion(ctx, db)
+//	if errors.Is(err, migrations.ErrNoMigrations) {
+//		// fresh database
+//	}
+//
+// It returns 0 and ErrNoMigrations when nothing was applied yet.
+func CurrentVersion(ctx context.Context, db *sql.DB, userOptions ...Option) (int64, error) {
+	opts, err := buildOptions(userOptions)
+	if err != nil {
+		return 0, err
+	}
+	lastAppliedVersion, err := readHead(ctx, db, dialects[opts.Dialect], opts.TableName)
+	if err != nil {
+		return 0, err
+	}
+	if lastAppliedVersion == 0 {
+		return 0, ErrNoMigrations
+	}
+	return int64(lastAppliedVersion), nil
+}
+
+// readHead returns the last applied version without changing the database: 0
+// when the bookkeeping table does not exist or is empty.
+func readHead(ctx context.Context, db *sql.DB, d dialect, table string) (int, error) {
+	var existingTables int
+	if err := db.QueryRowContext(ctx, d.rebind(d.tableExists), table).Scan(&existingTables); err != nil {
+		return 0, fmt.Errorf("failed to check if migrations table %q exists: %w", table, err)
+	}
+	if existingTables == 0 {
+		return 0, nil
+	}
+
+	var lastAppliedVersion int
+	queryLast := "SELECT COALESCE(MAX(version), 0) FROM " + d.quoteIdent(table)
+	if err := db.QueryRowContext(ctx, queryLast).Scan(&lastAppliedVersion); err != nil {
+		return 0, fmt.Errorf("failed to read last applied migration version: %w", err)
+	}
+	return lastAppliedVersion, nil
+}
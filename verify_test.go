@@ -0,0 +1,131 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/migrations/pkg/migrationsmock"
+)
+
+func TestVerify(t *testing.T) {
+	migs := []string{"SELECT 1", "SELECT 2", "SELECT 3"}
+	for _, tc := range []struct {
+		name        string
+		tables      int
+		lastApplied int
+		want        []int
+	}{
+		{name: "no bookkeeping table", tables: 0, want: []int{1, 2, 3}},
+		{name: "partially applied", tables: 1, lastApplied: 1, want: []int{2, 3}},
+		{name: "up to date", tables: 1, lastApplied: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("sqlite_master", []string{"count"}, []any{tc.tables})
+			rec.Return("MAX(version)", []string{"max"}, []any{tc.lastApplied})
+
+			err := Verify(context.Background(), db, migs)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var pending *PendingMigrationsError
+			if !errors.As(err, &pending) || !errors.Is(err, ErrPendingMigrations) {
+				t.Fatalf("expected PendingMigrationsError, got %v", err)
+			}
+			if !reflect.DeepEqual(pending.Pending, tc.want) {
+				t.Fatalf("got pending %v, want %v", pending.Pending, tc.want)
+			}
+			for _, q := range rec.Queries() {
+				if q == migrationsmock.Begin || strings.Contains(q, "CREATE") {
+					t.Fatalf("verify must not modify the database, got %q", rec.Queries())
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyMigrations(t *testing.T) {
+	migs := []Migration{{Name: "create_users", UpSQL: "SELECT 1"}, {Name: "add_email", UpSQL: "SELECT 2"}, {UpSQL: "SELECT 3"}}
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+	rec.Return("sqlite_master", []string{"count"}, []any{1})
+	rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+	err := VerifyMigrations(context.Background(), db, migs)
+	var pending *PendingMigrationsError
+	if !errors.As(err, &pending) || !errors.Is(err, ErrPendingMigrations) {
+		t.Fatalf("expected PendingMigrationsError, got %v", err)
+	}
+	if !reflect.DeepEqual(pending.Pending, []int{2, 3}) || !reflect.DeepEqual(pending.Names, []string{"add_email", ""}) {
+		t.Fatalf("got pending %v, names %q", pending.Pending, pending.Names)
+	}
+	if want := "pending migrations: versions 2 (add_email), 3 are not applied"; err.Error() != want {
+		t.Fatalf("got error %q, want %q", err, want)
+	}
+
+	if err := VerifyMigrations(context.Background(), db, migs[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Verify(context.Background(), db, []string{"SELECT 1", "SELECT 2"}); err == nil || err.Error() != "pending migrations: versions [2] are not applied" {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestPending(t *testing.T) {
+	migs := []Migration{{Name: "create_users", UpSQL: "SELECT 1"}, {Name: "add_email", UpSQL: "SELECT 2"}, {Name: "backfill", UpSQL: "SELECT 3"}}
+	db, rec := migrationsmock.DB()
+	defer db.Close()
+	rec.Return("sqlite_master", []string{"count"}, []any{1})
+	rec.Return("MAX(version)", []string{"max"}, []any{1})
+
+	pending, err := Pending(context.Background(), db, migs)
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	want := []Migration{{Version: 2, Name: "add_email", UpSQL: "SELECT 2"}, {Version: 3, Name: "backfill", UpSQL: "SELECT 3"}}
+	if !reflect.DeepEqual(pending, want) {
+		t.Fatalf("got %+v, want %+v", pending, want)
+	}
+	for _, q := range rec.Queries() {
+		if !strings.HasPrefix(q, "SELECT") {
+			t.Fatalf("expected read-only queries, got %q", rec.Queries())
+		}
+	}
+
+	if _, err := Pending(context.Background(), db, []Migration{{Version: 2, UpSQL: "SELECT 1"}}); err == nil {
+		t.Fatal("expected an error for a misplaced version")
+	}
+}
+
+func TestCurrentVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		tables      int
+		lastApplied int
+		want        int64
+		wantErr     error
+	}{
+		{name: "no bookkeeping table", tables: 0, wantErr: ErrNoMigrations},
+		{name: "only the lock row", tables: 1, lastApplied: 0, wantErr: ErrNoMigrations},
+		{name: "applied", tables: 1, lastApplied: 3, want: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, rec := migrationsmock.DB()
+			defer db.Close()
+			rec.Return("sqlite_master", []string{"count"}, []any{tc.tables})
+			rec.Return("MAX(version)", []string{"max"}, []any{tc.lastApplied})
+
+			got, err := CurrentVersion(context.Background(), db)
+			if got != tc.want || !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got %d, %v; want %d, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}